@@ -8,5 +8,6 @@ require (
 	github.com/google/martian v2.1.0+incompatible
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.3.0 // indirect
+	golang.org/x/crypto v0.0.0-20190411191339-88737f569e3a
 	golang.org/x/sys v0.0.0-20190412213103-97732733099d
 )
@@ -24,10 +24,12 @@ const readNameReply = "05"
 const writeNameReply = "06"
 const readEventLogReply = "07"
 const clearEventLogReply = "08"
+const abortReply = "09"
 const readSlotCountReply = "0E"
 const readSlotInfoReply = "0F"
 const readSlotDataReply = "10"
 const eraseSlotDataReply = "12"
+const rebootReply = "13"
 
 var readSlotDataReplyBytes = []byte(readSlotDataReply)
 var readEventLogReplyBytes = []byte(readEventLogReply)
@@ -370,6 +372,7 @@ func NewSlotInfoReply(d []byte) (*SlotInfoReply, error) {
 	return &SlotInfoReply{
 		Time:           stringToInt(t[4:12]),
 		Slot:           stringToInt(t[12:16]),
+		Dwords:         stringToInt(t[16:20]),
 		Bytes:          stringToInt(t[16:20]) * 4,
 		SampleRate:     stringToFloat32(t[20:28]),
 		Temperature:    stringToInt(t[28:32]),
@@ -395,7 +398,7 @@ func ProcessRS232SettingsReply(d []byte) (*RS232SettingsReply, error) {
 		return nil, fmt.Errorf("incorrect response")
 	}
 	tokens := strings.Split(string(b[1]), ",")
-	if len(tokens) < 3 {
+	if len(tokens) < 5 {
 		return nil, fmt.Errorf("unknown response")
 	}
 
@@ -426,9 +429,76 @@ func ProcessRS232SettingsReply(d []byte) (*RS232SettingsReply, error) {
 		return nil, errors.Wrap(err, "Parity conversion error")
 	}
 
+	if len(tokens) > 5 {
+		rsRply.ChangeAfterConfirm, err = strconv.Atoi(tokens[5])
+		if err != nil {
+			return nil, errors.Wrap(err, "ChangeAfterConfirm conversion error")
+		}
+	}
+
 	return &rsRply, nil
 }
 
+// splitOutATResponses returns the value part of each `prefix` response in `d`, as several
+// responses to the same query are concatenated by WaitForResponse.
+func splitOutATResponses(d []byte, prefix string) ([]string, error) {
+	b := bytes.Split(d, []byte(prefix))
+	if len(b) < 2 {
+		return nil, fmt.Errorf("incorrect response %q", d)
+	}
+	values := []string{}
+	for _, v := range b[1:] {
+		values = append(values, string(v))
+	}
+	return values, nil
+}
+
+// ProcessIntReply parses a response of the form <prefix><value>
+func ProcessIntReply(d []byte, prefix string) (int, error) {
+	values, err := splitOutATResponses(d, prefix)
+	if err != nil {
+		return -1, err
+	}
+	v, err := strconv.Atoi(values[0])
+	if err != nil {
+		return -1, errors.Wrapf(err, "%s conversion error", prefix)
+	}
+	return v, nil
+}
+
+// ProcessParameterListReply parses the responses of the form <prefix><tag>,<value> into a map of tag to value
+func ProcessParameterListReply(d []byte, prefix string) (map[int]int, error) {
+	values, err := splitOutATResponses(d, prefix)
+	if err != nil {
+		return nil, err
+	}
+	params := map[int]int{}
+	for _, v := range values {
+		tokens := strings.Split(v, ",")
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("%s unknown response %q", prefix, v)
+		}
+		tag, err := strconv.Atoi(tokens[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s tag conversion error", prefix)
+		}
+		params[tag], err = strconv.Atoi(tokens[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s value conversion error", prefix)
+		}
+	}
+	return params, nil
+}
+
+// ProcessLocalNameReply returns the module's local name from the +UBTLN response
+func ProcessLocalNameReply(d []byte) (string, error) {
+	values, err := splitOutATResponses(d, localNameResponseString)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(values[0], "\""), nil
+}
+
 // ErrUnexpectedResponse is a type of error that may not be catastrophic - just unexpected
 var ErrUnexpectedResponse = fmt.Errorf("UnexpectedResponse")
 
@@ -464,6 +534,88 @@ func ProcessEraseSlotDataReply(d []byte) error {
 	return err
 }
 
+// ProcessSetTimeReply checks the reply to a SetTime command sent with `opcode`
+func ProcessSetTimeReply(d []byte, opcode []byte) error {
+	_, err := splitOutResponse(d, opcodeReply(opcode))
+	return err
+}
+
+// ProcessAbortReply checks the reply to an abort command
+func ProcessAbortReply(d []byte) error {
+	// the aborted download's own indication may arrive ahead of the reply
+	err := fmt.Errorf("incorrect response")
+	for _, indication := range bytes.Split(d, gattIndicationResponse)[1:] {
+		_, err = splitOutResponse(append(append([]byte{}, gattIndicationResponse...), indication...), abortReply)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ProcessRebootReply checks the reply to a sensor reboot command
+func ProcessRebootReply(d []byte) error {
+	_, err := splitOutResponse(d, rebootReply)
+	return err
+}
+
+// ProcessDFUStartReply returns the image offset the sensor already holds, a little-endian uint32
+// after the status
+func ProcessDFUStartReply(d []byte, opcode []byte) (int, error) {
+	t, err := splitOutResponse(d, opcodeReply(opcode))
+	if err != nil {
+		return 0, err
+	}
+	if len(t) < 12 {
+		return 0, fmt.Errorf("[ProcessDFUStartReply] reply %s is too short", t)
+	}
+	return stringToInt(t[4:12]), nil
+}
+
+// ProcessDFUDataReply returns the image offset the sensor expects next, laid out as for DFUStart
+func ProcessDFUDataReply(d []byte, opcode []byte) (int, error) {
+	t, err := splitOutResponse(d, opcodeReply(opcode))
+	if err != nil {
+		return 0, err
+	}
+	if len(t) < 12 {
+		return 0, fmt.Errorf("[ProcessDFUDataReply] reply %s is too short", t)
+	}
+	return stringToInt(t[4:12]), nil
+}
+
+// ProcessDFUVerifyReply returns ErrDFUVerify if the sensor rejected the image
+func ProcessDFUVerifyReply(d []byte, opcode []byte) error {
+	_, err := splitOutResponse(d, opcodeReply(opcode))
+	if err != nil {
+		return errors.Wrapf(ErrDFUVerify, "[ProcessDFUVerifyReply] %s", d)
+	}
+	return nil
+}
+
+// ProcessChangePasswordReply checks the reply to a ChangePassword command sent with `opcode`
+func ProcessChangePasswordReply(d []byte, opcode []byte) error {
+	_, err := splitOutResponse(d, opcodeReply(opcode))
+	return err
+}
+
+// ProcessSlotDataIndication parses the indication that ends a slot download, which carries the
+// notification count
+func ProcessSlotDataIndication(d []byte) (*SlotSummary, error) {
+	t := string(d)
+	if !strings.HasPrefix(t, readSlotDataReply) || len(t) < 8 {
+		return nil, fmt.Errorf("[ProcessSlotDataIndication] unexpected indication %q", d)
+	}
+	s := &SlotSummary{
+		Status:        t[2:4],
+		Notifications: stringToInt(t[4:8]),
+	}
+	if s.Status != statusOk {
+		return nil, fmt.Errorf("[ProcessSlotDataIndication] status %s", s.Status)
+	}
+	return s, nil
+}
+
 // ProcessSlotsReply returns a count of available slots.
 func ProcessSlotsReply(d []byte) (int, error) {
 	// +UUBTGI:0,13,10012603
@@ -492,3 +644,111 @@ func ProcessRSSIReply(d []byte) (string, error) {
 	}
 	return string(b[1]), nil
 }
+
+// ProcessSecurityModeReply returns the mode and type from the +UBTSM response
+func ProcessSecurityModeReply(d []byte) (int, int, error) {
+	values, err := splitOutATResponses(d, securityModeResponseString)
+	if err != nil {
+		return -1, -1, err
+	}
+	tokens := strings.Split(values[0], ",")
+	mode, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		return -1, -1, errors.Wrap(err, "[ProcessSecurityModeReply] mode conversion error")
+	}
+	securityType := 0
+	if len(tokens) > 1 {
+		securityType, err = strconv.Atoi(tokens[1])
+		if err != nil {
+			return -1, -1, errors.Wrap(err, "[ProcessSecurityModeReply] type conversion error")
+		}
+	}
+	return mode, securityType, nil
+}
+
+// ProcessPHYReply parses the +UBTLEPHYR:<handle>,<tx>,<rx> response
+func ProcessPHYReply(d []byte) (int, int, int, error) {
+	values, err := splitOutATResponses(d, blePHYRequestResponseString)
+	if err != nil {
+		return -1, -1, -1, err
+	}
+	v, err := splitInts(values[0], 3)
+	if err != nil {
+		return -1, -1, -1, errors.Wrap(err, "[ProcessPHYReply] error")
+	}
+	return v[0], v[1], v[2], nil
+}
+
+// ProcessPHYUpdateEvent parses the +UUBTLEPHYU:<handle>,<status>,<tx>,<rx> event
+func ProcessPHYUpdateEvent(d []byte) (*PHYUpdate, error) {
+	values, err := splitOutATResponses(d, blePHYUpdateResponseString)
+	if err != nil {
+		return nil, err
+	}
+	v, err := splitInts(values[0], 4)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ProcessPHYUpdateEvent] error")
+	}
+	return &PHYUpdate{Handle: v[0], Status: v[1], TxPHY: PHY(v[2]), RxPHY: PHY(v[3])}, nil
+}
+
+// ProcessConnectionStatusReply parses the +UBTCST:<handle>,<property>,<value> responses into a
+// map of property to value
+func ProcessConnectionStatusReply(d []byte) (map[int]int, error) {
+	values, err := splitOutATResponses(d, connectionStatusResponseString)
+	if err != nil {
+		return nil, err
+	}
+	status := map[int]int{}
+	for _, value := range values {
+		v, err := splitInts(value, 3)
+		if err != nil {
+			return nil, errors.Wrap(err, "[ProcessConnectionStatusReply] error")
+		}
+		status[v[1]] = v[2]
+	}
+	return status, nil
+}
+
+// splitInts converts the first `n` comma separated values of `s`
+func splitInts(s string, n int) ([]int, error) {
+	tokens := strings.Split(s, ",")
+	if len(tokens) < n {
+		return nil, fmt.Errorf("expected %d values in %q", n, s)
+	}
+	v := make([]int, n)
+	for i := range v {
+		var err error
+		v[i], err = strconv.Atoi(tokens[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "value %d of %q", i, s)
+		}
+	}
+	return v, nil
+}
+
+// ProcessAddressIntEvent parses an event of the form <prefix><address>[,<value>], value is -1
+// when the event has none
+func ProcessAddressIntEvent(d []byte, prefix []byte) (string, int, error) {
+	b := bytes.Split(d, prefix)
+	if len(b) < 2 || len(b[1]) == 0 {
+		return "", -1, fmt.Errorf("incorrect event %q", d)
+	}
+	tokens := strings.Split(string(b[1]), ",")
+	if len(tokens) < 2 {
+		return tokens[0], -1, nil
+	}
+	v, err := strconv.Atoi(tokens[1])
+	if err != nil {
+		return "", -1, errors.Wrapf(err, "%s conversion error", prefix)
+	}
+	return tokens[0], v, nil
+}
+
+// ProcessBondedDevicesReply returns the addresses listed in the +UBTBD responses
+func ProcessBondedDevicesReply(d []byte) ([]string, error) {
+	if len(d) == 0 {
+		return []string{}, nil
+	}
+	return splitOutATResponses(d, bondedDevicesResponseString)
+}
@@ -1,5 +1,7 @@
 package ubloxbluetooth
 
+import "time"
+
 // DiscoveryReply BLE discovery structure
 type DiscoveryReply struct {
 	BluetoothAddress string
@@ -24,6 +26,27 @@ type ConnectionReply struct {
 	Handle           int
 	Type             int
 	BluetoothAddress string
+	// Link is updated by ReadLinkParameters and PHY updates, use UbloxBluetooth.LinkParameters
+	// for a copy while connected
+	Link LinkParameters
+}
+
+// LinkParameters are the negotiated parameters of a connection, zero values are not yet known
+type LinkParameters struct {
+	Interval           time.Duration
+	Latency            int
+	SupervisionTimeout time.Duration
+	MTU                int
+	TxPHY              PHY
+	RxPHY              PHY
+}
+
+// PHYUpdate is the +UUBTLEPHYU event, a Status other than zero is a failed update
+type PHYUpdate struct {
+	Handle int
+	Status int
+	TxPHY  PHY
+	RxPHY  PHY
 }
 
 // VersionReply VEH sensor version structure
@@ -58,8 +81,11 @@ type SlotCountReply struct {
 
 // SlotInfoReply holds the current data returned for Info
 type SlotInfoReply struct {
-	Time           int
-	Slot           int
+	Time int
+	Slot int
+	// Dwords is the slot's length as the sensor gives it, Bytes is the same length in bytes.
+	// Dwords is zero in an info recorded before it was kept.
+	Dwords         int
 	Bytes          int
 	SampleRate     float32
 	Temperature    int
@@ -1,38 +1,237 @@
 package serial
 
 import (
-	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
-
-	"github.com/pkg/errors"
 )
 
 var PathNotFound = fmt.Errorf("Port not found")
 var FormatError = fmt.Errorf("Format Error")
 
-// GetFTDIDevPath gets the current dev path that the USB device is connected to,
-// This is based on `/proc/tty/driver/usbserial` containing info in the format of:
-// 0: module:ftdi_sio name:"FTDI USB Serial Device" vendor:0403 product:6015 num_ports:1 port:0 path:usb-0000:00:14.0-1.2.2
-// or
-// 1: module:ftdi_sio name:"FTDI USB Serial Device" vendor:0403 product:6015 num_ports:1 port:0 path:usb-0000:00:14.0-1.2
-func GetFTDIDevPath() (string, error) {
-	usbserial, err := os.Open("/proc/tty/driver/usbserial")
+// SysfsRoot and DevRoot are where device discovery looks for sysfs and device nodes, they can be
+// changed when these are mounted elsewhere.
+var SysfsRoot = "/sys"
+var DevRoot = "/dev"
+
+// USBID is a USB vendor and product ID pair as four digit hex strings, an empty ProductID
+// matches any product from the vendor.
+type USBID struct {
+	VendorID  string
+	ProductID string
+}
+
+// KnownAdapters are the USB serial adapters found on u-blox dongles and evaluation boards
+var KnownAdapters = []USBID{
+	{VendorID: "0403", ProductID: "6001"}, // FTDI FT232R
+	{VendorID: "0403", ProductID: "6010"}, // FTDI FT2232
+	{VendorID: "0403", ProductID: "6014"}, // FTDI FT232H
+	{VendorID: "0403", ProductID: "6015"}, // FTDI FT-X
+	{VendorID: "10c4", ProductID: "ea60"}, // Silicon Labs CP210x
+	{VendorID: "1546", ProductID: ""},     // u-blox
+}
+
+// DeviceInfo describes a USB serial device found in sysfs
+type DeviceInfo struct {
+	Name         string
+	Path         string
+	ByIDPath     string
+	Driver       string
+	VendorID     string
+	ProductID    string
+	SerialNumber string
+	Manufacturer string
+	Product      string
+}
+
+// DeviceFilter selects devices, empty fields match anything. Path may be the device node or
+// a /dev/serial/by-id link.
+type DeviceFilter struct {
+	Path         string
+	VendorID     string
+	ProductID    string
+	SerialNumber string
+}
+
+// IsKnownAdapter returns true if the device uses one of the KnownAdapters
+func (d DeviceInfo) IsKnownAdapter() bool {
+	for _, id := range KnownAdapters {
+		if strings.EqualFold(d.VendorID, id.VendorID) && (id.ProductID == "" || strings.EqualFold(d.ProductID, id.ProductID)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the device passes the filter
+func (f DeviceFilter) Matches(d DeviceInfo) bool {
+	if f.Path != "" && resolvePath(f.Path) != resolvePath(d.Path) {
+		return false
+	}
+	if f.VendorID != "" && !strings.EqualFold(f.VendorID, d.VendorID) {
+		return false
+	}
+	if f.ProductID != "" && !strings.EqualFold(f.ProductID, d.ProductID) {
+		return false
+	}
+	return f.SerialNumber == "" || f.SerialNumber == d.SerialNumber
+}
+
+func resolvePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return resolved
+}
+
+func readAttribute(dir string, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// usbDeviceDir walks up from a tty's device directory to the USB device that owns it
+func usbDeviceDir(dir string) (string, bool) {
+	root := filepath.Clean(SysfsRoot)
+	for dir != root && dir != filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir, true
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "", false
+}
+
+// byIDLinks maps device nodes to their /dev/serial/by-id links
+func byIDLinks() map[string]string {
+	links := map[string]string{}
+	dir := filepath.Join(DevRoot, "serial", "by-id")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return links
+	}
+	for _, e := range entries {
+		link := filepath.Join(dir, e.Name())
+		links[resolvePath(link)] = link
+	}
+	return links
+}
+
+// ListSerialDevices walks /sys/class/tty and returns every USB serial device, sorted by name.
+func ListSerialDevices() ([]DeviceInfo, error) {
+	classDir := filepath.Join(SysfsRoot, "class", "tty")
+	entries, err := ioutil.ReadDir(classDir)
 	if err != nil {
-		return "", errors.Wrap(err, "error opening serial driver list")
+		return nil, fmt.Errorf("[ListSerialDevices] error reading %s: %v", classDir, err)
+	}
+
+	links := byIDLinks()
+	devices := []DeviceInfo{}
+	for _, e := range entries {
+		deviceDir, err := filepath.EvalSymlinks(filepath.Join(classDir, e.Name(), "device"))
+		if err != nil {
+			continue
+		}
+		usbDir, ok := usbDeviceDir(deviceDir)
+		if !ok {
+			continue
+		}
+
+		d := DeviceInfo{
+			Name:         e.Name(),
+			Path:         filepath.Join(DevRoot, e.Name()),
+			VendorID:     readAttribute(usbDir, "idVendor"),
+			ProductID:    readAttribute(usbDir, "idProduct"),
+			SerialNumber: readAttribute(usbDir, "serial"),
+			Manufacturer: readAttribute(usbDir, "manufacturer"),
+			Product:      readAttribute(usbDir, "product"),
+		}
+		driver, err := filepath.EvalSymlinks(filepath.Join(deviceDir, "driver"))
+		if err == nil {
+			d.Driver = filepath.Base(driver)
+		}
+		d.ByIDPath = links[resolvePath(d.Path)]
+		devices = append(devices, d)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices, nil
+}
+
+// ListUbloxDevices returns the USB serial devices that use one of the KnownAdapters
+func ListUbloxDevices() ([]DeviceInfo, error) {
+	devices, err := ListSerialDevices()
+	if err != nil {
+		return nil, err
+	}
+	known := []DeviceInfo{}
+	for _, d := range devices {
+		if d.IsKnownAdapter() {
+			known = append(known, d)
+		}
 	}
-	defer usbserial.Close()
+	return known, nil
+}
 
-	scanner := bufio.NewScanner(usbserial)
+// FindDevice returns the first device that matches the filter. An empty filter matches the
+// first of the KnownAdapters. A Path that is not a USB device, such as a board's own UART, is
+// returned as it is if it exists.
+func FindDevice(filter DeviceFilter) (*DeviceInfo, error) {
+	var devices []DeviceInfo
+	var err error
+	if filter == (DeviceFilter{}) {
+		devices, err = ListUbloxDevices()
+	} else {
+		devices, err = ListSerialDevices()
+	}
+	if err != nil && filter.Path == "" {
+		return nil, err
+	}
 
-	for scanner.Scan() {
-		tokens := strings.Split(scanner.Text(), " ")
-		index := strings.Split(tokens[0], ":")
-		module := strings.Split(tokens[1], ":")
-		if module[1] == "ftdi_sio" {
-			return fmt.Sprintf("/dev/ttyUSB%s", index[0]), nil
+	for _, d := range devices {
+		if filter.Matches(d) {
+			return &d, nil
+		}
+	}
+
+	if filter.Path != "" && filter.VendorID == "" && filter.ProductID == "" && filter.SerialNumber == "" {
+		if _, err := os.Stat(filter.Path); err == nil {
+			return &DeviceInfo{
+				Name: filepath.Base(resolvePath(filter.Path)),
+				Path: filter.Path,
+			}, nil
+		}
+	}
+	return nil, PathNotFound
+}
+
+// GetFTDIDevPath returns the device node of the first FTDI serial device
+func GetFTDIDevPath() (string, error) {
+	devices, err := ListSerialDevices()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		if d.Driver == "ftdi_sio" {
+			return d.Path, nil
 		}
 	}
 	return "", PathNotFound
 }
+
+// GetDevPath returns the device node of the first known u-blox adapter
+func GetDevPath() (string, error) {
+	d, err := FindDevice(DeviceFilter{})
+	if err != nil {
+		return "", err
+	}
+	return d.Path, nil
+}
@@ -0,0 +1,52 @@
+package serial
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDeviceGone is the cause of the error sent by ScanPort when the device is unplugged
+var ErrDeviceGone = fmt.Errorf("serial device gone")
+
+// ErrWatchStopped is returned by a DeviceWatcher that is stopped before the device appears
+var ErrWatchStopped = fmt.Errorf("device watch stopped")
+
+// IsDeviceGone returns true if err was caused by the device being removed
+func IsDeviceGone(err error) bool {
+	return err != nil && errors.Cause(err) == ErrDeviceGone
+}
+
+// DeviceWatcher waits for a device matching `filter` to be present, returning ErrWatchStopped
+// if `stop` is closed first.
+type DeviceWatcher interface {
+	WaitForDevice(filter DeviceFilter, stop <-chan struct{}) (*DeviceInfo, error)
+}
+
+// DefaultWatchInterval is how often a PollingWatcher with no Interval looks for the device
+const DefaultWatchInterval = time.Second
+
+// PollingWatcher is a DeviceWatcher that checks sysfs with FindDevice every Interval
+type PollingWatcher struct {
+	Interval time.Duration
+}
+
+// WaitForDevice polls until a device matching `filter` is found or `stop` is closed
+func (w PollingWatcher) WaitForDevice(filter DeviceFilter, stop <-chan struct{}) (*DeviceInfo, error) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	for {
+		d, err := FindDevice(filter)
+		if err == nil {
+			return d, nil
+		}
+		select {
+		case <-stop:
+			return nil, ErrWatchStopped
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package serial
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+// MaxEDMPayload is the largest payload an EDM frame can carry, the top four bits of the
+// length field are reserved and must be zero.
+const MaxEDMPayload = 0x0FFF
+
+const edmLengthReservedMask = 0xF000
+
+// EDMStats counts the work done by an EDMDecoder
+type EDMStats struct {
+	// Frames is the number of good frames decoded
+	Frames uint64
+	// DroppedFrames is the number of candidate frames rejected for a bad length or stop byte
+	DroppedFrames uint64
+	// DroppedBytes is the number of bytes skipped while looking for a start byte
+	DroppedBytes uint64
+}
+
+// EDMDecoder extracts Extended Data Mode frames from a byte stream. A frame with an invalid
+// length or stop byte is not trusted: only its start byte is dropped and the bytes that follow
+// are rescanned, so a corrupted byte costs at most the frame it is in.
+type EDMDecoder struct {
+	ring          *ringBuffer
+	maxPayload    int
+	frames        uint64
+	droppedFrames uint64
+	droppedBytes  uint64
+}
+
+// NewEDMDecoder returns a decoder which rejects frames with more than `maxPayload` bytes of
+// payload. Values outside 1 to MaxEDMPayload are replaced with MaxEDMPayload.
+func NewEDMDecoder(maxPayload int) *EDMDecoder {
+	return newEDMDecoder(newRingBuffer(2*(MaxEDMPayload+EDMPayloadOverhead)), maxPayload)
+}
+
+func newEDMDecoder(ring *ringBuffer, maxPayload int) *EDMDecoder {
+	if maxPayload <= 0 || maxPayload > MaxEDMPayload {
+		maxPayload = MaxEDMPayload
+	}
+	return &EDMDecoder{
+		ring:       ring,
+		maxPayload: maxPayload,
+	}
+}
+
+// Write buffers as much of p as there is room for and returns the number of bytes taken,
+// call Next until it returns nil to make room.
+func (d *EDMDecoder) Write(p []byte) int {
+	return d.ring.Write(p)
+}
+
+// Buffered returns the number of bytes waiting to be decoded
+func (d *EDMDecoder) Buffered() int {
+	return d.ring.Len()
+}
+
+// Stats returns the decoder's counters, it may be called from any goroutine.
+func (d *EDMDecoder) Stats() EDMStats {
+	return EDMStats{
+		Frames:        atomic.LoadUint64(&d.frames),
+		DroppedFrames: atomic.LoadUint64(&d.droppedFrames),
+		DroppedBytes:  atomic.LoadUint64(&d.droppedBytes),
+	}
+}
+
+func (d *EDMDecoder) drop(n int) {
+	d.ring.Discard(n)
+	atomic.AddUint64(&d.droppedBytes, uint64(n))
+}
+
+// Next returns the next complete frame without the start byte and length, but with the stop
+// byte. Both return values are nil when more data is needed. After an error the decoder has
+// already resynchronised and Next can be called again.
+func (d *EDMDecoder) Next() ([]byte, error) {
+	start := d.ring.IndexByte(EDMStartByte, 0)
+	if start == -1 {
+		d.drop(d.ring.Len())
+		return nil, nil
+	}
+	d.drop(start)
+
+	if d.ring.Len() < EDMHeaderSize {
+		return nil, nil
+	}
+
+	header := d.ring.Peek(EDMHeaderSize)
+	length := binary.BigEndian.Uint16(header[1:3])
+	if length&edmLengthReservedMask != 0 || int(length) > d.maxPayload {
+		d.drop(1)
+		atomic.AddUint64(&d.droppedFrames, 1)
+		return nil, fmt.Errorf("EDM error invalid payload length %d", length)
+	}
+
+	expectedLength := int(length) + EDMPayloadOverhead
+	if d.ring.Len() < expectedLength {
+		return nil, nil
+	}
+
+	if d.ring.At(expectedLength-1) != EDMStopByte {
+		d.drop(1)
+		atomic.AddUint64(&d.droppedFrames, 1)
+		return nil, fmt.Errorf("EDM error missing stop byte (Length: %d)", length)
+	}
+
+	line := d.ring.Peek(expectedLength)
+	d.ring.Discard(expectedLength)
+	atomic.AddUint64(&d.frames, 1)
+	showMsg("EDM R: %s\n[%x]", line, line)
+	return line[EDMHeaderSize:], nil
+}
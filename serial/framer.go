@@ -0,0 +1,61 @@
+package serial
+
+import "fmt"
+
+// framer splits the bytes buffered from the serial port into EDM frames or CR LF terminated lines
+type framer struct {
+	ring             *ringBuffer
+	edm              *EDMDecoder
+	extendedDataMode bool
+}
+
+func newFramer(size int) *framer {
+	ring := newRingBuffer(size)
+	return &framer{
+		ring:             ring,
+		edm:              newEDMDecoder(ring, MaxEDMPayload),
+		extendedDataMode: true,
+	}
+}
+
+// next returns the next complete frame. Both return values are nil when more data is needed,
+// after an error the offending bytes have been dropped and next can be called again.
+// EDM frames are returned without the start byte and length, but with the stop byte.
+func (f *framer) next() ([]byte, error) {
+	if f.extendedDataMode {
+		return f.edm.Next()
+	}
+	return f.nextLine()
+}
+
+func (f *framer) nextLine() ([]byte, error) {
+	for {
+		end := f.indexCRLF()
+		if end == -1 {
+			if f.ring.Free() == 0 {
+				f.ring.Discard(f.ring.Len())
+				return nil, fmt.Errorf("line exceeds buffer (Length: %d)", f.ring.Cap())
+			}
+			return nil, nil
+		}
+
+		line := f.ring.Peek(end + 1)
+		f.ring.Discard(end + 1)
+		if len(line) > 2 {
+			showMsg("R: \"%s\"\n[%x]", line, line)
+			return line, nil
+		}
+	}
+}
+
+// indexCRLF returns the offset of the LF of the first CR LF pair, or -1
+func (f *framer) indexCRLF() int {
+	from := 1
+	for {
+		lf := f.ring.IndexByte(newlineBytes[1], from)
+		if lf == -1 || f.ring.At(lf-1) == newlineBytes[0] {
+			return lf
+		}
+		from = lf + 1
+	}
+}
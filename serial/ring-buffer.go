@@ -0,0 +1,98 @@
+package serial
+
+// ringBuffer is a fixed size byte FIFO. The serial port reads straight into its free space
+// and the framer consumes complete frames from the front.
+type ringBuffer struct {
+	buf    []byte
+	start  int
+	length int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{
+		buf: make([]byte, size),
+	}
+}
+
+// Len returns the number of buffered bytes
+func (r *ringBuffer) Len() int {
+	return r.length
+}
+
+// Free returns the space left in the buffer
+func (r *ringBuffer) Free() int {
+	return len(r.buf) - r.length
+}
+
+// Cap returns the size of the buffer
+func (r *ringBuffer) Cap() int {
+	return len(r.buf)
+}
+
+// writeSlice returns the contiguous free space following the buffered bytes, call commit with
+// the number of bytes written into it.
+func (r *ringBuffer) writeSlice() []byte {
+	end := (r.start + r.length) % len(r.buf)
+	if r.length == len(r.buf) {
+		return r.buf[end:end]
+	}
+	if end < r.start {
+		return r.buf[end:r.start]
+	}
+	return r.buf[end:]
+}
+
+func (r *ringBuffer) commit(n int) {
+	r.length += n
+}
+
+// Write copies as much of p as will fit and returns the number of bytes copied
+func (r *ringBuffer) Write(p []byte) int {
+	written := 0
+	for len(p) > 0 && r.Free() > 0 {
+		n := copy(r.writeSlice(), p)
+		r.commit(n)
+		p = p[n:]
+		written += n
+	}
+	return written
+}
+
+// At returns the byte at offset i from the front
+func (r *ringBuffer) At(i int) byte {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// Peek returns a copy of the n bytes at the front
+func (r *ringBuffer) Peek(n int) []byte {
+	b := make([]byte, n)
+	end := r.start + n
+	if end > len(r.buf) {
+		end = len(r.buf)
+	}
+	first := copy(b, r.buf[r.start:end])
+	copy(b[first:], r.buf[:n-first])
+	return b
+}
+
+// Discard drops n bytes from the front
+func (r *ringBuffer) Discard(n int) {
+	if n > r.length {
+		n = r.length
+	}
+	r.start = (r.start + n) % len(r.buf)
+	r.length -= n
+	if r.length == 0 {
+		r.start = 0
+	}
+}
+
+// IndexByte returns the offset of the first c at or after `from`, or -1
+func (r *ringBuffer) IndexByte(c byte, from int) int {
+	for i := from; i < r.length; i++ {
+		if r.At(i) == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -1,12 +1,11 @@
 package serial
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -30,11 +29,19 @@ func showMsg(format string, v ...interface{}) {
 
 // SerialPort holds the file and file descriptor for the serial port
 type SerialPort struct {
+	path             string
 	file             *os.File
 	fd               uintptr
-	extendedDataMode bool
-	contineScanning  bool
+	extendedDataMode int32
 	isOpen           bool
+	baudRate         BaudRate
+	noFlowControl    bool
+	stop             chan struct{}
+	stopOnce         sync.Once
+	wake             [2]int
+	scanning         int32
+	scanDone         chan struct{}
+	framer           *framer
 }
 
 // BaudRate is a type used for enumerating the permissible rates in our system.
@@ -47,11 +54,52 @@ const (
 	HighSpeed BaudRate = unix.B1000000
 )
 
-// OpenSerialPort opens the Ublox device with a timeout value
+var baudRates = map[int]BaudRate{
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	1500000: unix.B1500000,
+	2000000: unix.B2000000,
+	3000000: unix.B3000000,
+}
+
+// BaudRateFromInt returns the BaudRate for a rate in bits per second
+func BaudRateFromInt(rate int) (BaudRate, error) {
+	br, ok := baudRates[rate]
+	if !ok {
+		return 0, fmt.Errorf("unsupported baud rate %d", rate)
+	}
+	return br, nil
+}
+
+// Int returns the rate in bits per second, or zero if it is not one of the supported rates
+func (br BaudRate) Int() int {
+	for rate, b := range baudRates {
+		if b == br {
+			return rate
+		}
+	}
+	return 0
+}
+
+// OpenSerialPort opens the first u-blox device found with a timeout value
 func OpenSerialPort(readTimeout time.Duration) (p *SerialPort, err error) {
-	devPath, err := GetFTDIDevPath()
-	if err != nil {
-		return nil, err
+	return OpenSerialPortPath("", readTimeout)
+}
+
+// OpenSerialPortPath opens the device at `devPath`, or the first u-blox device found when
+// `devPath` is empty, with a timeout value
+func OpenSerialPortPath(devPath string, readTimeout time.Duration) (p *SerialPort, err error) {
+	if devPath == "" {
+		devPath, err = GetDevPath()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	f, err := os.OpenFile(devPath, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0666)
@@ -73,21 +121,40 @@ func OpenSerialPort(readTimeout time.Duration) (p *SerialPort, err error) {
 		return nil, fmt.Errorf("[OpenSerialPort] set non block error: %v", err)
 	}
 
+	wake := [2]int{}
+	err = unix.Pipe2(wake[:], unix.O_NONBLOCK|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("[OpenSerialPort] wake pipe error: %v", err)
+	}
+
 	sp := &SerialPort{
+		path:             devPath,
 		file:             f,
 		fd:               fd,
-		extendedDataMode: true,
-		contineScanning:  true,
+		extendedDataMode: 1,
 		isOpen:           true,
+		stop:             make(chan struct{}),
+		wake:             wake,
+		scanDone:         make(chan struct{}),
+		framer:           newFramer(readBufferSize),
 	}
 
 	sp.SetBaudRate(HighSpeed, readTimeout)
 	return sp, nil
 }
 
+// Path returns the device path that the serial port was opened with
+func (sp *SerialPort) Path() string {
+	return sp.path
+}
+
 // SetEDMFlag is set when we leave AT mode.
 func (sp *SerialPort) SetEDMFlag(flag bool) {
-	sp.extendedDataMode = flag
+	v := int32(0)
+	if flag {
+		v = 1
+	}
+	atomic.StoreInt32(&sp.extendedDataMode, v)
 }
 
 // SetBaudRate sets the serialport's speed to the passed value
@@ -95,10 +162,13 @@ func (sp *SerialPort) SetBaudRate(baudrate BaudRate, readTimeout time.Duration)
 	br := uint32(baudrate)
 	t := unix.Termios{
 		Iflag:  unix.IGNPAR,
-		Cflag:  unix.CREAD | unix.CLOCAL | unix.IGNCR | br | unix.CS8 | unix.CRTSCTS,
+		Cflag:  unix.CREAD | unix.CLOCAL | unix.IGNCR | br | unix.CS8,
 		Ispeed: br,
 		Ospeed: br,
 	}
+	if !sp.noFlowControl {
+		t.Cflag |= unix.CRTSCTS
+	}
 
 	t.Cc[unix.VMIN] = uint8(0x00)
 	t.Cc[unix.VTIME] = uint8(readTimeout.Nanoseconds() / 1e6 / 100)
@@ -116,9 +186,31 @@ func (sp *SerialPort) SetBaudRate(baudrate BaudRate, readTimeout time.Duration)
 	if errno != 0 {
 		return fmt.Errorf("[OpenPort] ioctl error: %d", errno)
 	}
+	sp.baudRate = baudrate
 	return nil
 }
 
+// SetFlowControl turns RTS/CTS flow control, which is on when the port is opened, on or off
+func (sp *SerialPort) SetFlowControl(enabled bool, readTimeout time.Duration) error {
+	previous := sp.noFlowControl
+	sp.noFlowControl = !enabled
+	err := sp.SetBaudRate(sp.baudRate, readTimeout)
+	if err != nil {
+		sp.noFlowControl = previous
+	}
+	return err
+}
+
+// FlowControl returns true when RTS/CTS flow control is on
+func (sp *SerialPort) FlowControl() bool {
+	return !sp.noFlowControl
+}
+
+// BaudRate returns the rate that the serial port was last set to
+func (sp *SerialPort) BaudRate() BaudRate {
+	return sp.baudRate
+}
+
 // Write write's the passed byte array to the serial port
 func (sp *SerialPort) Write(b []byte) error {
 	showMsg("W: %s\n[%x]", b, b)
@@ -131,75 +223,119 @@ const EDMStopByte = byte(0x55)
 const EDMPayloadOverhead = 4
 const EDMHeaderSize = 3
 
+// readBufferSize holds the largest possible EDM frame, twice over
+const readBufferSize = 2 * (MaxEDMPayload + EDMPayloadOverhead)
+
+// pollTimeout bounds how long ScanPort waits in poll before rechecking for a stop
+const pollTimeout = 500
+
+// StopScanning ends ScanPort, it is safe to call more than once.
 func (sp *SerialPort) StopScanning() {
-	sp.contineScanning = false
+	sp.stopOnce.Do(func() {
+		close(sp.stop)
+		unix.Write(sp.wake[1], []byte{0})
+	})
+}
+
+func (sp *SerialPort) send(ch chan []byte, b []byte) bool {
+	select {
+	case ch <- b:
+		return true
+	case <-sp.stop:
+		return false
+	}
+}
+
+func (sp *SerialPort) sendError(errChan chan error, err error) bool {
+	select {
+	case errChan <- err:
+		return true
+	case <-sp.stop:
+		return false
+	}
 }
 
-// ScanLines reads a complete line from the serial port and sends the bytes
-// to the passed channel
+// ScanPort waits for the serial port to become readable, reads whatever is available into a
+// ring buffer, and sends each complete line to `dataChan`, or in extended data mode each EDM
+// frame to `edmChan`. It returns when StopScanning is called or the port fails.
 func (sp *SerialPort) ScanPort(dataChan chan []byte, edmChan chan []byte, errChan chan error) {
-	sp.contineScanning = true
-	line := []byte{}
-	lineLen := 0
-	expectedLength := -1
-	edmStartReceived := false
-	buf := make([]byte, 1)
-	for sp.contineScanning == true {
-		_, err := sp.file.Read(buf)
+	atomic.StoreInt32(&sp.scanning, 1)
+	defer close(sp.scanDone)
 
+	f := sp.framer
+	fds := []unix.PollFd{
+		{Fd: int32(sp.fd), Events: unix.POLLIN},
+		{Fd: int32(sp.wake[0]), Events: unix.POLLIN},
+	}
+	for {
+		select {
+		case <-sp.stop:
+			return
+		default:
+		}
+
+		fds[0].Revents = 0
+		fds[1].Revents = 0
+		_, err := unix.Poll(fds, pollTimeout)
 		if err != nil {
-			if err == io.EOF { // ignore EOFs we're going to get them all the time.
+			if err == unix.EINTR {
 				continue
-			} else {
-				if sp.isOpen {
-					errChan <- errors.Wrap(err, "serial read error")
-				} else {
-					fmt.Printf("[ScanPort] Read error %v\n", err)
-				}
-				break
 			}
+			sp.sendError(errChan, errors.Wrap(err, "serial poll error"))
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+		if fds[0].Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 {
+			sp.sendError(errChan, errors.Wrapf(ErrDeviceGone, "%s hung up (events %x)", sp.path, fds[0].Revents))
+			return
+		}
+		if fds[0].Revents&unix.POLLIN == 0 {
+			continue
 		}
 
-		if sp.extendedDataMode {
-			if !edmStartReceived {
-				if buf[0] == EDMStartByte {
-					edmStartReceived = true
-				}
+		n, err := unix.Read(int(sp.fd), f.ring.writeSlice())
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
 			}
-			if edmStartReceived {
-				line = append(line, buf[0])
-				lineLen = len(line)
-
-				if expectedLength == -1 && lineLen == 3 {
-					expectedLength = int(binary.BigEndian.Uint16(line[1:3])) + EDMPayloadOverhead
-				} else if lineLen == expectedLength {
-					if line[expectedLength-1] == EDMStopByte {
-						showMsg("EDM R: %s\n[%x]", line, line)
-						edmChan <- line[EDMHeaderSize:expectedLength]
-						line = []byte{}
-						expectedLength = -1
-						edmStartReceived = false
-					} else {
-						errChan <- fmt.Errorf("EDM errof Payload length exceeded (Length: %d %x)", expectedLength, line)
-						line = []byte{}
-						expectedLength = -1
-						edmStartReceived = false
-					}
-				}
+			if err == unix.EIO || err == unix.ENODEV || err == unix.ENXIO {
+				sp.sendError(errChan, errors.Wrapf(ErrDeviceGone, "%s read error (%v)", sp.path, err))
+				return
 			}
-		} else {
-			line = append(line, buf[0])
-			lineLen = len(line)
-			if bytes.HasSuffix(line, newlineBytes) {
-				if lineLen > 2 {
-					showMsg("R: \"%s\"\n[%x]", buf, buf)
-					dataChan <- line
+			sp.sendError(errChan, errors.Wrap(err, "serial read error"))
+			return
+		}
+		f.ring.commit(n)
+
+		for {
+			f.extendedDataMode = atomic.LoadInt32(&sp.extendedDataMode) == 1
+			frame, err := f.next()
+			if err != nil {
+				if !sp.sendError(errChan, err) {
+					return
 				}
-				line = []byte{}
+				continue
+			}
+			if frame == nil {
+				break
+			}
+
+			ch := dataChan
+			if f.extendedDataMode {
+				ch = edmChan
+			}
+			if !sp.send(ch, frame) {
+				return
 			}
 		}
 	}
-	fmt.Print("[ScanPort] CATASTROPHIC ERROR")
+}
+
+// EDMStats returns the EDM decoder's frame and error counters
+func (sp *SerialPort) EDMStats() EDMStats {
+	return sp.framer.edm.Stats()
 }
 
 // Ioctl sends
@@ -278,9 +414,18 @@ func (sp *SerialPort) ResetViaDTR() error {
 	return nil
 }
 
-// Close closes the file
+// Close stops ScanPort, waiting for it to finish, and closes the file
 func (sp *SerialPort) Close() (err error) {
+	if !sp.isOpen {
+		return nil
+	}
+	sp.StopScanning()
+	if atomic.LoadInt32(&sp.scanning) == 1 {
+		<-sp.scanDone
+	}
 	err = sp.file.Close()
+	unix.Close(sp.wake[0])
+	unix.Close(sp.wake[1])
 	sp.isOpen = false
 	return err
 }
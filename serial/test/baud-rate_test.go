@@ -0,0 +1,25 @@
+package serial
+
+import (
+	"testing"
+
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+)
+
+func TestBaudRateFromInt(t *testing.T) {
+	br, err := serial.BaudRateFromInt(1000000)
+	if err != nil {
+		t.Fatalf("BaudRateFromInt error %v\n", err)
+	}
+	if br != serial.HighSpeed {
+		t.Errorf("1000000 should be HighSpeed")
+	}
+	if serial.Default.Int() != 115200 {
+		t.Errorf("Default rate is %d", serial.Default.Int())
+	}
+
+	_, err = serial.BaudRateFromInt(12345)
+	if err == nil {
+		t.Errorf("expected unsupported baud rate error")
+	}
+}
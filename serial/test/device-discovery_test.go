@@ -0,0 +1,147 @@
+package serial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+)
+
+type fakeUSBDevice struct {
+	tty       string
+	driver    string
+	port      string
+	vendorID  string
+	productID string
+	serial    string
+	byID      string
+}
+
+// makeFakeSysfs builds enough of sysfs and /dev under `root` for device discovery
+func makeFakeSysfs(t *testing.T, root string, devices []fakeUSBDevice) {
+	mkdir := func(dir string) {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("mkdir error %v", err)
+		}
+	}
+	write := func(file string, value string) {
+		err := ioutil.WriteFile(file, []byte(value+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("write error %v", err)
+		}
+	}
+	link := func(target string, name string) {
+		err := os.Symlink(target, name)
+		if err != nil {
+			t.Fatalf("symlink error %v", err)
+		}
+	}
+
+	sys := filepath.Join(root, "sys")
+	dev := filepath.Join(root, "dev")
+	mkdir(filepath.Join(dev, "serial", "by-id"))
+
+	// a non USB tty that discovery must skip
+	mkdir(filepath.Join(sys, "devices", "platform", "serial8250", "tty", "ttyS0"))
+	mkdir(filepath.Join(sys, "class", "tty", "ttyS0"))
+	link(filepath.Join(sys, "devices", "platform", "serial8250"), filepath.Join(sys, "class", "tty", "ttyS0", "device"))
+
+	for _, d := range devices {
+		usbDir := filepath.Join(sys, "devices", "pci0000:00", "usb1", d.port)
+		mkdir(usbDir)
+		write(filepath.Join(usbDir, "idVendor"), d.vendorID)
+		write(filepath.Join(usbDir, "idProduct"), d.productID)
+		write(filepath.Join(usbDir, "serial"), d.serial)
+		write(filepath.Join(usbDir, "manufacturer"), "Test")
+
+		portDir := filepath.Join(usbDir, d.port+":1.0", d.tty)
+		mkdir(portDir)
+		driverDir := filepath.Join(sys, "bus", "usb-serial", "drivers", d.driver)
+		mkdir(driverDir)
+		link(driverDir, filepath.Join(portDir, "driver"))
+
+		mkdir(filepath.Join(sys, "class", "tty", d.tty))
+		link(portDir, filepath.Join(sys, "class", "tty", d.tty, "device"))
+
+		write(filepath.Join(dev, d.tty), "")
+		link(filepath.Join("..", "..", d.tty), filepath.Join(dev, "serial", "by-id", d.byID))
+	}
+}
+
+func TestDeviceDiscovery(t *testing.T) {
+	root, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	makeFakeSysfs(t, root, []fakeUSBDevice{
+		{tty: "ttyUSB0", driver: "cp210x", port: "1-1", vendorID: "10c4", productID: "ea60", serial: "CP01", byID: "usb-Silicon_Labs_CP01-if00-port0"},
+		{tty: "ttyUSB1", driver: "ftdi_sio", port: "1-2", vendorID: "0403", productID: "6015", serial: "FT01", byID: "usb-FTDI_FT01-if00-port0"},
+		{tty: "ttyUSB2", driver: "pl2303", port: "1-3", vendorID: "067b", productID: "2303", serial: "PL01", byID: "usb-Prolific_PL01-if00-port0"},
+	})
+
+	sysfsRoot, devRoot := serial.SysfsRoot, serial.DevRoot
+	serial.SysfsRoot = filepath.Join(root, "sys")
+	serial.DevRoot = filepath.Join(root, "dev")
+	defer func() {
+		serial.SysfsRoot, serial.DevRoot = sysfsRoot, devRoot
+	}()
+
+	devices, err := serial.ListSerialDevices()
+	if err != nil {
+		t.Fatalf("ListSerialDevices error %v", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 USB devices, got %+v", devices)
+	}
+	d := devices[1]
+	if d.Name != "ttyUSB1" || d.Driver != "ftdi_sio" || d.VendorID != "0403" || d.ProductID != "6015" || d.SerialNumber != "FT01" || d.Manufacturer != "Test" {
+		t.Errorf("unexpected device %+v", d)
+	}
+	if d.ByIDPath != filepath.Join(serial.DevRoot, "serial", "by-id", "usb-FTDI_FT01-if00-port0") {
+		t.Errorf("unexpected by-id path %s", d.ByIDPath)
+	}
+
+	known, err := serial.ListUbloxDevices()
+	if err != nil || len(known) != 2 {
+		t.Errorf("expected 2 known adapters, got %+v %v", known, err)
+	}
+
+	path, err := serial.GetFTDIDevPath()
+	if err != nil || path != filepath.Join(serial.DevRoot, "ttyUSB1") {
+		t.Errorf("GetFTDIDevPath returned %s %v", path, err)
+	}
+
+	found, err := serial.FindDevice(serial.DeviceFilter{})
+	if err != nil || found.Name != "ttyUSB0" {
+		t.Errorf("empty filter found %+v %v", found, err)
+	}
+	found, err = serial.FindDevice(serial.DeviceFilter{SerialNumber: "FT01"})
+	if err != nil || found.Name != "ttyUSB1" {
+		t.Errorf("serial number filter found %+v %v", found, err)
+	}
+	found, err = serial.FindDevice(serial.DeviceFilter{VendorID: "067B", ProductID: "2303"})
+	if err != nil || found.Name != "ttyUSB2" {
+		t.Errorf("VID/PID filter found %+v %v", found, err)
+	}
+	found, err = serial.FindDevice(serial.DeviceFilter{Path: filepath.Join(serial.DevRoot, "serial", "by-id", "usb-Prolific_PL01-if00-port0")})
+	if err != nil || found.Name != "ttyUSB2" {
+		t.Errorf("by-id filter found %+v %v", found, err)
+	}
+	_, err = serial.FindDevice(serial.DeviceFilter{SerialNumber: "missing"})
+	if err != serial.PathNotFound {
+		t.Errorf("expected PathNotFound, got %v", err)
+	}
+
+	// an explicit path that is not a USB device is still usable
+	uart := filepath.Join(serial.DevRoot, "ttyS0")
+	ioutil.WriteFile(uart, nil, 0644)
+	found, err = serial.FindDevice(serial.DeviceFilter{Path: uart})
+	if err != nil || found.Path != uart {
+		t.Errorf("explicit path found %+v %v", found, err)
+	}
+}
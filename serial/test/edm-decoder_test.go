@@ -0,0 +1,189 @@
+package serial
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+)
+
+func edmFrame(payload []byte) []byte {
+	f := []byte{serial.EDMStartByte, byte(len(payload) >> 8), byte(len(payload))}
+	f = append(f, payload...)
+	return append(f, serial.EDMStopByte)
+}
+
+// decodeAll feeds `data` through `d` and returns the payloads of the frames found
+func decodeAll(d *serial.EDMDecoder, data []byte) ([][]byte, int) {
+	frames := [][]byte{}
+	errors := 0
+	for len(data) > 0 {
+		n := d.Write(data)
+		data = data[n:]
+		for {
+			f, err := d.Next()
+			if err != nil {
+				errors++
+				continue
+			}
+			if f == nil {
+				break
+			}
+			frames = append(frames, f[:len(f)-1])
+		}
+	}
+	return frames, errors
+}
+
+func TestEDMDecoderSplitFrames(t *testing.T) {
+	d := serial.NewEDMDecoder(0)
+	stream := append(edmFrame([]byte("first")), edmFrame([]byte("second"))...)
+
+	frames := [][]byte{}
+	for _, b := range stream {
+		d.Write([]byte{b})
+		f, err := d.Next()
+		if err != nil {
+			t.Fatalf("Next error %v", err)
+		}
+		if f != nil {
+			frames = append(frames, f)
+		}
+	}
+	if len(frames) != 2 || string(frames[0]) != "first\x55" || string(frames[1]) != "second\x55" {
+		t.Errorf("unexpected frames %q", frames)
+	}
+	if d.Buffered() != 0 {
+		t.Errorf("%d bytes left buffered", d.Buffered())
+	}
+}
+
+func TestEDMDecoderResync(t *testing.T) {
+	good := edmFrame([]byte("good"))
+	// a frame whose length byte was corrupted swallows the frame after it
+	corrupt := edmFrame([]byte("bad"))
+	corrupt[2] = 0x0A
+
+	stream := []byte("garbage")
+	stream = append(stream, corrupt...)
+	stream = append(stream, good...)
+	stream = append(stream, good...)
+
+	d := serial.NewEDMDecoder(0)
+	frames, errors := decodeAll(d, stream)
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames after resync, got %q", frames)
+	}
+	if errors != 1 {
+		t.Errorf("expected 1 error, got %d", errors)
+	}
+
+	stats := d.Stats()
+	if stats.Frames != 2 || stats.DroppedFrames != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.DroppedBytes != uint64(len("garbage")+len(corrupt)) {
+		t.Errorf("expected %d dropped bytes, got %d", len("garbage")+len(corrupt), stats.DroppedBytes)
+	}
+}
+
+func TestEDMDecoderMaxPayload(t *testing.T) {
+	d := serial.NewEDMDecoder(8)
+	stream := append(edmFrame([]byte("too long to pass")), edmFrame([]byte("short"))...)
+	frames, errors := decodeAll(d, stream)
+	if len(frames) != 1 || string(frames[0]) != "short" {
+		t.Errorf("unexpected frames %q", frames)
+	}
+	if errors == 0 {
+		t.Errorf("oversize frame was not reported")
+	}
+
+	// the reserved length bits must be clear
+	d = serial.NewEDMDecoder(0)
+	_, err := func() ([]byte, error) {
+		d.Write([]byte{serial.EDMStartByte, 0x10, 0x00})
+		return d.Next()
+	}()
+	if err == nil {
+		t.Errorf("reserved length bits were accepted")
+	}
+}
+
+// TestEDMDecoderRandomCorruption mixes valid frames with noise, frames with a bad stop byte and
+// frames with an oversize length, and checks that every intact frame is still recovered.
+func TestEDMDecoderRandomCorruption(t *testing.T) {
+	rnd := rand.New(rand.NewSource(34))
+	randomBytes := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			// keep start bytes out of the noise so that no false frame can be built from it
+			for b[i] = byte(rnd.Intn(256)); b[i] == serial.EDMStartByte; b[i] = byte(rnd.Intn(256)) {
+			}
+		}
+		return b
+	}
+
+	for run := 0; run < 200; run++ {
+		stream := []byte{}
+		expected := [][]byte{}
+		for i := 0; i < 20; i++ {
+			payload := randomBytes(rnd.Intn(64))
+			frame := edmFrame(payload)
+			switch rnd.Intn(4) {
+			case 0:
+				stream = append(stream, randomBytes(rnd.Intn(16))...)
+			case 1:
+				for frame[len(frame)-1] == serial.EDMStopByte {
+					frame[len(frame)-1] = randomBytes(1)[0]
+				}
+				stream = append(stream, frame...)
+				continue
+			case 2:
+				frame[1] = byte(1 + rnd.Intn(0x0F))
+				stream = append(stream, frame...)
+				continue
+			}
+			stream = append(stream, frame...)
+			expected = append(expected, payload)
+		}
+
+		frames, _ := decodeAll(serial.NewEDMDecoder(64), stream)
+		if len(frames) != len(expected) {
+			t.Fatalf("run %d: recovered %d of %d frames", run, len(frames), len(expected))
+		}
+		for i := range frames {
+			if !bytes.Equal(frames[i], expected[i]) {
+				t.Fatalf("run %d: frame %d is %x, expected %x", run, i, frames[i], expected[i])
+			}
+		}
+	}
+}
+
+func FuzzEDMDecoder(f *testing.F) {
+	f.Add(edmFrame([]byte("\x00\x41+STARTUP\r\n")))
+	f.Add(append([]byte{0xAA, 0xFF, 0xFF, 0x55}, edmFrame(nil)...))
+	f.Add([]byte{0xAA, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := serial.NewEDMDecoder(64)
+		frames, _ := decodeAll(d, data)
+		for _, frame := range frames {
+			if len(frame) > 64 {
+				t.Fatalf("frame of %d bytes exceeds the maximum", len(frame))
+			}
+		}
+
+		stats := d.Stats()
+		if stats.Frames != uint64(len(frames)) {
+			t.Fatalf("counted %d frames, returned %d", stats.Frames, len(frames))
+		}
+		consumed := uint64(d.Buffered()) + stats.DroppedBytes
+		for _, frame := range frames {
+			consumed += uint64(len(frame)) + serial.EDMPayloadOverhead
+		}
+		if consumed != uint64(len(data)) {
+			t.Fatalf("accounted for %d of %d bytes", consumed, len(data))
+		}
+	})
+}
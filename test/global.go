@@ -1,6 +1,19 @@
 package ubloxbluetooth
 
-import "time"
+import (
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
 
 var timeout = 5 * time.Second
-var password = []byte{'A', 'B', 'C'}
+var password = benchPassword()
+
+// benchPassword is the test sensors' password, from UBLOX_SENSOR_PASSWORD when it is set
+func benchPassword() []byte {
+	p, err := u.EnvCredentials{}.Password("")
+	if err != nil {
+		return []byte{'A', 'B', 'C'}
+	}
+	return p
+}
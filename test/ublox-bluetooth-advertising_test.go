@@ -0,0 +1,125 @@
+package ubloxbluetooth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestIBeaconAdvertisingData(t *testing.T) {
+	d, err := u.IBeaconAdvertisingData("2456e1b9-26e2-8f83-e744-f34f01e9d701", 1, 0x0203, -59)
+	if err != nil {
+		t.Fatalf("IBeaconAdvertisingData error %v\n", err)
+	}
+	expected, _ := hex.DecodeString("1AFF4C0002152456E1B926E28F83E744F34F01E9D70100010203C5")
+	if !bytes.Equal(d, expected) {
+		t.Errorf("iBeacon data is incorrect:\n[%X] should be [%X]", d, expected)
+	}
+
+	_, err = u.IBeaconAdvertisingData("2456e1b9", 1, 2, -59)
+	if err == nil {
+		t.Errorf("expected invalid UUID error")
+	}
+}
+
+func TestEddystoneAdvertisingData(t *testing.T) {
+	namespace, _ := hex.DecodeString("00112233445566778899")
+	instance, _ := hex.DecodeString("AABBCCDDEEFF")
+	d, err := u.EddystoneUIDAdvertisingData(namespace, instance, -20)
+	if err != nil {
+		t.Fatalf("EddystoneUIDAdvertisingData error %v\n", err)
+	}
+	expected, _ := hex.DecodeString("0303AAFE" + "1716AAFE00EC" + "00112233445566778899" + "AABBCCDDEEFF" + "0000")
+	if !bytes.Equal(d, expected) {
+		t.Errorf("Eddystone-UID data is incorrect:\n[%X] should be [%X]", d, expected)
+	}
+
+	d, err = u.EddystoneURLAdvertisingData("https://www.u-blox.com/", -20)
+	if err != nil {
+		t.Fatalf("EddystoneURLAdvertisingData error %v\n", err)
+	}
+	expected, _ = hex.DecodeString("0303AAFE" + "0D16AAFE10EC01" + hex.EncodeToString([]byte("u-blox")) + "00")
+	if !bytes.Equal(d, expected) {
+		t.Errorf("Eddystone-URL data is incorrect:\n[%X] should be [%X]", d, expected)
+	}
+
+	ads, err := u.ParseAdvertisingData(hex.EncodeToString(d))
+	if err != nil || len(ads) != 2 {
+		t.Errorf("Eddystone-URL data does not parse %v %v", ads, err)
+	}
+
+	_, err = u.EddystoneURLAdvertisingData("ftp://example.com", -20)
+	if err == nil {
+		t.Errorf("expected unsupported scheme error")
+	}
+}
+
+func TestConfigureAdvertising(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	data, err := u.EddystoneURLAdvertisingData("https://www.u-blox.com/", -20)
+	if err != nil {
+		t.Fatalf("EddystoneURLAdvertisingData error %v\n", err)
+	}
+
+	err = ub.ConfigureAdvertising(u.AdvertisingOptions{
+		Data:            data,
+		Discoverability: u.GeneralDiscoverable,
+		Connectability:  u.NonConnectable,
+	})
+	if err != nil {
+		t.Errorf("ConfigureAdvertising error %v\n", err)
+	}
+}
+
+func TestConfigureAdvertisingKeepsSettings(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTLECFG?", confirmationFrame("+UBTLECFG:1,64"), confirmationFrame("+UBTLECFG:2,1600"))
+
+	// a central gains the peripheral role, only the maximum interval is changed
+	dongle.reply("AT+UBTLE?", confirmationFrame("+UBTLE:1"))
+	err = ub.ConfigureAdvertising(u.AdvertisingOptions{MaxInterval: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("ConfigureAdvertising error %v", err)
+	}
+	waitForRequest(t, dongle, "AT+UBTLE=3")
+	waitForRequest(t, dongle, "AT+UBTLECFG=1,64")
+	waitForRequest(t, dongle, "AT+UBTLECFG=2,800")
+
+	// a peripheral keeps its role, and the maximum is raised to a minimum above it
+	dongle.reply("AT+UBTLE?", confirmationFrame("+UBTLE:2"))
+	err = ub.ConfigureAdvertising(u.AdvertisingOptions{MinInterval: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("ConfigureAdvertising error %v", err)
+	}
+	for {
+		select {
+		case b := <-dongle.received:
+			if bytes.Contains(b, []byte("AT+UBTLE=")) {
+				t.Errorf("role written for a peripheral: %q", b)
+			}
+			if bytes.Contains(b, []byte("AT+UBTLECFG=2,")) {
+				if !bytes.Contains(b, []byte("AT+UBTLECFG=2,3200")) {
+					t.Errorf("maximum interval written as %q", b)
+				}
+				return
+			}
+		case <-time.After(timeout):
+			t.Fatalf("maximum interval not written")
+		}
+	}
+}
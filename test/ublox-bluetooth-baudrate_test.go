@@ -0,0 +1,30 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNegotiateBaudRate(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	rate, err := ub.DetectBaudRate(nil)
+	if err != nil {
+		t.Fatalf("DetectBaudRate error %v\n", err)
+	}
+	fmt.Printf("Module is at %d\n", rate)
+
+	err = ub.NegotiateBaudRate(115200)
+	if err != nil {
+		t.Fatalf("NegotiateBaudRate error %v\n", err)
+	}
+
+	err = ub.NegotiateBaudRate(rate)
+	if err != nil {
+		t.Errorf("NegotiateBaudRate error %v\n", err)
+	}
+}
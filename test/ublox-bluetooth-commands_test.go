@@ -175,6 +175,67 @@ func TestReboot(t *testing.T) {
 	fmt.Printf("Rebooted")
 }
 
+func TestSensorReboot(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	err = connectToDevice("CE1A0B7E9D79r", func(t *testing.T) error {
+		err := ub.EnableIndications()
+		if err != nil {
+			t.Fatalf("EnableIndications error %v\n", err)
+		}
+		return ub.RebootSensor()
+	}, ub, t)
+	if err != nil {
+		t.Errorf("RebootSensor error %v\n", err)
+	}
+}
+
+func TestAbortSlotRead(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	err = connectToDevice("CE1A0B7E9D79r", func(t *testing.T) error {
+		defer ub.DisconnectFromDevice()
+
+		err := ub.EnableNotifications()
+		if err != nil {
+			t.Fatalf("EnableNotifications error %v\n", err)
+		}
+		err = ub.EnableIndications()
+		if err != nil {
+			t.Fatalf("EnableIndications error %v\n", err)
+		}
+
+		notifications := 0
+		err = ub.DownloadSlotData(0, 0, func(b []byte) error {
+			notifications++
+			if notifications == 4 {
+				return u.ErrAbortDownload
+			}
+			return nil
+		}, func(s string) error {
+			return nil
+		})
+		if err != u.ErrAbortDownload {
+			t.Errorf("DownloadSlotData returned %v\n", err)
+		}
+
+		// the device still answers after the abort
+		_, err = ub.GetInfo()
+		return err
+	}, ub, t)
+	if err != nil {
+		t.Errorf("TestAbortSlotRead error %v\n", err)
+	}
+}
+
 func setupBluetooth() (*u.UbloxBluetooth, error) {
 	ub, err := u.NewUbloxBluetooth(timeout)
 	if err != nil {
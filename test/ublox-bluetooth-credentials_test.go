@@ -0,0 +1,227 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	sc := u.StaticCredentials{Passwords: map[string][]byte{"CE1A0B7E9D79r": []byte("one")}}
+	p, err := sc.Password("ce1a0b7e9d79")
+	if err != nil || string(p) != "one" {
+		t.Errorf("StaticCredentials returned %s %v", p, err)
+	}
+	_, err = sc.Password("D8C4E2A1B3F5r")
+	if errors.Cause(err) != u.ErrNoPassword {
+		t.Errorf("expected ErrNoPassword, got %v", err)
+	}
+
+	os.Setenv("TEST_SENSOR_PASSWORD", "all")
+	os.Setenv("TEST_SENSOR_PASSWORD_CE1A0B7E9D79", "mine")
+	defer os.Unsetenv("TEST_SENSOR_PASSWORD")
+	defer os.Unsetenv("TEST_SENSOR_PASSWORD_CE1A0B7E9D79")
+	ec := u.EnvCredentials{Prefix: "TEST_SENSOR_PASSWORD"}
+	p, _ = ec.Password("CE1A0B7E9D79r")
+	other, _ := ec.Password("D8C4E2A1B3F5r")
+	if string(p) != "mine" || string(other) != "all" {
+		t.Errorf("EnvCredentials returned %s and %s", p, other)
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keystore")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	ks, err := u.OpenKeystore(path, "secret")
+	if err != nil {
+		t.Fatalf("OpenKeystore error %v", err)
+	}
+	ks.Set("CE1A0B7E9D79r", []byte("ABC"))
+	ks.SetPending("CE1A0B7E9D79r", []byte("XYZ"))
+
+	d, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(d), "ABC") || strings.Contains(string(d), "414243") {
+		t.Errorf("the keystore holds the password in the clear: %s", d)
+	}
+	_, err = u.OpenKeystore(path, "wrong")
+	if err == nil {
+		t.Errorf("the keystore opened with the wrong passphrase")
+	}
+
+	ks, err = u.OpenKeystore(path, "secret")
+	if err != nil {
+		t.Fatalf("reopen error %v", err)
+	}
+	p, _ := ks.Password("CE1A0B7E9D79r")
+	pending, ok, _ := ks.Pending("CE1A0B7E9D79r")
+	if string(p) != "ABC" || !ok || string(pending) != "XYZ" {
+		t.Errorf("unexpected keystore contents %s %s %v", p, pending, ok)
+	}
+	ks.Commit("CE1A0B7E9D79r")
+	p, _ = ks.Password("CE1A0B7E9D79r")
+	_, ok, _ = ks.Pending("CE1A0B7E9D79r")
+	if string(p) != "XYZ" || ok {
+		t.Errorf("Commit left %s pending %v", p, ok)
+	}
+}
+
+// lockedSensors checks passwords and can lose the reply to a password change
+type lockedSensors struct {
+	connected   string
+	unlocked    bool
+	passwords   map[string]string
+	loseReply   bool
+	reject      map[string]bool
+	unreachable bool
+}
+
+func (l *lockedSensors) ConnectToDevice(address string, onConnect u.DeviceEvent, onDisconnect u.DeviceEvent) error {
+	if l.unreachable {
+		return fmt.Errorf("Timeout")
+	}
+	l.connected = address
+	l.unlocked = false
+	return onConnect()
+}
+
+func (l *lockedSensors) DisconnectFromDevice() error {
+	l.connected = ""
+	return nil
+}
+
+func (l *lockedSensors) EnableIndications() error { return nil }
+
+func (l *lockedSensors) UnlockDevice(password []byte) (bool, error) {
+	l.unlocked = l.passwords[l.connected] == string(password)
+	return l.unlocked, nil
+}
+
+func (l *lockedSensors) ChangePassword(password []byte) error {
+	if !l.unlocked {
+		return fmt.Errorf("invalid response")
+	}
+	if l.reject[l.connected] {
+		return fmt.Errorf("invalid response")
+	}
+	l.passwords[l.connected] = string(password)
+	if l.loseReply {
+		l.loseReply = false
+		return fmt.Errorf("Timeout")
+	}
+	return nil
+}
+
+func TestRotatePasswords(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keystore")
+	defer os.RemoveAll(dir)
+	ks, _ := u.OpenKeystore(filepath.Join(dir, "keys.json"), "secret")
+
+	sensors := &lockedSensors{
+		passwords: map[string]string{"CE1A0B7E9D79r": "ABC", "D8C4E2A1B3F5r": "ABC", "F344B0C992E1r": "ABC"},
+		reject:    map[string]bool{"F344B0C992E1r": true},
+	}
+	addresses := []string{"CE1A0B7E9D79r", "D8C4E2A1B3F5r", "F344B0C992E1r"}
+	for _, a := range addresses {
+		ks.Set(a, []byte("ABC"))
+	}
+
+	// the reply to the first change is lost, but the sensor did change
+	sensors.loseReply = true
+	results, err := u.RotatePasswords(context.Background(), sensors, ks, addresses, func(address string) ([]byte, error) {
+		return []byte("new-" + address[:4]), nil
+	})
+	if err != nil || len(results) != 3 {
+		t.Fatalf("RotatePasswords returned %v %v", results, err)
+	}
+	if results[0].Err != nil || results[1].Err != nil || results[2].Err == nil {
+		t.Errorf("unexpected results %+v", results)
+	}
+	for _, a := range addresses {
+		p, _ := ks.Password(a)
+		if string(p) != sensors.passwords[a] {
+			t.Errorf("%s keystore has %s, sensor has %s", a, p, sensors.passwords[a])
+		}
+	}
+	_, pending, _ := ks.Pending("F344B0C992E1r")
+	if pending {
+		t.Errorf("the rejected change is still pending")
+	}
+
+	// a change that cannot be confirmed stays pending
+	sensors.loseReply = true
+	sensors.unreachable = false
+	flaky := &unreachableAfterChange{sensors}
+	err = u.RotatePassword(flaky, ks, "D8C4E2A1B3F5r", []byte("third"))
+	p, _ := ks.Password("D8C4E2A1B3F5r")
+	_, pending, _ = ks.Pending("D8C4E2A1B3F5r")
+	if err == nil || string(p) != "new-D8C4" || !pending {
+		t.Errorf("unconfirmed RotatePassword returned %v with %s pending %v", err, p, pending)
+	}
+
+	// and is settled by the next rotation before changing again
+	sensors.unreachable = false
+	err = u.RotatePassword(sensors, ks, "D8C4E2A1B3F5r", []byte("fourth"))
+	p, _ = ks.Password("D8C4E2A1B3F5r")
+	if err != nil || string(p) != "fourth" || sensors.passwords["D8C4E2A1B3F5r"] != "fourth" {
+		t.Errorf("RotatePassword returned %v with %s", err, p)
+	}
+}
+
+// unreachableAfterChange drops off the air once a password change has been sent
+type unreachableAfterChange struct {
+	*lockedSensors
+}
+
+func (l *unreachableAfterChange) ChangePassword(password []byte) error {
+	err := l.lockedSensors.ChangePassword(password)
+	l.unreachable = true
+	return err
+}
+
+func TestSensorSchedulerCredentials(t *testing.T) {
+	fake := &fakeSensors{info: map[string]*u.InfoReply{storeAddress: {}}}
+	sink := &resultRecorder{}
+	s := u.NewSensorScheduler(fake, sink, u.SchedulerOptions{Credentials: u.StaticCredentials{}})
+	s.AddSensor(u.Sensor{Address: storeAddress, Policy: u.SensorPolicy{Fetch: u.FetchInfo}})
+	s.RunDue(context.Background())
+	if len(sink.results) != 1 || errors.Cause(sink.results[0].Err) != u.ErrNoPassword {
+		t.Errorf("expected ErrNoPassword, got %+v", sink.results)
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	counts := map[byte]int{}
+	for i := 0; i < 200; i++ {
+		p, err := u.GeneratePassword(57)
+		if err != nil {
+			t.Fatalf("GeneratePassword error %v", err)
+		}
+		if len(p) != 57 {
+			t.Fatalf("GeneratePassword returned %d characters", len(p))
+		}
+		for _, c := range p {
+			if !strings.ContainsRune(u.PasswordAlphabet, rune(c)) {
+				t.Fatalf("GeneratePassword returned %q outside the alphabet", c)
+			}
+			counts[c]++
+		}
+	}
+	// mapping bytes modulo 57 would draw the first 28 characters 5 times for every 4 of the
+	// others, about 6230 of the 11400 rather than 5600
+	first := 0
+	for _, c := range []byte(u.PasswordAlphabet[:256%len(u.PasswordAlphabet)]) {
+		first += counts[c]
+	}
+	if first < 5300 || first > 5900 {
+		t.Errorf("the first 28 characters were drawn %d times", first)
+	}
+}
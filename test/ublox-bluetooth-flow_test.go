@@ -0,0 +1,272 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestFlowController(t *testing.T) {
+	now := time.Now()
+	opts := u.FlowControlOptions{InitialCredit: 8, MinCredit: 2, MaxCredit: 16, StallTimeout: 100 * time.Millisecond}
+	f := u.NewFlowController(opts, 100, now)
+
+	// credit is topped up once half the window is used
+	granted := 0
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Millisecond)
+		granted += f.Received(20, now)
+	}
+	if granted != 4 {
+		t.Errorf("expected 4 credits after half the window, got %d", granted)
+	}
+
+	// a full window without a stall grows it
+	for i := 0; i < 8; i++ {
+		now = now.Add(time.Millisecond)
+		f.Received(20, now)
+	}
+	s := f.Stats(now)
+	if s.Window != 10 || s.Notifications != 12 || s.Bytes != 240 {
+		t.Errorf("after a full window got %+v", s)
+	}
+	if f.StallTimeout() != opts.StallTimeout {
+		t.Errorf("fast notifications should keep the configured stall timeout, got %v", f.StallTimeout())
+	}
+
+	// a stall halves the window and sends a single credit
+	credit, err := f.Stalled(now.Add(time.Second))
+	if err != nil || credit != 1 || f.Stats(now).Window != 5 {
+		t.Errorf("Stalled got %d %v window %d", credit, err, f.Stats(now).Window)
+	}
+	f.CreditFailed(credit)
+	if f.Stats(now).Window != 2 {
+		t.Errorf("CreditFailed should halve the window to its minimum, got %d", f.Stats(now).Window)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = f.Stalled(now.Add(time.Second))
+	}
+	if err == nil {
+		t.Errorf("expected an error after repeated stalls")
+	}
+	if s := f.Stats(now); s.Stalls != 4 || s.MaxWindow != 10 {
+		t.Errorf("stats after stalls %+v", s)
+	}
+}
+
+func TestFlowControllerDefaults(t *testing.T) {
+	now := time.Now()
+	f := u.NewFlowController(u.FlowControlOptions{}, 200, now)
+	if s := f.Stats(now); s.Window != u.DefaultInitialCredit || s.Window >= u.DefaultMaxCredit {
+		t.Fatalf("the default initial window %d leaves no room to grow", s.Window)
+	}
+
+	// the window grows to the default maximum while notifications keep coming
+	outstanding := u.DefaultInitialCredit
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Millisecond)
+		outstanding += f.Received(20, now) - 1
+		if outstanding > u.DefaultMaxCredit {
+			t.Fatalf("%d credits outstanding beyond the maximum window", outstanding)
+		}
+	}
+	if s := f.Stats(now); s.Window != u.DefaultMaxCredit || s.MaxWindow != u.DefaultMaxCredit {
+		t.Errorf("window did not grow to the default maximum %+v", s)
+	}
+}
+
+func TestFlowControllerNeverOvercredits(t *testing.T) {
+	now := time.Now()
+	f := u.NewFlowController(u.FlowControlOptions{InitialCredit: 16, MaxCredit: 64}, 20, now)
+	granted := 16
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Millisecond)
+		granted += f.Received(10, now)
+	}
+	if granted != 20 {
+		t.Errorf("granted %d credits for 20 notifications", granted)
+	}
+	credit, _ := f.Stalled(now)
+	if credit != 0 || !f.Stats(now).Complete {
+		t.Errorf("a complete download should not be credited, got %d", credit)
+	}
+
+	// a slow sensor keeps its credit through a stall, a lost grant is only replaced once the
+	// sensor has answered the stall's credit, so it never holds more than the window and that credit
+	most, stalls := simulateCredit(t, 0, 0)
+	if most > 9 || stalls != 1 {
+		t.Errorf("a slow sensor held up to %d credits after %d stalls", most, stalls)
+	}
+	most, stalls = simulateCredit(t, 2, -1)
+	if most > 9 || stalls != 2 {
+		t.Errorf("a sensor that lost a grant held up to %d credits after %d stalls", most, stalls)
+	}
+
+	// slow notifications lengthen the stall timeout
+	f = u.NewFlowController(u.FlowControlOptions{StallTimeout: 10 * time.Millisecond}, 20, now)
+	now = now.Add(100 * time.Millisecond)
+	f.Received(10, now)
+	if f.StallTimeout() != 800*time.Millisecond {
+		t.Errorf("expected the stall timeout to follow the notification gap, got %v", f.StallTimeout())
+	}
+}
+
+// simulateCredit downloads 100 notifications with a window of 8, dropping grant `drop` and
+// stalling at notification `slowAt` with credit in hand. It returns the most credit the sensor held.
+func simulateCredit(t *testing.T, drop int, slowAt int) (int, int) {
+	now := time.Now()
+	f := u.NewFlowController(u.FlowControlOptions{InitialCredit: 8, MaxCredit: 8}, 100, now)
+	held, grants, received, most := 8, 0, 0, 8
+	for received < 100 {
+		now = now.Add(time.Millisecond)
+		if held == 0 || received == slowAt {
+			slowAt = -1
+			credit, err := f.Stalled(now)
+			if err != nil {
+				t.Fatalf("Stalled error %v after %d notifications", err, received)
+			}
+			held += credit
+		} else {
+			held--
+			received++
+			if credit := f.Received(10, now); credit > 0 {
+				grants++
+				if grants != drop {
+					held += credit
+				}
+			}
+		}
+		if held > most {
+			most = held
+		}
+	}
+	return most, f.Stats(now).Stalls
+}
+
+// creditSensor answers an event log download, sending a notification for each credit it holds
+type creditSensor struct {
+	lock       sync.Mutex
+	total      int
+	sent       int
+	credit     int
+	dropCredit int
+	grants     []int
+}
+
+var gattWrite = regexp.MustCompile(`0,13,([0-9a-f]+)`)
+
+func (s *creditSensor) download(request string) ([][]byte, [][]byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	params := gattWrite.FindStringSubmatch(request)[1]
+	s.credit = hexByte(params[6:8])
+	reply := eventFrame(fmt.Sprintf("+UUBTGI:0,13,0700%02x%02x", s.total&0xFF, s.total>>8))
+	return [][]byte{reply}, s.flush()
+}
+
+func (s *creditSensor) credits(request string) ([][]byte, [][]byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	credit := hexByte(gattWrite.FindStringSubmatch(request)[1][2:4])
+	s.grants = append(s.grants, credit)
+	if len(s.grants) == s.dropCredit {
+		return nil, nil
+	}
+	s.credit += credit
+	return nil, s.flush()
+}
+
+func (s *creditSensor) flush() [][]byte {
+	frames := [][]byte{}
+	for s.credit > 0 && s.sent < s.total {
+		frames = append(frames, eventFrame(fmt.Sprintf("+UUBTGN:0,16,%04x", s.sent)))
+		s.sent++
+		s.credit--
+	}
+	if s.sent == s.total {
+		frames = append(frames, eventFrame("+UUBTGI:0,13,0700"))
+		s.sent++
+	}
+	return frames
+}
+
+func hexByte(s string) int {
+	v, _ := strconv.ParseUint(s, 16, 8)
+	return int(v)
+}
+
+func TestAdaptiveDownload(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	sensor := &creditSensor{total: 40, dropCredit: 2}
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	dongle.responder("AT+UBTGW=0,13,07", sensor.download)
+	dongle.responder("AT+UBTGW=0,13,11", sensor.credits)
+
+	reports := []u.DownloadStats{}
+	ub.SetFlowControl(u.FlowControlOptions{
+		InitialCredit: 4,
+		MinCredit:     2,
+		MaxCredit:     8,
+		StallTimeout:  50 * time.Millisecond,
+		Report: func(s u.DownloadStats) {
+			reports = append(reports, s)
+		},
+	})
+
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+
+	received := 0
+	err = ub.DownloadEventLog(0, func(b []byte) error {
+		if string(b) != fmt.Sprintf("%04x", received) {
+			return fmt.Errorf("notification %d was %s", received, b)
+		}
+		received++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DownloadEventLog error %v", err)
+	}
+	if received != 40 {
+		t.Errorf("received %d of 40 notifications", received)
+	}
+
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	r := reports[0]
+	if !r.Complete || r.Notifications != 40 || r.Bytes != 160 || r.Stalls != 2 || r.MaxWindow > 8 || r.BytesPerSecond() <= 0 {
+		t.Errorf("report %+v", r)
+	}
+
+	sensor.lock.Lock()
+	total := 4
+	for _, g := range sensor.grants {
+		total += g
+	}
+	sensor.lock.Unlock()
+	// the dropped grant is the only credit beyond the notifications
+	if total-sensor.grants[1] != 40 {
+		t.Errorf("granted %d credits %v for 40 notifications", total, sensor.grants)
+	}
+
+	// the OKs to the credit writes have all been taken
+	err = ub.ATCommand()
+	if err != nil {
+		t.Errorf("ATCommand error %v", err)
+	}
+}
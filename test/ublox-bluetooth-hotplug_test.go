@@ -0,0 +1,304 @@
+package ubloxbluetooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+	"golang.org/x/sys/unix"
+)
+
+// fakeWatcher hands out the devices pushed to it
+type fakeWatcher struct {
+	devices chan *serial.DeviceInfo
+}
+
+func (w *fakeWatcher) WaitForDevice(filter serial.DeviceFilter, stop <-chan struct{}) (*serial.DeviceInfo, error) {
+	select {
+	case d := <-w.devices:
+		return d, nil
+	case <-stop:
+		return nil, serial.ErrWatchStopped
+	}
+}
+
+// fakeDongle is a pseudo terminal standing in for the dongle, it answers every EDM AT request with OK
+// and passes the request to received when there is room. A request containing a key of replies is
+// also answered by its responder, one containing a key of failures with ERROR, and the next one
+// containing a key of ignores not at all.
+type fakeDongle struct {
+	master   *os.File
+	path     string
+	received chan []byte
+	lock     sync.Mutex
+	replies  map[string]responder
+	failures map[string]bool
+	ignores  map[string]bool
+}
+
+// responder returns the frames to send before and after the OK to a request
+type responder func(request string) (before [][]byte, after [][]byte)
+
+func newFakeDongle(t *testing.T) *fakeDongle {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	// Fd would put the master into blocking mode, and a blocked Read would stop unplug closing it
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn error %v", err)
+	}
+	n := 0
+	rc.Control(func(fd uintptr) {
+		err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0)
+		if err == nil {
+			n, err = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if err != nil {
+		t.Fatalf("pseudo terminal setup error %v", err)
+	}
+
+	d := &fakeDongle{master: master, path: fmt.Sprintf("/dev/pts/%d", n), received: make(chan []byte, 16)}
+	go d.answer()
+	return d
+}
+
+func (d *fakeDongle) answer() {
+	ok := confirmationFrame("OK")
+	failed := confirmationFrame("ERROR")
+	b := make([]byte, 256)
+	buf := []byte{}
+	for {
+		n, err := d.master.Read(b)
+		if err != nil {
+			return
+		}
+		buf = append(buf, b[:n]...)
+		for {
+			start := bytes.IndexByte(buf, u.EDMStartByte)
+			if start < 0 {
+				buf = buf[:0]
+				break
+			}
+			buf = buf[start:]
+			if len(buf) < 3 {
+				break
+			}
+			size := 3 + int(binary.BigEndian.Uint16(buf[1:3])) + 1
+			if len(buf) < size {
+				break
+			}
+			frame := append([]byte{}, buf[:size]...)
+			buf = buf[size:]
+
+			select {
+			case d.received <- frame:
+			default:
+			}
+			if d.ignoring(string(frame)) {
+				continue
+			}
+			before, after := d.respond(string(frame))
+			for _, r := range before {
+				d.master.Write(r)
+			}
+			if d.failing(string(frame)) {
+				d.master.Write(failed)
+			} else {
+				d.master.Write(ok)
+			}
+			for _, r := range after {
+				d.master.Write(r)
+			}
+		}
+	}
+}
+
+// event sends an unsolicited AT event to the host
+func (d *fakeDongle) event(s string) {
+	d.master.Write(eventFrame(s))
+}
+
+// reply has the dongle answer requests containing `cmd` with `frames` before the OK
+func (d *fakeDongle) reply(cmd string, frames ...[]byte) {
+	d.responder(cmd, func(string) ([][]byte, [][]byte) {
+		return frames, nil
+	})
+}
+
+// responder has `r` answer requests containing `cmd`
+func (d *fakeDongle) responder(cmd string, r responder) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.replies == nil {
+		d.replies = map[string]responder{}
+	}
+	d.replies[cmd] = r
+}
+
+func (d *fakeDongle) respond(request string) ([][]byte, [][]byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for cmd, r := range d.replies {
+		if strings.Contains(request, cmd) {
+			return r(request)
+		}
+	}
+	return nil, nil
+}
+
+// fail has the dongle answer requests containing `cmd` with ERROR
+func (d *fakeDongle) fail(cmd string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.failures == nil {
+		d.failures = map[string]bool{}
+	}
+	d.failures[cmd] = true
+}
+
+func (d *fakeDongle) failing(request string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for cmd := range d.failures {
+		if strings.Contains(request, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignore has the dongle send nothing in reply to the next request containing `cmd`
+func (d *fakeDongle) ignore(cmd string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.ignores == nil {
+		d.ignores = map[string]bool{}
+	}
+	d.ignores[cmd] = true
+}
+
+func (d *fakeDongle) ignoring(request string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for cmd := range d.ignores {
+		if strings.Contains(request, cmd) {
+			delete(d.ignores, cmd)
+			return true
+		}
+	}
+	return false
+}
+
+func eventFrame(s string) []byte {
+	return u.NewEMDCmdBytes(append([]byte{0x00, u.ATEvent}, "\r\n"+s+"\r\n"...))
+}
+
+func confirmationFrame(s string) []byte {
+	return u.NewEMDCmdBytes(append([]byte{0x00, u.ATConfirmation}, "\r\n"+s+"\r\n"...))
+}
+
+// unplug closes the master side, which hangs up the device
+func (d *fakeDongle) unplug() {
+	d.master.Close()
+}
+
+func waitForEvent(t *testing.T, events chan u.HotPlugEvent, expected u.HotPlugEventType) u.HotPlugEvent {
+	select {
+	case e := <-events:
+		if e.Type != expected {
+			t.Fatalf("expected event %d, got %+v", expected, e)
+		}
+		return e
+	case <-time.After(timeout):
+		t.Fatalf("no event %d", expected)
+	}
+	return u.HotPlugEvent{}
+}
+
+func TestHotPlug(t *testing.T) {
+	first := newFakeDongle(t)
+	ub, err := u.NewUbloxBluetoothOnDevice(first.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	err = ub.ATCommand()
+	if err != nil {
+		t.Fatalf("ATCommand error %v", err)
+	}
+
+	watcher := &fakeWatcher{devices: make(chan *serial.DeviceInfo)}
+	events := make(chan u.HotPlugEvent, 2)
+	initialised := 0
+	ub.EnableHotPlug(u.HotPlugOptions{
+		Watcher: watcher,
+		Filter:  serial.DeviceFilter{SerialNumber: "DONGLE1"},
+		Initialise: func(ub *u.UbloxBluetooth) error {
+			initialised++
+			return ub.ATCommand()
+		},
+		Handler: func(e u.HotPlugEvent) {
+			events <- e
+		},
+	})
+
+	first.unplug()
+	e := waitForEvent(t, events, u.ModuleDetached)
+	if !serial.IsDeviceGone(e.Err) {
+		t.Errorf("detached with %v", e.Err)
+	}
+	if ub.IsAttached() {
+		t.Errorf("still attached after unplug")
+	}
+	err = ub.ATCommand()
+	if !serial.IsDeviceGone(err) {
+		t.Errorf("expected ErrDeviceGone, got %v", err)
+	}
+
+	second := newFakeDongle(t)
+	defer second.unplug()
+	watcher.devices <- &serial.DeviceInfo{Path: second.path, SerialNumber: "DONGLE1"}
+	e = waitForEvent(t, events, u.ModuleReattached)
+	if e.Err != nil || e.Device.Path != second.path || initialised != 1 {
+		t.Errorf("reattached with %+v, initialised %d times", e, initialised)
+	}
+
+	err = ub.ATCommand()
+	if err != nil {
+		t.Errorf("ATCommand after reattach error %v", err)
+	}
+}
+
+func TestUnplugDuringCommand(t *testing.T) {
+	dongle := newFakeDongle(t)
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	// the dongle goes before it answers, the waiting command fails straight away
+	dongle.responder("AT\r", func(string) ([][]byte, [][]byte) {
+		dongle.unplug()
+		return nil, nil
+	})
+	start := time.Now()
+	err = ub.ATCommand()
+	if !serial.IsDeviceGone(err) {
+		t.Errorf("expected ErrDeviceGone, got %v", err)
+	}
+	if time.Since(start) >= timeout {
+		t.Errorf("command waited %v for the unplug", time.Since(start))
+	}
+}
@@ -0,0 +1,125 @@
+package ubloxbluetooth
+
+import (
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestConnectionParametersValidate(t *testing.T) {
+	valid := u.ConnectionParameters{
+		MinInterval:        7500 * time.Microsecond,
+		MaxInterval:        15 * time.Millisecond,
+		Latency:            0,
+		SupervisionTimeout: 4 * time.Second,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate error %v", err)
+	}
+
+	invalid := map[string]func(p *u.ConnectionParameters){
+		"interval too short": func(p *u.ConnectionParameters) { p.MinInterval = 5 * time.Millisecond },
+		"intervals reversed": func(p *u.ConnectionParameters) { p.MinInterval = 20 * time.Millisecond },
+		"latency":            func(p *u.ConnectionParameters) { p.Latency = 500 },
+		"timeout too short":  func(p *u.ConnectionParameters) { p.SupervisionTimeout = 50 * time.Millisecond },
+		"timeout vs latency": func(p *u.ConnectionParameters) { p.Latency = 200 },
+	}
+	for name, change := range invalid {
+		p := valid
+		change(&p)
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLinkReplies(t *testing.T) {
+	handle, tx, rx, err := u.ProcessPHYReply([]byte("+UBTLEPHYR:0,2,2"))
+	if err != nil || handle != 0 || tx != 2 || rx != 2 {
+		t.Errorf("ProcessPHYReply got %d %d %d %v", handle, tx, rx, err)
+	}
+
+	update, err := u.ProcessPHYUpdateEvent([]byte("+UUBTLEPHYU:1,0,4,1"))
+	if err != nil || *update != (u.PHYUpdate{Handle: 1, Status: 0, TxPHY: u.PHYCoded, RxPHY: u.PHY1M}) {
+		t.Errorf("ProcessPHYUpdateEvent got %+v %v", update, err)
+	}
+
+	status, err := u.ProcessConnectionStatusReply([]byte("+UBTCST:0,0,24+UBTCST:0,3,247"))
+	if err != nil || status[0] != 24 || status[3] != 247 {
+		t.Errorf("ProcessConnectionStatusReply got %v %v", status, err)
+	}
+	_, err = u.ProcessConnectionStatusReply([]byte("+UBTCST:0,x,1"))
+	if err == nil {
+		t.Errorf("ProcessConnectionStatusReply expected an error")
+	}
+}
+
+func TestLinkParameters(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	dongle.reply("AT+UBTLEPHYR=0,2,2", eventFrame("+UUBTLEPHYU:0,0,2,2"))
+	dongle.reply("AT+UBTCST=0",
+		confirmationFrame("+UBTCST:0,0,24"),
+		confirmationFrame("+UBTCST:0,1,0"),
+		confirmationFrame("+UBTCST:0,2,400"),
+		confirmationFrame("+UBTCST:0,3,247"),
+		confirmationFrame("+UBTCST:0,9,2"),
+		confirmationFrame("+UBTCST:0,10,2"),
+	)
+
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+
+	update, err := ub.RequestPHY(u.PHY2M, u.PHY2M)
+	if err != nil || update.TxPHY != u.PHY2M || update.RxPHY != u.PHY2M {
+		t.Fatalf("RequestPHY got %+v %v", update, err)
+	}
+	link, err := ub.LinkParameters()
+	if err != nil || link.TxPHY != u.PHY2M || link.RxPHY != u.PHY2M {
+		t.Errorf("LinkParameters after RequestPHY got %+v %v", link, err)
+	}
+
+	// an update the host did not ask for, e.g. from the peer, is recorded too
+	dongle.event("+UUBTLEPHYU:0,0,1,1")
+	deadline := time.Now().Add(timeout)
+	for link.TxPHY != u.PHY1M && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		link, _ = ub.LinkParameters()
+	}
+	if link.TxPHY != u.PHY1M || link.RxPHY != u.PHY1M {
+		t.Errorf("LinkParameters after peer update got %+v", link)
+	}
+
+	read, err := ub.ReadLinkParameters()
+	expected := u.LinkParameters{
+		Interval:           30 * time.Millisecond,
+		Latency:            0,
+		SupervisionTimeout: 4 * time.Second,
+		MTU:                247,
+		TxPHY:              u.PHY2M,
+		RxPHY:              u.PHY2M,
+	}
+	if err != nil || *read != expected {
+		t.Errorf("ReadLinkParameters got %+v %v", read, err)
+	}
+
+	err = ub.UpdateConnectionParameters(u.ConnectionParameters{
+		MinInterval:        7500 * time.Microsecond,
+		MaxInterval:        15 * time.Millisecond,
+		SupervisionTimeout: 4 * time.Second,
+	})
+	if err != nil {
+		t.Errorf("UpdateConnectionParameters error %v", err)
+	}
+	waitForRequest(t, dongle, "AT+UBTCPU=0,6,12,0,400")
+}
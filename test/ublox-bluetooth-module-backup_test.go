@@ -0,0 +1,82 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestModuleBackupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ublox-backup")
+	if err != nil {
+		t.Fatalf("TempDir error %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &u.ModuleBackup{
+		Version: u.ModuleBackupVersion,
+		Config: u.ModuleConfig{
+			BLERole:   1,
+			RS232:     u.RS232SettingsReply{BaudRate: 1000000, FlowControl: 1, DataBits: 8, StopBits: 1, Parity: 1},
+			StartMode: u.ExtendedDataMode,
+			Watchdog:  u.WatchdogSettings{InactivityTimeout: 6000, DisconnectReset: 1},
+			LocalName: "gateway-1",
+		},
+		BondedDevices: []string{"CE1A0B7E9D79r"},
+	}
+	b.Config.BLEConfig.Set(map[int]int{4: 24, 5: 40, 99: 3})
+
+	path := filepath.Join(dir, "backup.json")
+	err = u.SaveModuleBackup(path, b)
+	if err != nil {
+		t.Fatalf("SaveModuleBackup error %v\n", err)
+	}
+	// the backup is written beside the file and renamed over it
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary backup left behind %v", err)
+	}
+
+	loaded, err := u.LoadModuleBackup(path)
+	if err != nil {
+		t.Fatalf("LoadModuleBackup error %v\n", err)
+	}
+	if !reflect.DeepEqual(b, loaded) {
+		t.Errorf("backup changed on reload:\n%+v\n%+v", b, loaded)
+	}
+
+	err = ioutil.WriteFile(path, []byte(`{"Version": 99}`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile error %v\n", err)
+	}
+	_, err = u.LoadModuleBackup(path)
+	if err == nil {
+		t.Errorf("expected unsupported version error")
+	}
+}
+
+func TestModuleBackupRestore(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	b, err := ub.BackupModuleConfig()
+	if err != nil {
+		t.Fatalf("BackupModuleConfig error %v\n", err)
+	}
+	fmt.Printf("Backup: %+v\n", b)
+
+	changes, err := ub.RestoreModuleConfig(b)
+	if err != nil {
+		t.Errorf("RestoreModuleConfig error %v\n", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("restoring an unchanged module made changes %v", changes)
+	}
+}
@@ -0,0 +1,126 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"golang.org/x/sys/unix"
+)
+
+func TestProcessParameterListReply(t *testing.T) {
+	params, err := u.ProcessParameterListReply([]byte("+UBTLECFG:1,1600+UBTLECFG:4,24+UBTLECFG:5,40+UBTLECFG:99,3"), "+UBTLECFG:")
+	if err != nil {
+		t.Fatalf("ProcessParameterListReply error %v\n", err)
+	}
+	if len(params) != 4 || params[1] != 1600 || params[4] != 24 || params[5] != 40 || params[99] != 3 {
+		t.Errorf("unexpected parameters %v", params)
+	}
+
+	var cfg u.BLEConfigParams
+	cfg.Set(params)
+	if cfg.MinAdvertisingInterval != 1600 || cfg.MaxConnectionInterval != 40 || cfg.Other[99] != 3 {
+		t.Errorf("parameters not set %v", cfg)
+	}
+
+	name, err := u.ProcessLocalNameReply([]byte("+UBTLN:\"gateway-1\""))
+	if err != nil || name != "gateway-1" {
+		t.Errorf("ProcessLocalNameReply returned %q %v", name, err)
+	}
+}
+
+func TestModuleConfigDiff(t *testing.T) {
+	current := &u.ModuleConfig{
+		BLERole:   1,
+		RS232:     u.RS232SettingsReply{BaudRate: 1000000, FlowControl: 1, DataBits: 8, StopBits: 1, Parity: 1},
+		StartMode: u.ExtendedDataMode,
+		LocalName: "gateway-1",
+	}
+	current.BLEConfig.Set(map[int]int{4: 24, 5: 40})
+
+	desired := *current
+	desired.BLEConfig.Set(map[int]int{4: 24, 5: 40})
+	if changes := current.Diff(&desired); len(changes) != 0 {
+		t.Errorf("expected no changes got %v", changes)
+	}
+
+	desired.BLEConfig.MaxConnectionInterval = 80
+	desired.Watchdog.InactivityTimeout = 6000
+	// the module does not report its echo, so it is never a change
+	desired.Echo = u.EchoOff
+	changes := current.Diff(&desired)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes got %v", changes)
+	}
+	if !changes[0].RequiresReboot || changes[0].Desired != "80" {
+		t.Errorf("unexpected BLE config change %v", changes[0])
+	}
+	if changes[1].RequiresReboot {
+		t.Errorf("watchdog change should not need a reboot %v", changes)
+	}
+}
+
+func TestApplyModuleConfig(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	cfg, err := ub.ReadModuleConfig()
+	if err != nil {
+		t.Fatalf("ReadModuleConfig error %v\n", err)
+	}
+	fmt.Printf("Module config: %+v\n", cfg)
+
+	cfg.BLEConfig.MinConnectionInterval = 24
+	cfg.BLEConfig.MaxConnectionInterval = 40
+	changes, err := ub.ApplyModuleConfig(cfg)
+	if err != nil {
+		t.Errorf("ApplyModuleConfig error %v\n", err)
+	}
+	fmt.Printf("Changes: %v\n", changes)
+}
+
+func TestApplyModuleConfigFlowControl(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTLE?", confirmationFrame("+UBTLE:2"))
+	dongle.reply("AT+UBTLECFG?", confirmationFrame("+UBTLECFG:4,24"))
+	dongle.reply("AT+UMRS?", confirmationFrame("+UMRS:1000000,1,8,1,1,1"))
+	dongle.reply("AT+UMSM?", confirmationFrame("+UMSM:2"))
+	dongle.reply("AT+UDWS?", confirmationFrame("+UDWS:1,0"))
+	dongle.reply("AT+UBTLN?", confirmationFrame("+UBTLN:\"gateway-1\""))
+
+	cfg, err := ub.ReadModuleConfig()
+	if err != nil {
+		t.Fatalf("ReadModuleConfig error %v", err)
+	}
+	cfg.RS232.FlowControl = 2
+	changes, err := ub.ApplyModuleConfig(cfg)
+	if err != nil || len(changes) != 1 || changes[0].RequiresReboot {
+		t.Fatalf("ApplyModuleConfig returned %v %v", changes, err)
+	}
+	waitForRequest(t, dongle, "AT+UMRS=1000000,2,8,1,1,1")
+
+	// the module changes once it has confirmed, so the host must have followed
+	f, err := os.OpenFile(dongle.path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("open %s error %v", dongle.path, err)
+	}
+	defer f.Close()
+	termios, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatalf("TCGETS error %v", err)
+	}
+	if termios.Cflag&unix.CRTSCTS != 0 {
+		t.Errorf("host still has RTS/CTS flow control")
+	}
+}
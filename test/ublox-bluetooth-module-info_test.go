@@ -0,0 +1,150 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+)
+
+func TestFirmwareVersion(t *testing.T) {
+	major, minor, patch, err := u.ParseFirmwareVersion("2.1.3-017")
+	if err != nil || major != 2 || minor != 1 || patch != 3 {
+		t.Errorf("ParseFirmwareVersion returned %d.%d.%d %v", major, minor, patch, err)
+	}
+
+	_, _, _, err = u.ParseFirmwareVersion("unknown")
+	if err == nil {
+		t.Errorf("expected invalid version error")
+	}
+
+	mi := &u.ModuleInfo{FirmwareVersion: "2.1.3-017"}
+	tests := []struct {
+		major, minor, patch int
+		want                bool
+	}{
+		{2, 1, 3, true},
+		{2, 1, 4, false},
+		{2, 0, 9, true},
+		{1, 9, 9, true},
+		{3, 0, 0, false},
+	}
+	for _, tc := range tests {
+		if got := mi.FirmwareAtLeast(tc.major, tc.minor, tc.patch); got != tc.want {
+			t.Errorf("FirmwareAtLeast(%d, %d, %d) returned %v", tc.major, tc.minor, tc.patch, got)
+		}
+	}
+}
+
+func TestModuleInfo(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	mi, err := ub.ModuleInfo()
+	if err != nil {
+		t.Fatalf("ModuleInfo error %v\n", err)
+	}
+	fmt.Printf("Module: %+v\n", mi)
+}
+
+func TestModuleInfoText(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	identify(dongle, "0123456789")
+
+	mi, err := ub.ModuleInfo()
+	if err != nil {
+		t.Fatalf("ModuleInfo error %v", err)
+	}
+	if mi.Manufacturer != "u-blox" || mi.Model != "NINA-B3" || mi.FirmwareVersion != "2.1.3-017" || mi.SerialNumber != "0123456789" {
+		t.Errorf("ModuleInfo returned %+v", mi)
+	}
+
+	// the cached identity is not changed through a returned copy
+	mi.FirmwareVersion = "0.0.0"
+	mi, err = ub.ModuleInfo()
+	if err != nil || mi.FirmwareVersion != "2.1.3-017" {
+		t.Errorf("ModuleInfo returned %+v %v after a copy was changed", mi, err)
+	}
+
+	// text only answers the identification commands
+	dongle.reply("AT\r", confirmationFrame("stray"))
+	err = ub.ATCommand()
+	if err == nil || !strings.Contains(err.Error(), "Cannot handle message") {
+		t.Errorf("expected stray text error, got %v", err)
+	}
+}
+
+// identify has `dongle` answer the ModuleInfo queries
+func identify(dongle *fakeDongle, serialNumber string) {
+	dongle.reply("AT+CGMI", confirmationFrame("u-blox"))
+	dongle.reply("AT+CGMM", confirmationFrame("NINA-B3"))
+	dongle.reply("AT+CGMR", confirmationFrame("\"2.1.3-017\""))
+	dongle.reply("AT+CGSN", confirmationFrame(serialNumber))
+	dongle.reply("AT+UMLA", confirmationFrame("+UMLA:CE1A0B7E9D79r"))
+}
+
+func TestModuleInfoReattach(t *testing.T) {
+	first := newFakeDongle(t)
+	identify(first, "FIRST")
+	ub, err := u.NewUbloxBluetoothOnDevice(first.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	watcher := &fakeWatcher{devices: make(chan *serial.DeviceInfo)}
+	events := make(chan u.HotPlugEvent, 2)
+	ub.EnableHotPlug(u.HotPlugOptions{
+		Watcher: watcher,
+		Handler: func(e u.HotPlugEvent) {
+			events <- e
+		},
+	})
+
+	mi, err := ub.ModuleInfo()
+	if err != nil || mi.SerialNumber != "FIRST" {
+		t.Fatalf("ModuleInfo returned %+v %v", mi, err)
+	}
+
+	// the cache is read while the reattach clears it
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				ub.ModuleInfo()
+			}
+		}
+	}()
+
+	first.unplug()
+	waitForEvent(t, events, u.ModuleDetached)
+	second := newFakeDongle(t)
+	defer second.unplug()
+	identify(second, "SECOND")
+	watcher.devices <- &serial.DeviceInfo{Path: second.path}
+	waitForEvent(t, events, u.ModuleReattached)
+	close(stop)
+	<-done
+
+	mi, err = ub.ModuleInfo()
+	if err != nil || mi.SerialNumber != "SECOND" {
+		t.Errorf("ModuleInfo after reattach returned %+v %v", mi, err)
+	}
+}
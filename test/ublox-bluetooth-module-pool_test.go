@@ -0,0 +1,89 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+func TestModulePool(t *testing.T) {
+	first := newFakeDongle(t)
+	second := newFakeDongle(t)
+	defer second.unplug()
+
+	pool, err := u.NewModulePool(u.PoolOptions{
+		Timeout:             time.Second,
+		Devices:             []string{first.path, second.path, "/dev/missing"},
+		HealthCheckInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewModulePool error %v", err)
+	}
+	defer pool.Close()
+
+	if len(pool.Status()) != 2 {
+		t.Fatalf("expected 2 modules, got %+v", pool.Status())
+	}
+
+	var mu sync.Mutex
+	active := map[string]int{}
+	used := map[string]int{}
+	job := func(ub *u.UbloxBluetooth) error {
+		path := ub.DevicePath()
+		mu.Lock()
+		active[path]++
+		if active[path] > 1 {
+			t.Errorf("%d jobs running on %s", active[path], path)
+		}
+		used[path]++
+		mu.Unlock()
+
+		err := ub.ATCommand()
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active[path]--
+		mu.Unlock()
+		return err
+	}
+
+	results := []<-chan error{}
+	for i := 0; i < 6; i++ {
+		results = append(results, pool.Submit(context.Background(), job))
+	}
+	for _, r := range results {
+		if err := <-r; err != nil {
+			t.Errorf("job error %v", err)
+		}
+	}
+	if used[first.path] == 0 || used[second.path] == 0 {
+		t.Errorf("jobs were not spread across modules %v", used)
+	}
+
+	// jobs move off a module that has failed
+	first.unplug()
+	for i := 0; i < 4; i++ {
+		err = pool.Run(context.Background(), job)
+		if err != nil {
+			t.Errorf("job after unplug error %v", err)
+		}
+	}
+	for _, s := range pool.Status() {
+		if s.Path == first.path && s.Healthy {
+			t.Errorf("unplugged module is still healthy")
+		}
+		if s.Path == second.path && !s.Healthy {
+			t.Errorf("module %s is unhealthy: %v", s.Path, s.LastError)
+		}
+	}
+
+	second.unplug()
+	err = pool.Run(context.Background(), job)
+	if errors.Cause(err) != u.ErrNoModules {
+		t.Errorf("expected ErrNoModules, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+func TestPresenceRegistry(t *testing.T) {
+	start := time.Now()
+	pr := u.NewPresenceRegistry(10 * time.Second)
+
+	scan := func(at time.Time, rssi int) u.DeviceTable {
+		return u.DeviceTable{
+			"CE1A0B7E9D79": &u.ScannedDevice{
+				BluetoothAddress: "CE1A0B7E9D79r",
+				Rssi:             rssi,
+				AverageRssi:      float64(rssi),
+				FirstSeen:        at,
+				LastSeen:         at,
+				Sightings:        1,
+			},
+		}
+	}
+
+	events := pr.Observe(scan(start, -60))
+	if len(events) != 1 || events[0].Type != u.DeviceAppeared {
+		t.Fatalf("expected appeared event got %v", events)
+	}
+
+	events = pr.Observe(scan(start.Add(5*time.Second), -80))
+	if len(events) != 1 || events[0].Type != u.DeviceUpdated {
+		t.Fatalf("expected updated event got %v", events)
+	}
+	if events[0].Device.Sightings != 2 || events[0].Device.Rssi != -80 {
+		t.Errorf("device not merged %v", events[0].Device)
+	}
+	if !events[0].Device.FirstSeen.Equal(start) {
+		t.Errorf("FirstSeen changed to %v", events[0].Device.FirstSeen)
+	}
+
+	if events = pr.Expire(start.Add(10 * time.Second)); len(events) != 0 {
+		t.Errorf("device expired too soon %v", events)
+	}
+
+	events = pr.Expire(start.Add(16 * time.Second))
+	if len(events) != 1 || events[0].Type != u.DeviceLost {
+		t.Fatalf("expected lost event got %v", events)
+	}
+	if len(pr.Devices()) != 0 {
+		t.Errorf("lost device still in registry")
+	}
+
+	events = pr.Observe(scan(start.Add(20*time.Second), -60))
+	if len(events) != 1 || events[0].Type != u.DeviceAppeared {
+		t.Errorf("expected reappeared event got %v", events)
+	}
+}
+
+func TestPresenceTracker(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	pt := ub.NewPresenceTracker(u.PresenceTrackerOptions{
+		Scan:      u.ScanOptions{Duration: 2 * time.Second},
+		Interval:  time.Second,
+		LostAfter: 20 * time.Second,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pt.Start(ctx)
+
+	for e := range pt.Events {
+		fmt.Printf("Presence: %s %s RSSI %.1f %v\n", e.Type, e.Device.BluetoothAddress, e.Device.AverageRssi, e.Err)
+	}
+}
+
+func TestScanWhileConnected(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	dongle.reply("AT+UBTACLD=", eventFrame("+UUBTACLD:0"))
+
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error {
+		if ub.IsIdle() {
+			t.Errorf("idle while connected")
+		}
+		_, err := ub.Scan(context.Background(), u.ScanOptions{})
+		if errors.Cause(err) != u.ErrRadioBusy {
+			t.Errorf("Scan from onConnect expected ErrRadioBusy, got %v", err)
+		}
+		err = ub.DiscoveryCommand(func(*u.DiscoveryReply) error { return nil })
+		if errors.Cause(err) != u.ErrRadioBusy {
+			t.Errorf("DiscoveryCommand from onConnect expected ErrRadioBusy, got %v", err)
+		}
+		return ub.DisconnectFromDevice()
+	}, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+	if !ub.IsIdle() {
+		t.Errorf("not idle after disconnect")
+	}
+}
+
+func TestConnectWhileConnected(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+
+	// without the disconnect the second connection gives up rather than waiting for good
+	done := make(chan error, 1)
+	go func() {
+		done <- ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	}()
+	select {
+	case err = <-done:
+		if errors.Cause(err) != u.ErrRadioBusy {
+			t.Errorf("second ConnectToDevice expected ErrRadioBusy, got %v", err)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("second ConnectToDevice is still waiting for the radio")
+	}
+}
+
+func TestDisconnectFailure(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	dongle.fail("AT+UBTACLD=")
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+	err = ub.DisconnectFromDevice()
+	if err == nil {
+		t.Fatalf("expected DisconnectFromDevice to fail")
+	}
+
+	// the failed disconnect still releases the radio
+	if ub.Connected() {
+		t.Errorf("still connected after the failed disconnect")
+	}
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Errorf("second ConnectToDevice error %v", err)
+	}
+}
+
+func TestUnexpectedDisconnect(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	disconnected := make(chan bool, 1)
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error {
+		disconnected <- ub.Connected()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+
+	dongle.event("+UUBTACLD:0")
+	select {
+	case connected := <-disconnected:
+		if connected {
+			t.Errorf("still connected in the disconnect handler")
+		}
+	case <-time.After(timeout):
+		t.Fatalf("the disconnect handler was not called")
+	}
+	if !ub.IsIdle() {
+		t.Errorf("not idle after the disconnect")
+	}
+}
@@ -0,0 +1,172 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+var _ u.RolloutClient = (*u.UbloxBluetooth)(nil)
+
+// fakeFleet holds a config and name for each sensor
+type fakeFleet struct {
+	connected   string
+	configs     map[string]u.ConfigReply
+	names       map[string]string
+	unreachable map[string]bool
+	writes      []string
+}
+
+func (f *fakeFleet) ConnectToDevice(address string, onConnect u.DeviceEvent, onDisconnect u.DeviceEvent) error {
+	if f.unreachable[address] {
+		return fmt.Errorf("Timeout")
+	}
+	f.connected = address
+	return onConnect()
+}
+
+func (f *fakeFleet) DisconnectFromDevice() error {
+	f.connected = ""
+	return nil
+}
+
+func (f *fakeFleet) EnableNotifications() error { return nil }
+func (f *fakeFleet) EnableIndications() error   { return nil }
+
+func (f *fakeFleet) UnlockDevice(password []byte) (bool, error) {
+	return string(password) == "ABC", nil
+}
+
+func (f *fakeFleet) ReadConfig() (*u.ConfigReply, error) {
+	c := f.configs[f.connected]
+	return &c, nil
+}
+
+func (f *fakeFleet) WriteConfig(cfg *u.ConfigReply) error {
+	f.writes = append(f.writes, "config "+f.connected)
+	f.configs[f.connected] = *cfg
+	return nil
+}
+
+func (f *fakeFleet) ReadName() (string, error) {
+	return f.names[f.connected], nil
+}
+
+func (f *fakeFleet) WriteName(name string) error {
+	f.writes = append(f.writes, "name "+f.connected)
+	f.names[f.connected] = name
+	return nil
+}
+
+const fleetFile = `{
+	"groups": [
+		{
+			"name": "north",
+			"config": {"sampleIntervalSeconds": 120, "mode": "active"},
+			"sensors": [
+				{"address": "CE1A0B7E9D79r", "config": {"name": "north-1"}},
+				{"address": "D8C4E2A1B3F5r"}
+			]
+		},
+		{
+			"name": "south",
+			"config": {"accelRangeG": 8, "temperatureOffsetC": -0.5},
+			"sensors": [{"address": "F344B0C992E1r"}]
+		}
+	]
+}`
+
+func TestRollout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollout")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fleet.json")
+	ioutil.WriteFile(path, []byte(fleetFile), 0644)
+	fleet, err := u.LoadFleetConfig(path)
+	if err != nil {
+		t.Fatalf("LoadFleetConfig error %v", err)
+	}
+
+	base := u.ConfigReply{AdvertisingInterval: 1000, SampleTime: 60}
+	fake := &fakeFleet{
+		configs: map[string]u.ConfigReply{
+			"CE1A0B7E9D79r": base,
+			"D8C4E2A1B3F5r": {AdvertisingInterval: 1000, SampleTime: 120, State: 1},
+			"F344B0C992E1r": base,
+		},
+		names:       map[string]string{"CE1A0B7E9D79r": "old"},
+		unreachable: map[string]bool{"F344B0C992E1r": true},
+	}
+	options := u.RolloutOptions{
+		JournalPath: filepath.Join(dir, "journal.json"),
+		Password:    func(string) []byte { return []byte("ABC") },
+	}
+
+	// a dry run changes nothing
+	dry := options
+	dry.DryRun = true
+	r, _ := u.NewRollout(fake, fleet, dry)
+	results, err := r.Run(context.Background())
+	if err != nil || len(fake.writes) != 0 || results[0].Status != u.RolloutPlanned || len(results[0].Changes) != 3 {
+		t.Fatalf("unexpected dry run %+v %v %v", results, fake.writes, err)
+	}
+
+	r, err = u.NewRollout(fake, fleet, options)
+	if err != nil {
+		t.Fatalf("NewRollout error %v", err)
+	}
+	results, _ = r.Run(context.Background())
+	if results[0].Status != u.RolloutApplied || results[1].Status != u.RolloutUnchanged || results[2].Status != u.RolloutFailed {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if fake.names["CE1A0B7E9D79r"] != "north-1" || fake.configs["CE1A0B7E9D79r"].SampleTime != 120 || fake.configs["CE1A0B7E9D79r"].State != 1 {
+		t.Errorf("config not applied %+v", fake.configs["CE1A0B7E9D79r"])
+	}
+
+	// a resumed rollout only visits the sensor that failed
+	fake.unreachable = nil
+	fake.writes = nil
+	r, _ = u.NewRollout(fake, fleet, options)
+	results, _ = r.Run(context.Background())
+	if results[0].Status != u.RolloutSkipped || results[1].Status != u.RolloutSkipped || results[2].Status != u.RolloutApplied {
+		t.Fatalf("unexpected resumed results %+v", results)
+	}
+	if len(fake.writes) != 1 || fake.configs["F344B0C992E1r"].AccelSettings != 2 || fake.configs["F344B0C992E1r"].TemperatureOffset != 0xFFFB {
+		t.Errorf("unexpected writes %v %+v", fake.writes, fake.configs["F344B0C992E1r"])
+	}
+
+	// a changed desired config is applied again
+	interval := 300
+	fleet.Groups[0].Config.SampleIntervalSeconds = &interval
+	r, _ = u.NewRollout(fake, fleet, options)
+	results, _ = r.Run(context.Background())
+	if results[0].Status != u.RolloutApplied || results[1].Status != u.RolloutApplied || results[2].Status != u.RolloutSkipped {
+		t.Errorf("unexpected results after change %+v", results)
+	}
+}
+
+func TestFleetConfigValidate(t *testing.T) {
+	bad := []string{
+		`{"groups": [{"name": "a", "sensors": [{"address": "CE1A0B7E9D79r"}]}, {"name": "b", "sensors": [{"address": "ce1a0b7e9d79"}]}]}`,
+		`{"groups": [{"name": "a", "config": {"mode": "sleeping"}, "sensors": [{"address": "CE1A0B7E9D79r"}]}]}`,
+		`{"groups": [{"name": "a", "config": {"accelDataRateHz": 30}, "sensors": [{"address": "CE1A0B7E9D79r"}]}]}`,
+		`{"groups": [{"name": "a", "sensors": [{"address": "CE1A0B7E9D79r", "config": {"sampleIntervalSeconds": 0}}]}]}`,
+	}
+	dir, _ := ioutil.TempDir("", "rollout")
+	defer os.RemoveAll(dir)
+	for i, b := range bad {
+		path := filepath.Join(dir, "fleet.json")
+		ioutil.WriteFile(path, []byte(b), 0644)
+		_, err := u.LoadFleetConfig(path)
+		if err == nil {
+			t.Errorf("invalid fleet %d accepted", i)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestParseAdvertisingData(t *testing.T) {
+	// flags, complete 128 bit UUID list, complete local name "VEH"
+	ads, err := u.ParseAdvertisingData("020106" + "1107" + "01D7E9014FF344E7838FE226B9E15624" + "0409564548")
+	if err != nil {
+		t.Fatalf("ParseAdvertisingData error %v\n", err)
+	}
+	if len(ads) != 3 {
+		t.Fatalf("expected 3 AD structures got %d", len(ads))
+	}
+
+	uuids := u.AdvertisedServiceUUIDs(ads)
+	if len(uuids) != 1 || uuids[0] != u.NormaliseUUID("2456e1b9-26e2-8f83-e744-f34f01e9d701") {
+		t.Errorf("unexpected service UUIDs %v", uuids)
+	}
+
+	if name := u.AdvertisedLocalName(ads); name != "VEH" {
+		t.Errorf("unexpected local name %q", name)
+	}
+
+	_, err = u.ParseAdvertisingData("0501AA")
+	if err == nil {
+		t.Errorf("expected overrun error")
+	}
+}
+
+func TestScanOptionsMatches(t *testing.T) {
+	sd := &u.ScannedDevice{
+		BluetoothAddress: "CE1A0B7E9D79r",
+		DeviceName:       "VEH-0042",
+		AverageRssi:      -70,
+		ServiceUUIDs:     []string{"2456E1B926E28F83E744F34F01E9D701"},
+	}
+
+	tests := []struct {
+		opts u.ScanOptions
+		want bool
+	}{
+		{u.ScanOptions{}, true},
+		{u.ScanOptions{MinRSSI: -80}, true},
+		{u.ScanOptions{MinRSSI: -60}, false},
+		{u.ScanOptions{NamePrefix: "VEH"}, true},
+		{u.ScanOptions{NamePrefix: "ABC"}, false},
+		{u.ScanOptions{Addresses: []string{"ce1a0b7e9d79"}}, true},
+		{u.ScanOptions{Addresses: []string{"D5926479C652r"}}, false},
+		{u.ScanOptions{ServiceUUID: "2456e1b9-26e2-8f83-e744-f34f01e9d701"}, true},
+		{u.ScanOptions{ServiceUUID: "180F"}, false},
+	}
+	for i, tc := range tests {
+		if got := tc.opts.Matches(sd); got != tc.want {
+			t.Errorf("case %d: Matches returned %v wanted %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	ub, err := setupBluetooth()
+	if err != nil {
+		t.Fatalf("setupBluetooth error %v\n", err)
+	}
+	defer ub.Close()
+
+	results := make(chan *u.ScannedDevice)
+	go func() {
+		for sd := range results {
+			fmt.Printf("Scanned: %s %s %d\n", sd.BluetoothAddress, sd.DeviceName, sd.Rssi)
+		}
+	}()
+
+	table, err := ub.Scan(context.Background(), u.ScanOptions{
+		DiscoveryType: u.DiscoverAllWithDuplicates,
+		Mode:          u.ActiveScan,
+		Duration:      3 * time.Second,
+		MinRSSI:       -90,
+		Results:       results,
+	})
+	close(results)
+	if err != nil {
+		t.Errorf("Scan error %v\n", err)
+	}
+	for _, sd := range table {
+		fmt.Printf("Device: %s seen %d times, average RSSI %.1f\n", sd.BluetoothAddress, sd.Sightings, sd.AverageRssi)
+	}
+}
@@ -0,0 +1,167 @@
+package ubloxbluetooth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestSecurityReplies(t *testing.T) {
+	mode, securityType, err := u.ProcessSecurityModeReply([]byte("+UBTSM:4,1"))
+	if err != nil || mode != 4 || securityType != 1 {
+		t.Errorf("ProcessSecurityModeReply got %d %d %v", mode, securityType, err)
+	}
+
+	address, value, err := u.ProcessAddressIntEvent([]byte("+UUBTUC:CE1A0B7E9D79r,123456"), []byte("+UUBTUC:"))
+	if err != nil || address != "CE1A0B7E9D79r" || value != 123456 {
+		t.Errorf("ProcessAddressIntEvent got %s %d %v", address, value, err)
+	}
+	address, value, err = u.ProcessAddressIntEvent([]byte("+UUBTUPE:CE1A0B7E9D79r"), []byte("+UUBTUPE:"))
+	if err != nil || address != "CE1A0B7E9D79r" || value != -1 {
+		t.Errorf("ProcessAddressIntEvent without value got %s %d %v", address, value, err)
+	}
+	_, _, err = u.ProcessAddressIntEvent([]byte("+UUBTB:"), []byte("+UUBTB:"))
+	if err == nil {
+		t.Errorf("ProcessAddressIntEvent expected an error for an empty event")
+	}
+
+	if c := u.UserPasskeyEntryCommand("CE1A0B7E9D79r", true, 4321).Cmd; c != "AT+UBTUPE=CE1A0B7E9D79r,1,004321" {
+		t.Errorf("UserPasskeyEntryCommand got %s", c)
+	}
+}
+
+// waitForRequest returns the next request the dongle receives that contains `cmd`
+func waitForRequest(t *testing.T, d *fakeDongle, cmd string) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case b := <-d.received:
+			if strings.Contains(string(b), cmd) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("dongle did not receive %s", cmd)
+		}
+	}
+}
+
+func TestPairingHandler(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	bonded := make(chan u.BondStatus, 1)
+	ub.SetPairingHandler(&u.PairingHandler{
+		PasskeyEntry: func(address string) (int, bool) {
+			return 4321, address == "CE1A0B7E9D79r"
+		},
+		NumericComparison: func(address string, value int) bool {
+			return value == 123456
+		},
+		Bonded: func(address string, status u.BondStatus) {
+			bonded <- status
+		},
+	})
+
+	dongle.event("+UUBTUC:CE1A0B7E9D79r,123456")
+	waitForRequest(t, dongle, "AT+UBTUC=CE1A0B7E9D79r,1")
+	dongle.event("+UUBTUC:CE1A0B7E9D79r,654321")
+	waitForRequest(t, dongle, "AT+UBTUC=CE1A0B7E9D79r,0")
+	dongle.event("+UUBTUPE:CE1A0B7E9D79r")
+	waitForRequest(t, dongle, "AT+UBTUPE=CE1A0B7E9D79r,1,004321")
+
+	dongle.event("+UUBTB:CE1A0B7E9D79r,3")
+	select {
+	case s := <-bonded:
+		if s != u.BondFailedMIC {
+			t.Errorf("Bonded got status %d", s)
+		}
+	case <-time.After(timeout):
+		t.Errorf("Bonded was not called")
+	}
+
+	// the OKs to the answers must not be taken as the reply to a command
+	err = ub.ATCommand()
+	if err != nil {
+		t.Errorf("ATCommand error %v", err)
+	}
+
+	ub.SetPairingHandler(nil)
+	dongle.event("+UUBTUPE:CE1A0B7E9D79r")
+	waitForRequest(t, dongle, "AT+UBTUPE=CE1A0B7E9D79r,0,000000")
+}
+
+func TestPairingDuringCommand(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+	ub.SetPairingHandler(&u.PairingHandler{
+		NumericComparison: func(address string, value int) bool {
+			return true
+		},
+	})
+
+	// the request arrives while AT is in progress and is answered before AT's OK is sent,
+	// the answer's ERROR must not be taken as AT's reply
+	dongle.fail("AT+UBTUC=")
+	dongle.responder("AT\r", func(string) ([][]byte, [][]byte) {
+		dongle.event("+UUBTUC:CE1A0B7E9D79r,123456")
+		time.Sleep(100 * time.Millisecond)
+		return nil, nil
+	})
+	err = ub.ATCommand()
+	if err != nil {
+		t.Fatalf("ATCommand error %v", err)
+	}
+	waitForRequest(t, dongle, "AT+UBTUC=CE1A0B7E9D79r,1")
+
+	dongle.responder("AT\r", func(string) ([][]byte, [][]byte) {
+		return nil, nil
+	})
+	err = ub.ATCommand()
+	if err != nil {
+		t.Errorf("ATCommand after the answer error %v", err)
+	}
+}
+
+func TestPairingAfterTimeout(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+	ub.SetPairingHandler(&u.PairingHandler{
+		NumericComparison: func(address string, value int) bool {
+			return true
+		},
+	})
+
+	// AT is never answered, so the OK to the answer that follows must not be taken as its reply
+	dongle.ignore("AT\r")
+	err = ub.ATCommand()
+	if err == nil {
+		t.Fatalf("expected ATCommand to time out")
+	}
+	dongle.event("+UUBTUC:CE1A0B7E9D79r,123456")
+	waitForRequest(t, dongle, "AT+UBTUC=CE1A0B7E9D79r,1")
+	time.Sleep(50 * time.Millisecond)
+
+	// nor passed on to the next command
+	dongle.fail("AT\r")
+	err = ub.ATCommand()
+	if err == nil || err.Error() != "ERROR" {
+		t.Errorf("expected ATCommand to fail with ERROR, got %v", err)
+	}
+}
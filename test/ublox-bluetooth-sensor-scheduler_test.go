@@ -0,0 +1,323 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+var _ u.SensorClient = (*u.UbloxBluetooth)(nil)
+
+// fakeSensors stands in for a module talking to VEH sensors
+type fakeSensors struct {
+	connected   string
+	calls       []string
+	failGetInfo map[string]int
+	info        map[string]*u.InfoReply
+	slots       map[string]int
+	config      u.ConfigReply
+	table       u.DeviceTable
+	clockSet    time.Time
+	dropSlots   int
+	scanErr     error
+}
+
+func (f *fakeSensors) Scan(ctx context.Context, opts u.ScanOptions) (u.DeviceTable, error) {
+	f.calls = append(f.calls, "Scan")
+	if f.scanErr != nil {
+		return nil, f.scanErr
+	}
+	return f.table, nil
+}
+
+func (f *fakeSensors) ConnectToDevice(address string, onConnect u.DeviceEvent, onDisconnect u.DeviceEvent) error {
+	f.connected = address
+	f.calls = append(f.calls, "Connect "+address)
+	return onConnect()
+}
+
+func (f *fakeSensors) DisconnectFromDevice() error {
+	f.calls = append(f.calls, "Disconnect "+f.connected)
+	f.connected = ""
+	return nil
+}
+
+func (f *fakeSensors) EnableNotifications() error { return nil }
+func (f *fakeSensors) EnableIndications() error   { return nil }
+
+func (f *fakeSensors) UnlockDevice(password []byte) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeSensors) GetInfo() (*u.InfoReply, error) {
+	if f.failGetInfo[f.connected] > 0 {
+		f.failGetInfo[f.connected]--
+		return nil, fmt.Errorf("Timeout")
+	}
+	return f.info[f.connected], nil
+}
+
+func (f *fakeSensors) SetTime(t time.Time) error {
+	f.calls = append(f.calls, "SetTime "+f.connected)
+	f.clockSet = t
+	f.info[f.connected].CurrentTime = int(t.Unix())
+	return nil
+}
+
+func (f *fakeSensors) GetVersion() (*u.VersionReply, error) {
+	return &u.VersionReply{SoftwareVersion: "1.0"}, nil
+}
+
+func (f *fakeSensors) ReadConfig() (*u.ConfigReply, error) {
+	c := f.config
+	return &c, nil
+}
+
+func (f *fakeSensors) WriteConfig(cfg *u.ConfigReply) error {
+	f.config = *cfg
+	return nil
+}
+
+func (f *fakeSensors) DownloadEventLog(startingIndex int, fn u.DownloadNotificationHandler) error {
+	f.calls = append(f.calls, fmt.Sprintf("DownloadEventLog %d", startingIndex))
+	for i := startingIndex; i < f.info[f.connected].CurrentSequenceNumber; i++ {
+		fn([]byte(fmt.Sprintf("event %d", i)))
+	}
+	return nil
+}
+
+func (f *fakeSensors) ReadSlotCount() (*u.SlotCountReply, error) {
+	return &u.SlotCountReply{Count: f.slots[f.connected]}, nil
+}
+
+func (f *fakeSensors) ReadSlotInfo(slotNumber int) (*u.SlotInfoReply, error) {
+	return &u.SlotInfoReply{Slot: slotNumber, Bytes: 2}, nil
+}
+
+// DownloadSlot sends each slot as two notifications, the second is lost while dropSlots is above zero
+func (f *fakeSensors) DownloadSlot(sd *u.SlotDownload) error {
+	f.calls = append(f.calls, fmt.Sprintf("DownloadSlot %d", sd.Slot))
+	sd.Add(0, []byte(fmt.Sprintf("%02x", sd.Slot)))
+	if f.dropSlots > 0 {
+		f.dropSlots--
+	} else {
+		sd.Add(1, []byte("03"))
+	}
+	sd.Summary = &u.SlotSummary{Status: "00", Notifications: 2}
+	return sd.Verify()
+}
+
+type resultRecorder struct {
+	results []*u.SensorResult
+}
+
+func (rr *resultRecorder) SensorResult(r *u.SensorResult) error {
+	rr.results = append(rr.results, r)
+	return nil
+}
+
+// failingSink fails every result
+type failingSink struct {
+	results int
+}
+
+func (fs *failingSink) SensorResult(r *u.SensorResult) error {
+	fs.results++
+	return fmt.Errorf("sink full")
+}
+
+func TestSensorScheduler(t *testing.T) {
+	fake := &fakeSensors{
+		failGetInfo: map[string]int{"CE1A0B7E9D79r": 1},
+		info: map[string]*u.InfoReply{
+			"CE1A0B7E9D79r": {CurrentSequenceNumber: 10, RecordsCount: 4},
+			"D8C4E2A1B3F5r": {CurrentSequenceNumber: 3, RecordsCount: 3},
+		},
+		slots: map[string]int{"CE1A0B7E9D79r": 2},
+		table: u.DeviceTable{
+			"CE1A0B7E9D79": {BluetoothAddress: "CE1A0B7E9D79r", AverageRssi: -60},
+			"D8C4E2A1B3F5": {BluetoothAddress: "D8C4E2A1B3F5r", AverageRssi: -95},
+		},
+	}
+	sink := &resultRecorder{}
+	s := u.NewSensorScheduler(fake, sink, u.SchedulerOptions{Scan: &u.ScanOptions{}})
+
+	config := &u.ConfigReply{AdvertisingInterval: 1000, SampleTime: 60}
+	s.AddSensor(u.Sensor{
+		Address:  "CE1A0B7E9D79r",
+		Password: password,
+		Policy: u.SensorPolicy{
+			Fetch:        u.FetchEvents | u.FetchSlots,
+			Config:       config,
+			Priority:     1,
+			MinRSSI:      -80,
+			MaxRetries:   2,
+			RetryBackoff: time.Millisecond,
+		},
+	})
+	s.AddSensor(u.Sensor{
+		Address:  "D8C4E2A1B3F5r",
+		Password: password,
+		Policy:   u.SensorPolicy{Fetch: u.FetchInfo, MinRSSI: -80, RetryBackoff: time.Millisecond},
+	})
+
+	// the first attempt fails and the distant sensor is skipped
+	n, err := s.RunDue(context.Background())
+	if err != nil || n != 0 {
+		t.Fatalf("RunDue returned %d %v", n, err)
+	}
+	if len(sink.results) != 2 || sink.results[0].Err == nil || !sink.results[1].Skipped {
+		t.Fatalf("unexpected results %+v %+v", sink.results[0], sink.results[1])
+	}
+	if fake.calls[0] != "Scan" || fake.calls[1] != "Connect CE1A0B7E9D79r" || fake.calls[2] != "Disconnect CE1A0B7E9D79r" {
+		t.Errorf("unexpected calls %v", fake.calls)
+	}
+	state, _ := s.State("CE1A0B7E9D79r")
+	if state.Failures != 1 {
+		t.Errorf("expected 1 failure, got %+v", state)
+	}
+
+	// the retry succeeds
+	time.Sleep(5 * time.Millisecond)
+	fake.table["D8C4E2A1B3F5"].AverageRssi = -70
+	n, err = s.RunDue(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("retry RunDue returned %d %v", n, err)
+	}
+	r := sink.results[2]
+	if r.Err != nil || len(r.Events) != 4 || len(r.Slots) != 2 || !r.ConfigUpdated || len(r.Slots[1].Data) != 4 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if fake.config != *config {
+		t.Errorf("config was not written")
+	}
+	state, _ = s.State("CE1A0B7E9D79r")
+	if state.Failures != 0 || state.NextEventIndex != 10 || state.SlotsDownloaded != 2 || !state.NextDue.After(time.Now().Add(time.Hour)) {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// only new events and slots are downloaded next time
+	fake.info["CE1A0B7E9D79r"].CurrentSequenceNumber = 12
+	fake.slots["CE1A0B7E9D79r"] = 3
+	state.NextDue = time.Now()
+	s.SetState("CE1A0B7E9D79r", state)
+	fake.calls = nil
+	_, err = s.RunDue(context.Background())
+	r = sink.results[len(sink.results)-1]
+	if err != nil || len(r.Events) != 2 || len(r.Slots) != 1 || r.ConfigUpdated {
+		t.Errorf("unexpected incremental result %+v %v", r, err)
+	}
+	if fake.calls[2] != "DownloadEventLog 10" || fake.calls[3] != "DownloadSlot 2" {
+		t.Errorf("unexpected incremental calls %v", fake.calls)
+	}
+}
+
+func TestSensorSchedulerScanError(t *testing.T) {
+	fake := &fakeSensors{
+		info: map[string]*u.InfoReply{
+			"CE1A0B7E9D79r": {CurrentSequenceNumber: 1, RecordsCount: 1},
+			"D8C4E2A1B3F5r": {CurrentSequenceNumber: 1, RecordsCount: 1},
+		},
+		scanErr: u.ErrRadioBusy,
+	}
+	sink := &resultRecorder{}
+	s := u.NewSensorScheduler(fake, sink, u.SchedulerOptions{Scan: &u.ScanOptions{}})
+	s.AddSensor(u.Sensor{
+		Address:  "CE1A0B7E9D79r",
+		Password: password,
+		Policy:   u.SensorPolicy{Fetch: u.FetchInfo, MinRSSI: -80, RetryBackoff: time.Millisecond},
+	})
+	s.AddSensor(u.Sensor{
+		Address:  "D8C4E2A1B3F5r",
+		Password: password,
+		Policy:   u.SensorPolicy{Fetch: u.FetchInfo},
+	})
+
+	// the sensor that needs the scan is skipped, the other is still collected
+	n, err := s.RunDue(context.Background())
+	if n != 1 || errors.Cause(err) != u.ErrRadioBusy {
+		t.Fatalf("RunDue returned %d %v", n, err)
+	}
+	if len(sink.results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(sink.results))
+	}
+	for _, r := range sink.results {
+		switch r.Address {
+		case "CE1A0B7E9D79r":
+			if !r.Skipped || errors.Cause(r.Err) != u.ErrRadioBusy {
+				t.Errorf("unexpected skipped result %+v", r)
+			}
+		case "D8C4E2A1B3F5r":
+			if r.Skipped || r.Err != nil || r.Info == nil {
+				t.Errorf("unexpected collected result %+v", r)
+			}
+		}
+	}
+
+	// the skipped sensor is collected once the scan works again
+	time.Sleep(5 * time.Millisecond)
+	fake.scanErr = nil
+	fake.table = u.DeviceTable{"CE1A0B7E9D79": {BluetoothAddress: "CE1A0B7E9D79r", AverageRssi: -60}}
+	n, err = s.RunDue(context.Background())
+	if n != 1 || err != nil {
+		t.Errorf("second RunDue returned %d %v", n, err)
+	}
+}
+
+func TestSensorSchedulerRunContinues(t *testing.T) {
+	fake := &fakeSensors{
+		info:    map[string]*u.InfoReply{"CE1A0B7E9D79r": {CurrentSequenceNumber: 1, RecordsCount: 1}},
+		scanErr: u.ErrRadioBusy,
+	}
+	sink := &failingSink{}
+	errs := make(chan error, 100)
+	s := u.NewSensorScheduler(fake, sink, u.SchedulerOptions{
+		Scan:         &u.ScanOptions{},
+		ErrorBackoff: time.Millisecond,
+		ErrorHandler: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	s.AddSensor(u.Sensor{
+		Address:  "CE1A0B7E9D79r",
+		Password: password,
+		Policy:   u.SensorPolicy{Fetch: u.FetchInfo, MinRSSI: -80, RetryBackoff: time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// Run keeps going after the scan and sink errors
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if errors.Cause(err) != u.ErrRadioBusy {
+				t.Errorf("unexpected error %v", err)
+			}
+		case err := <-done:
+			t.Fatalf("Run returned %v", err)
+		case <-time.After(timeout):
+			t.Fatal("timed out waiting for an error")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("Run did not stop")
+	}
+}
@@ -0,0 +1,190 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+const storeAddress = "CE1A0B7E9D79r"
+
+func tempStoreDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sensor-store")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	return dir
+}
+
+func TestFileStore(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore error %v", err)
+	}
+
+	now := time.Now().UTC()
+	cfg := u.ConfigReply{AdvertisingInterval: 1000}
+	for i := 0; i < 2; i++ {
+		err = store.UpdateMetadata(storeAddress, &u.VersionReply{SoftwareVersion: "1.2"}, &u.InfoReply{CurrentSequenceNumber: 3}, &u.SensorConfigRecord{At: now, Config: cfg}, now)
+		if err != nil {
+			t.Fatalf("UpdateMetadata error %v", err)
+		}
+	}
+	cfg.SampleTime = 60
+	store.UpdateMetadata(storeAddress, nil, nil, &u.SensorConfigRecord{At: now, Config: cfg, Written: true}, now)
+
+	n, err := store.AddEvents(storeAddress, []u.EventRecord{{Sequence: 0, Data: []byte{1}}, {Sequence: 1, Data: []byte{2}}, {Sequence: 1, Data: []byte{2}}})
+	if err != nil || n != 2 {
+		t.Fatalf("AddEvents returned %d %v", n, err)
+	}
+	n, _ = store.AddEvents(storeAddress, []u.EventRecord{{Sequence: 1}, {Sequence: 2, Data: []byte{3}}})
+	if n != 1 {
+		t.Errorf("expected 1 new event, got %d", n)
+	}
+	n, _ = store.AddSlots(storeAddress, []u.SlotRecording{{Time: 100, Slot: 0, Data: []byte{9}}, {Time: 200, Slot: 0}, {Time: 100, Slot: 0}})
+	if n != 2 {
+		t.Errorf("expected 2 new slots, got %d", n)
+	}
+
+	changes, _ := store.Changes(storeAddress, 0)
+	if len(changes.Events) != 3 || len(changes.Slots) != 2 || changes.Revision != 5 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	err = store.SetSyncCursor("exporter", storeAddress, changes.Revision)
+	if err != nil {
+		t.Fatalf("SetSyncCursor error %v", err)
+	}
+	store.AddEvents(storeAddress, []u.EventRecord{{Sequence: 3}})
+	store.Close()
+
+	// simulate a crash part way through an append
+	f, _ := os.OpenFile(filepath.Join(dir, "CE1A0B7E9D79", "events.jsonl"), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"Sequence":4,"Da`)
+	f.Close()
+
+	store, err = u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("reopen error %v", err)
+	}
+	defer store.Close()
+
+	cursor, _ := store.SyncCursor("exporter", storeAddress)
+	changes, _ = store.Changes(storeAddress, cursor)
+	if cursor != 5 || len(changes.Events) != 1 || changes.Events[0].Sequence != 3 || len(changes.Slots) != 0 {
+		t.Errorf("unexpected changes since %d: %+v", cursor, changes)
+	}
+	last, ok, _ := store.LastEventSequence(storeAddress)
+	if !ok || last != 3 {
+		t.Errorf("LastEventSequence returned %d %v", last, ok)
+	}
+	has, _ := store.HasSlot(storeAddress, 200, 0)
+	if !has {
+		t.Errorf("slot 200/0 was not kept")
+	}
+
+	m, _ := store.Metadata(storeAddress)
+	if m == nil || m.Version.SoftwareVersion != "1.2" || m.Info.CurrentSequenceNumber != 3 || len(m.ConfigHistory) != 2 || !m.ConfigHistory[1].Written {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+	sensors, _ := store.Sensors()
+	if len(sensors) != 1 || sensors[0] != "CE1A0B7E9D79" {
+		t.Errorf("unexpected sensors %v", sensors)
+	}
+
+	n, err = store.AddEvents(storeAddress, []u.EventRecord{{Sequence: 4}})
+	if err != nil || n != 1 {
+		t.Errorf("append after recovery returned %d %v", n, err)
+	}
+}
+
+func TestFileStoreBrokenLine(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore error %v", err)
+	}
+	store.AddEvents(storeAddress, []u.EventRecord{{Sequence: 0}})
+	store.AddSlots(storeAddress, []u.SlotRecording{{Time: 100, Slot: 0}})
+	store.Close()
+
+	// a short write left a partial line in the middle of each file
+	for _, name := range []string{"events.jsonl", "slots.jsonl"} {
+		f, _ := os.OpenFile(filepath.Join(dir, "CE1A0B7E9D79", name), os.O_WRONLY|os.O_APPEND, 0644)
+		f.WriteString(`{"Sequence":1,"Da` + "\n")
+		f.Close()
+	}
+
+	store, err = u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("reopen error %v", err)
+	}
+	store.AddEvents(storeAddress, []u.EventRecord{{Sequence: 1}})
+	store.AddSlots(storeAddress, []u.SlotRecording{{Time: 200, Slot: 0}})
+	store.Close()
+
+	store, err = u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("second reopen error %v", err)
+	}
+	defer store.Close()
+	changes, _ := store.Changes(storeAddress, 0)
+	if len(changes.Events) != 2 || len(changes.Slots) != 2 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestSensorSchedulerWithStore(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	store, err := u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore error %v", err)
+	}
+	defer store.Close()
+
+	fake := &fakeSensors{
+		info:  map[string]*u.InfoReply{storeAddress: {CurrentSequenceNumber: 5, RecordsCount: 5}},
+		slots: map[string]int{storeAddress: 1},
+	}
+	sensor := u.Sensor{Address: storeAddress, Password: password, Policy: u.SensorPolicy{Fetch: u.FetchEvents | u.FetchSlots | u.FetchConfig}}
+
+	s := u.NewSensorScheduler(fake, nil, u.SchedulerOptions{Store: store})
+	s.AddSensor(sensor)
+	_, err = s.RunDue(context.Background())
+	if err != nil {
+		t.Fatalf("RunDue error %v", err)
+	}
+
+	// a new scheduler, as after a restart, only downloads what the store does not hold
+	fake.info[storeAddress].CurrentSequenceNumber = 7
+	fake.slots[storeAddress] = 2
+	fake.calls = nil
+	s = u.NewSensorScheduler(fake, nil, u.SchedulerOptions{Store: store})
+	s.AddSensor(sensor)
+	_, err = s.RunDue(context.Background())
+	if err != nil {
+		t.Fatalf("RunDue error %v", err)
+	}
+	if fake.calls[1] != "DownloadEventLog 5" || fake.calls[2] != "DownloadSlot 1" || len(fake.calls) != 4 {
+		t.Errorf("unexpected calls %v", fake.calls)
+	}
+
+	changes, _ := store.Changes(storeAddress, 0)
+	if len(changes.Events) != 7 || len(changes.Slots) != 2 || changes.Events[6].Sequence != 6 {
+		t.Errorf("unexpected store contents %+v", changes)
+	}
+	m, _ := store.Metadata(storeAddress)
+	if m == nil || len(m.ConfigHistory) != 1 {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+}
@@ -0,0 +1,186 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+var _ u.SyncClient = (*u.UbloxBluetooth)(nil)
+
+const syncAddress = "CE1A0B7E9D79r"
+
+func (f *fakeSensors) EraseSlotData() error {
+	f.calls = append(f.calls, "EraseSlotData")
+	return nil
+}
+
+func (f *fakeSensors) ClearEventLog() error {
+	f.calls = append(f.calls, "ClearEventLog")
+	return nil
+}
+
+// syncSensors records more slots and events on the sensor once the first slot has been downloaded
+type syncSensors struct {
+	*fakeSensors
+	newSlots  int
+	newEvents int
+}
+
+func (s *syncSensors) DownloadSlot(sd *u.SlotDownload) error {
+	err := s.fakeSensors.DownloadSlot(sd)
+	if sd.Slot == 0 {
+		s.slots[s.connected] += s.newSlots
+		s.info[s.connected].CurrentSequenceNumber += s.newEvents
+	}
+	return err
+}
+
+// syncRecorder is a SyncSink that refuses the slots in failSlots
+type syncRecorder struct {
+	events    []u.EventRecord
+	slots     []u.SlotRecording
+	failSlots map[int]bool
+}
+
+func (r *syncRecorder) SyncEvents(address string, events []u.EventRecord) error {
+	r.events = append(r.events, events...)
+	return nil
+}
+
+func (r *syncRecorder) SyncSlot(address string, slot u.SlotRecording) error {
+	if r.failSlots[slot.Slot] {
+		return fmt.Errorf("disk full")
+	}
+	r.slots = append(r.slots, slot)
+	return nil
+}
+
+func newSyncSensors(slots int, events int) *syncSensors {
+	return &syncSensors{fakeSensors: &fakeSensors{
+		info:  map[string]*u.InfoReply{syncAddress: {CurrentSequenceNumber: events}},
+		slots: map[string]int{syncAddress: slots},
+	}}
+}
+
+func TestSyncSensor(t *testing.T) {
+	fake := newSyncSensors(2, 3)
+	sink := &syncRecorder{}
+	r, err := u.SyncSensor(context.Background(), fake, syncAddress, u.SyncOptions{Password: password, Sink: sink, EraseSlots: true, ClearEvents: true})
+	if err != nil {
+		t.Fatalf("SyncSensor error %v", err)
+	}
+
+	expected := []string{"Connect " + syncAddress, "DownloadSlot 0", "DownloadSlot 1", "DownloadEventLog 0", "EraseSlotData", "ClearEventLog", "Disconnect " + syncAddress}
+	if !reflect.DeepEqual(fake.calls, expected) {
+		t.Errorf("calls %v", fake.calls)
+	}
+	if len(sink.slots) != 2 || string(sink.slots[1].Data) != "0103" || len(sink.events) != 3 || sink.events[2].Sequence != 2 {
+		t.Errorf("sink %+v", sink)
+	}
+	if !r.SlotsErased || !r.Slots[1].Erased || r.Events != 3 || r.NextEventIndex != 3 || !r.EventsSynced || !r.EventsCleared {
+		t.Errorf("result %+v", r)
+	}
+}
+
+func TestSyncSensorPartialFailure(t *testing.T) {
+	fake := newSyncSensors(3, 2)
+	fake.dropSlots = 3
+	sink := &syncRecorder{failSlots: map[int]bool{2: true}}
+	r, err := u.SyncSensor(context.Background(), fake, syncAddress, u.SyncOptions{Password: password, Sink: sink, EraseSlots: true, ClearEvents: true})
+	if u.IsIncompleteDownload(err) == nil {
+		t.Errorf("expected slot 0's download to be returned, got %v", err)
+	}
+
+	expected := []string{"Connect " + syncAddress, "DownloadSlot 0", "DownloadSlot 0", "DownloadSlot 0", "DownloadSlot 1", "DownloadSlot 2", "DownloadEventLog 0", "ClearEventLog", "Disconnect " + syncAddress}
+	if !reflect.DeepEqual(fake.calls, expected) {
+		t.Errorf("calls %v", fake.calls)
+	}
+	if r.SlotsErased || len(r.Slots) != 3 {
+		t.Fatalf("result %+v", r)
+	}
+	// the synced slot is kept with the others, it can only be erased with them
+	if r.Slots[0].Err == nil || r.Slots[0].Erased || !r.Slots[1].Synced || r.Slots[1].Erased || r.Slots[2].Err == nil || r.Slots[2].Erased {
+		t.Errorf("slots %+v", r.Slots)
+	}
+	if !r.EventsCleared || len(sink.events) != 2 {
+		t.Errorf("events %+v, sink %v", r, sink.events)
+	}
+}
+
+func TestSyncSensorRecordsDuringSync(t *testing.T) {
+	fake := newSyncSensors(2, 3)
+	fake.newSlots = 1
+	fake.newEvents = 2
+	sink := &syncRecorder{}
+	r, err := u.SyncSensor(context.Background(), fake, syncAddress, u.SyncOptions{Password: password, Sink: sink, EventIndex: 1, EraseSlots: true, ClearEvents: true})
+	if err != nil {
+		t.Fatalf("SyncSensor error %v", err)
+	}
+
+	// the slot count is read before the new slot, which keeps the slots, the events after the new events
+	expected := []string{"Connect " + syncAddress, "DownloadSlot 0", "DownloadSlot 1", "DownloadEventLog 1", "ClearEventLog", "Disconnect " + syncAddress}
+	if !reflect.DeepEqual(fake.calls, expected) {
+		t.Errorf("calls %v", fake.calls)
+	}
+	if r.SlotsErased || r.SlotCount != 2 || r.Events != 4 || r.NextEventIndex != 5 || sink.events[0].Sequence != 1 {
+		t.Errorf("result %+v", r)
+	}
+}
+
+func TestSyncSensorNewEventsBeforeClear(t *testing.T) {
+	fake := newSyncSensors(0, 2)
+	sink := &syncRecorder{}
+	fake.info[syncAddress].CurrentSequenceNumber = 2
+	r, err := u.SyncSensor(context.Background(), &eventsAfterDownload{fake.fakeSensors}, syncAddress, u.SyncOptions{Password: password, Sink: sink, ClearEvents: true})
+	if err != nil {
+		t.Fatalf("SyncSensor error %v", err)
+	}
+
+	expected := []string{"Connect " + syncAddress, "DownloadEventLog 0", "DownloadEventLog 2", "ClearEventLog", "Disconnect " + syncAddress}
+	if !reflect.DeepEqual(fake.calls, expected) {
+		t.Errorf("calls %v", fake.calls)
+	}
+	if r.Events != 3 || r.NextEventIndex != 3 || len(sink.events) != 3 || sink.events[2].Sequence != 2 {
+		t.Errorf("result %+v, sink %v", r, sink.events)
+	}
+}
+
+// eventsAfterDownload logs an event once the first event log download has finished
+type eventsAfterDownload struct {
+	*fakeSensors
+}
+
+func (e *eventsAfterDownload) DownloadEventLog(startingIndex int, fn u.DownloadNotificationHandler) error {
+	err := e.fakeSensors.DownloadEventLog(startingIndex, fn)
+	if startingIndex == 0 {
+		e.info[e.connected].CurrentSequenceNumber++
+	}
+	return err
+}
+
+func TestSyncSensorRequirements(t *testing.T) {
+	fake := newSyncSensors(1, 1)
+	_, err := u.SyncSensor(context.Background(), fake, syncAddress, u.SyncOptions{Password: password})
+	if err == nil {
+		t.Errorf("expected an error without a sink")
+	}
+	_, err = u.SyncSensor(context.Background(), fake, syncAddress, u.SyncOptions{Sink: &syncRecorder{}})
+	if err == nil {
+		t.Errorf("expected an error without a password")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = u.SyncSensor(ctx, fake, syncAddress, u.SyncOptions{Password: password, Sink: &syncRecorder{}, EraseSlots: true, ClearEvents: true})
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("a cancelled sync made calls %v", fake.calls)
+	}
+}
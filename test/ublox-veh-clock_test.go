@@ -0,0 +1,103 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestNewClockOffset(t *testing.T) {
+	sent := time.Unix(1000, 0)
+	c := u.NewClockOffset(1010, sent, sent.Add(2*time.Second))
+	if c.Offset != 9*time.Second || c.RoundTrip != 2*time.Second || !c.MeasuredAt.Equal(time.Unix(1001, 0)) {
+		t.Errorf("unexpected offset %+v", c)
+	}
+	if !c.Correct(1110).Equal(time.Unix(1101, 0)) {
+		t.Errorf("unexpected correction %v", c.Correct(1110))
+	}
+}
+
+func TestClockHistory(t *testing.T) {
+	h := u.ClockHistory{
+		{SensorTime: 1000, Offset: 0},
+		{SensorTime: 2000, Offset: 10 * time.Second},
+		{SensorTime: 3010, Offset: 20 * time.Second},
+		{SensorTime: 2990, Offset: 0, Set: true},
+		{SensorTime: 4000, Offset: -4 * time.Second},
+	}
+	tests := []struct {
+		sensorTime int
+		offset     time.Duration
+	}{
+		{500, 0},
+		{1500, 5 * time.Second},
+		{2000, 10 * time.Second},
+		{2505, 15 * time.Second},
+		{2995, -20 * time.Millisecond},
+		{3500, -2020 * time.Millisecond},
+		{5000, -4 * time.Second},
+	}
+	for _, tc := range tests {
+		offset := h.Offset(tc.sensorTime)
+		if offset < tc.offset-time.Millisecond || offset > tc.offset+time.Millisecond {
+			t.Errorf("Offset(%d) returned %v expected %v", tc.sensorTime, offset, tc.offset)
+		}
+	}
+	if !h.Correct(1500).Equal(time.Unix(1495, 0)) {
+		t.Errorf("unexpected correction %v", h.Correct(1500))
+	}
+	if u.ClockHistory(nil).Offset(100) != 0 {
+		t.Errorf("empty history has an offset")
+	}
+}
+
+func TestSensorSchedulerSyncClock(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	store, err := u.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore error %v", err)
+	}
+	defer store.Close()
+
+	fake := &fakeSensors{
+		info: map[string]*u.InfoReply{storeAddress: {CurrentTime: int(time.Now().Add(-time.Hour).Unix())}},
+	}
+	sensor := u.Sensor{Address: storeAddress, Password: password, Policy: u.SensorPolicy{SyncClock: time.Minute}}
+	s := u.NewSensorScheduler(fake, nil, u.SchedulerOptions{Store: store})
+	s.AddSensor(sensor)
+	_, err = s.RunDue(context.Background())
+	if err != nil {
+		t.Fatalf("RunDue error %v", err)
+	}
+	if fake.clockSet.IsZero() {
+		t.Fatalf("the clock was not set %v", fake.calls)
+	}
+
+	// the clock is now close enough to be left alone
+	fake.calls = nil
+	s.AddSensor(sensor)
+	state, _ := s.State(storeAddress)
+	state.NextDue = time.Now()
+	s.SetState(storeAddress, state)
+	s.RunDue(context.Background())
+	for _, c := range fake.calls {
+		if c == "SetTime "+storeAddress {
+			t.Errorf("the clock was set twice")
+		}
+	}
+
+	m, _ := store.Metadata(storeAddress)
+	if m == nil || len(m.Clock) != 3 || !m.Clock[1].Set || m.Clock[0].Offset > -59*time.Minute || m.Clock[2].Offset < -2*time.Second {
+		t.Fatalf("unexpected clock history %+v", m)
+	}
+	// a slot recorded before the clock was set is corrected by the hour it was behind
+	before := m.Clock[0].SensorTime - 60
+	corrected := m.Clock.Correct(before)
+	if d := corrected.Sub(time.Now().Add(-time.Minute)); d < -5*time.Second || d > 5*time.Second {
+		t.Errorf("unexpected correction %v", corrected)
+	}
+}
@@ -0,0 +1,113 @@
+package ubloxbluetooth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestVehCommandReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		process func([]byte) error
+		ok      string
+		wrong   string
+	}{
+		{"abort", u.ProcessAbortReply, "+UUBTGI:0,13,0900", "+UUBTGI:0,13,1300"},
+		{"abort after slot indication", u.ProcessAbortReply, "+UUBTGI:0,13,10000600+UUBTGI:0,13,0900", "+UUBTGI:0,13,10000600"},
+		{"reboot", u.ProcessRebootReply, "+UUBTGI:0,13,1300", "+UUBTGI:0,13,1302"},
+		{"erase slot", u.ProcessEraseSlotDataReply, "+UUBTGI:0,13,1200", "+UUBTGN:0,16,1200"},
+	}
+	for _, tc := range tests {
+		err := tc.process([]byte(tc.ok))
+		if err != nil {
+			t.Errorf("%s reply %s error %v", tc.name, tc.ok, err)
+		}
+		err = tc.process([]byte(tc.wrong))
+		if err == nil {
+			t.Errorf("%s reply %s accepted", tc.name, tc.wrong)
+		}
+	}
+}
+
+func TestExtendedOpcodes(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	dongle.reply("AT+UBTACLD=", eventFrame("+UUBTACLD:0"))
+	// an opcode from outside the baseline table, standing in for the firmware specification's
+	dongle.reply("AT+UBTGW=0,13,20", eventFrame("+UUBTGI:0,13,2000"))
+
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error {
+		defer ub.DisconnectFromDevice()
+
+		err := ub.SetTime(time.Now())
+		if errors.Cause(err) != u.ErrUnknownOpcode {
+			t.Errorf("SetTime without an opcode expected ErrUnknownOpcode, got %v", err)
+		}
+
+		ub.SetExtendedOpcodes(u.ExtendedOpcodes{SetTime: []byte{0x20}})
+		err = ub.SetTime(time.Now())
+		if err != nil {
+			t.Errorf("SetTime error %v", err)
+		}
+		return nil
+	}, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+
+	if u.ProcessSetTimeReply([]byte("+UUBTGI:0,13,2000"), []byte{0x21}) == nil {
+		t.Errorf("reply to another opcode accepted")
+	}
+}
+
+func TestRebootSensor(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	// the sensor replies and drops the connection after the module's OK
+	dongle.responder("AT+UBTGW=0,13,13", func(string) ([][]byte, [][]byte) {
+		return nil, [][]byte{eventFrame("+UUBTGI:0,13,1300"), eventFrame("+UUBTACLD:0")}
+	})
+
+	disconnected := false
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error {
+		return ub.RebootSensor()
+	}, func() error {
+		disconnected = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RebootSensor error %v", err)
+	}
+	if disconnected {
+		t.Errorf("disconnect handler called for the reboot")
+	}
+	if ub.EnableNotifications() == nil {
+		t.Errorf("still connected after the reboot")
+	}
+	if !ub.IsIdle() {
+		t.Errorf("radio held after the reboot")
+	}
+
+	err = ub.ATCommand()
+	if err != nil {
+		t.Errorf("ATCommand after reboot error %v", err)
+	}
+}
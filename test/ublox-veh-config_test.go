@@ -0,0 +1,77 @@
+package ubloxbluetooth
+
+import (
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+)
+
+func TestSensorConfig(t *testing.T) {
+	raw := u.ConfigReply{AdvertisingInterval: 1000, SampleTime: 60, State: 1, AccelSettings: 0x0151, SpareOne: 7, TemperatureOffset: 0xFFF1}
+	c := raw.SensorConfig()
+	expected := u.SensorConfig{
+		AdvertisingInterval: time.Second,
+		SampleInterval:      time.Minute,
+		Mode:                u.SensorModeActive,
+		AccelRange:          4,
+		AccelDataRate:       100,
+		AccelReserved:       0x0100,
+		Spare:               7,
+		TemperatureOffset:   -1.5,
+	}
+	if c != expected {
+		t.Fatalf("SensorConfig returned %+v", c)
+	}
+	if c.Mode.String() != "active" {
+		t.Errorf("unexpected mode name %s", c.Mode)
+	}
+
+	back, err := c.ConfigReply()
+	if err != nil || *back != raw {
+		t.Errorf("ConfigReply returned %+v %v", back, err)
+	}
+
+	invalid := []func(c *u.SensorConfig){
+		func(c *u.SensorConfig) { c.AdvertisingInterval = 10 * time.Millisecond },
+		func(c *u.SensorConfig) { c.AdvertisingInterval = 1500 * time.Microsecond },
+		func(c *u.SensorConfig) { c.SampleInterval = 0 },
+		func(c *u.SensorConfig) { c.SampleInterval = 100 * time.Hour },
+		func(c *u.SensorConfig) { c.Mode = 9 },
+		func(c *u.SensorConfig) { c.AccelRange = 3 },
+		func(c *u.SensorConfig) { c.AccelDataRate = 5 },
+		func(c *u.SensorConfig) { c.AccelReserved = 0x02 },
+		func(c *u.SensorConfig) { c.TemperatureOffset = 25 },
+	}
+	for i, change := range invalid {
+		bad := expected
+		change(&bad)
+		_, err := bad.ConfigReply()
+		if err == nil {
+			t.Errorf("invalid config %d accepted: %+v", i, bad)
+		}
+	}
+
+	// an unknown data rate code is written back unchanged unless the rate is edited
+	unknown := u.ConfigReply{AdvertisingInterval: 1000, SampleTime: 60, AccelSettings: 0xF1}
+	c = unknown.SensorConfig()
+	if c.AccelDataRate != u.UnknownAccelDataRate {
+		t.Errorf("unexpected data rate %d", c.AccelDataRate)
+	}
+	back, err = c.ConfigReply()
+	if err != nil || *back != unknown {
+		t.Errorf("unknown data rate ConfigReply returned %+v %v", back, err)
+	}
+	c.AccelDataRate = 25
+	back, err = c.ConfigReply()
+	if err != nil || back.AccelSettings != 0x31 {
+		t.Errorf("edited data rate ConfigReply returned %+v %v", back, err)
+	}
+
+	// a config that was not read has no code to fall back on
+	bad := expected
+	bad.AccelDataRate = u.UnknownAccelDataRate
+	if bad.Validate() == nil {
+		t.Errorf("unknown data rate accepted")
+	}
+}
@@ -0,0 +1,239 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+var _ u.DFUTarget = (*u.UbloxBluetooth)(nil)
+
+// virtualSensor holds a firmware update in memory
+type virtualSensor struct {
+	connected   bool
+	version     string
+	newVersion  string
+	size        int
+	crc         uint32
+	received    []byte
+	verified    bool
+	dropAt      int
+	stallAt     int
+	corrupt     bool
+	mtu         int
+	starts      int
+	reconnects  int
+	disconnects int
+	noOpcodes   bool
+}
+
+func (v *virtualSensor) DFUStart(size int, crc uint32) (int, error) {
+	if !v.connected {
+		return 0, fmt.Errorf("ConnectionReply is nil")
+	}
+	v.starts++
+	if v.noOpcodes {
+		return 0, errors.Wrap(u.ErrUnknownOpcode, "DFUStart error")
+	}
+	if size != v.size || crc != v.crc {
+		v.size = size
+		v.crc = crc
+		v.received = nil
+	}
+	return len(v.received), nil
+}
+
+func (v *virtualSensor) DFUWrite(offset int, chunk []byte) (int, error) {
+	if !v.connected {
+		return 0, fmt.Errorf("ConnectionReply is nil")
+	}
+	if offset != len(v.received) {
+		return len(v.received), nil
+	}
+	if v.stallAt > 0 && offset >= v.stallAt {
+		// the sensor stops taking data but keeps the link up
+		return offset, nil
+	}
+	if len(chunk) > v.mtu-8 {
+		return 0, fmt.Errorf("chunk of %d does not fit the MTU", len(chunk))
+	}
+	v.received = append(v.received, chunk...)
+	if v.dropAt > 0 && len(v.received) >= v.dropAt {
+		v.dropAt = 0
+		v.connected = false
+		return 0, fmt.Errorf("Timeout")
+	}
+	return len(v.received), nil
+}
+
+func (v *virtualSensor) DFUVerify() error {
+	if v.corrupt {
+		v.received[0]++
+	}
+	v.verified = len(v.received) == v.size && crc32.ChecksumIEEE(v.received) == v.crc
+	if !v.verified {
+		return u.ErrDFUVerify
+	}
+	return nil
+}
+
+func (v *virtualSensor) RebootSensor() error {
+	v.connected = false
+	if v.verified {
+		v.version = v.newVersion
+	}
+	return nil
+}
+
+func (v *virtualSensor) GetVersion() (*u.VersionReply, error) {
+	if !v.connected {
+		return nil, fmt.Errorf("ConnectionReply is nil")
+	}
+	return &u.VersionReply{SoftwareVersion: v.version}, nil
+}
+
+func (v *virtualSensor) ReadMTU() (int, error) {
+	if !v.connected {
+		return 0, fmt.Errorf("ConnectionReply is nil")
+	}
+	return v.mtu, nil
+}
+
+func (v *virtualSensor) Connected() bool {
+	return v.connected
+}
+
+func (v *virtualSensor) DisconnectFromDevice() error {
+	if !v.connected {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+	v.disconnects++
+	v.connected = false
+	return nil
+}
+
+func (v *virtualSensor) reconnect() error {
+	if v.connected {
+		return fmt.Errorf("already connected")
+	}
+	v.reconnects++
+	v.connected = true
+	v.stallAt = 0
+	return nil
+}
+
+func firmwareImage(size int) []byte {
+	image := make([]byte, size)
+	rand.New(rand.NewSource(42)).Read(image)
+	return image
+}
+
+func TestUpdateFirmware(t *testing.T) {
+	image := firmwareImage(1000)
+	v := &virtualSensor{connected: true, version: "1.0", newVersion: "1.1", dropAt: 600, mtu: 247}
+
+	progress := []u.DFUProgress{}
+	version, err := u.UpdateFirmware(v, image, u.DFUOptions{
+		ChunkSize:   100,
+		Reconnect:   v.reconnect,
+		MaxResumes:  1,
+		RebootDelay: time.Millisecond,
+		Version:     "1.1",
+		Progress: func(p u.DFUProgress) {
+			progress = append(progress, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("UpdateFirmware error %v", err)
+	}
+	if version.SoftwareVersion != "1.1" || v.starts != 2 || v.reconnects != 2 {
+		t.Errorf("unexpected update %+v starts %d reconnects %d", version, v.starts, v.reconnects)
+	}
+
+	// the resume carries on from the sensor's offset rather than starting again
+	sends := 0
+	for _, p := range progress {
+		if p.Stage == u.DFUSending {
+			sends++
+		}
+	}
+	last := progress[len(progress)-1]
+	if sends != 10 || last.Stage != u.DFUComplete || last.Sent != 1000 || last.Resumes != 1 {
+		t.Errorf("unexpected progress %d %+v", sends, last)
+	}
+}
+
+func TestUpdateFirmwareFailures(t *testing.T) {
+	image := firmwareImage(300)
+
+	v := &virtualSensor{connected: true, version: "1.0", newVersion: "1.1", corrupt: true, mtu: 23}
+	_, err := u.UpdateFirmware(v, image, u.DFUOptions{Reconnect: v.reconnect, MaxResumes: 3, RebootDelay: time.Millisecond})
+	if errors.Cause(err) != u.ErrDFUVerify || v.starts != 1 || v.version != "1.0" {
+		t.Errorf("expected ErrDFUVerify without retries, got %v after %d starts", err, v.starts)
+	}
+
+	v = &virtualSensor{connected: true, version: "1.0", newVersion: "1.0", mtu: 23}
+	_, err = u.UpdateFirmware(v, image, u.DFUOptions{Reconnect: v.reconnect, RebootDelay: time.Millisecond, Version: "1.1"})
+	if errors.Cause(err) != u.ErrDFUVersion {
+		t.Errorf("expected ErrDFUVersion, got %v", err)
+	}
+
+	v = &virtualSensor{connected: true, version: "1.0", noOpcodes: true, mtu: 23}
+	_, err = u.UpdateFirmware(v, image, u.DFUOptions{Reconnect: v.reconnect, MaxResumes: 3, RebootDelay: time.Millisecond})
+	if errors.Cause(err) != u.ErrUnknownOpcode || v.starts != 1 || v.reconnects != 0 {
+		t.Errorf("expected ErrUnknownOpcode without retries, got %v after %d starts", err, v.starts)
+	}
+
+	v = &virtualSensor{connected: true, dropAt: 100, mtu: 23}
+	_, err = u.UpdateFirmware(v, image, u.DFUOptions{Reconnect: v.reconnect, RebootDelay: time.Millisecond})
+	if err == nil || v.reconnects != 0 {
+		t.Errorf("expected the disconnect to fail the update without resumes, got %v", err)
+	}
+}
+
+func TestUpdateFirmwareStall(t *testing.T) {
+	image := firmwareImage(300)
+	v := &virtualSensor{connected: true, version: "1.0", newVersion: "1.1", stallAt: 150, mtu: 23}
+	_, err := u.UpdateFirmware(v, image, u.DFUOptions{Reconnect: v.reconnect, MaxResumes: 1, RebootDelay: time.Millisecond, Version: "1.1"})
+	if err != nil {
+		t.Fatalf("UpdateFirmware error %v", err)
+	}
+	// the stalled link is dropped before reconnecting
+	if v.disconnects != 1 || v.reconnects != 2 || v.starts != 2 {
+		t.Errorf("disconnects %d reconnects %d starts %d", v.disconnects, v.reconnects, v.starts)
+	}
+}
+
+func TestDFUReplies(t *testing.T) {
+	// opcodes from outside the baseline table, standing in for the firmware specification's
+	start, data, verify := []byte{0x20}, []byte{0x21}, []byte{0x22}
+	offset, err := u.ProcessDFUStartReply([]byte("+UUBTGI:0,13,200010270000"), start)
+	if err != nil || offset != 10000 {
+		t.Errorf("ProcessDFUStartReply returned %d %v", offset, err)
+	}
+	offset, err = u.ProcessDFUDataReply([]byte("+UUBTGI:0,13,210080000000"), data)
+	if err != nil || offset != 128 {
+		t.Errorf("ProcessDFUDataReply returned %d %v", offset, err)
+	}
+	_, err = u.ProcessDFUDataReply([]byte("+UUBTGI:0,13,2100"), data)
+	if err == nil {
+		t.Errorf("short ProcessDFUDataReply accepted")
+	}
+	_, err = u.ProcessDFUDataReply([]byte("+UUBTGI:0,13,200080000000"), data)
+	if err == nil {
+		t.Errorf("ProcessDFUDataReply accepted another opcode's reply")
+	}
+	err = u.ProcessDFUVerifyReply([]byte("+UUBTGI:0,13,2200"), verify)
+	if err != nil {
+		t.Errorf("ProcessDFUVerifyReply error %v", err)
+	}
+	err = u.ProcessDFUVerifyReply([]byte("+UUBTGI:0,13,2202"), verify)
+	if errors.Cause(err) != u.ErrDFUVerify {
+		t.Errorf("expected ErrDFUVerify, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	u "github.com/RobHumphris/ublox-bluetooth"
+	"github.com/pkg/errors"
+)
+
+func TestProcessSlotDataIndication(t *testing.T) {
+	s, err := u.ProcessSlotDataIndication([]byte("10000600"))
+	if err != nil || s.Notifications != 6 {
+		t.Errorf("indication got %+v %v", s, err)
+	}
+	for _, bad := range []string{"10010600", "07000600", "1000"} {
+		_, err = u.ProcessSlotDataIndication([]byte(bad))
+		if err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestSlotDownloadVerify(t *testing.T) {
+	sd := u.NewSlotDownload(3, &u.SlotInfoReply{Slot: 3, Bytes: 8})
+	sd.Add(0, []byte("00000000"))
+	sd.Add(3, []byte("03030303"))
+	err := sd.Verify()
+	incomplete := u.IsIncompleteDownload(err)
+	if incomplete == nil || !reflect.DeepEqual(incomplete.Missing, []u.SequenceRange{{Start: 1, End: 3}, {Start: 4, End: -1}}) || incomplete.Expected != -1 {
+		t.Fatalf("without a summary got %v", err)
+	}
+
+	sd.Summary = &u.SlotSummary{Status: "00", Notifications: 4}
+	incomplete = u.IsIncompleteDownload(sd.Verify())
+	if incomplete == nil || !reflect.DeepEqual(incomplete.Missing, []u.SequenceRange{{Start: 1, End: 3}}) || incomplete.Received != 2 {
+		t.Fatalf("with a summary got %+v", incomplete)
+	}
+
+	sd.Add(1, []byte("01010101"))
+	sd.Add(2, []byte("02020202"))
+	if err = sd.Verify(); errors.Cause(err) != u.ErrSlotSize {
+		t.Errorf("16 bytes against an info of 8 got %v", err)
+	}
+	sd.Info.Bytes = 16
+	if err = sd.Verify(); err != nil {
+		t.Errorf("Verify error %v", err)
+	}
+	sd.Info.Dwords = 3
+	if err = sd.Verify(); errors.Cause(err) != u.ErrSlotSize {
+		t.Errorf("16 bytes against an info of 3 dwords got %v", err)
+	}
+	sd.Info.Dwords = 4
+	if err = sd.Verify(); err != nil {
+		t.Errorf("Verify with dwords error %v", err)
+	}
+}
+
+// slotSensor answers slot downloads, numbering the notifications from 0 in each download and
+// losing notification `drop` of the first
+type slotSensor struct {
+	lock      sync.Mutex
+	total     int
+	drop      int
+	downloads []int
+}
+
+func (s *slotSensor) notification(seq int) []byte {
+	b := []byte{byte(seq), byte(seq), byte(seq), byte(seq)}
+	return eventFrame(fmt.Sprintf("+UUBTGN:0,16,%x%02x%02x", b, seq&0xFF, seq>>8))
+}
+
+func (s *slotSensor) data() []byte {
+	d := []byte{}
+	for seq := 0; seq < s.total; seq++ {
+		d = append(d, byte(seq), byte(seq), byte(seq), byte(seq))
+	}
+	return d
+}
+
+func (s *slotSensor) download(request string) ([][]byte, [][]byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	params := gattWrite.FindStringSubmatch(request)[1]
+	b, _ := hex.DecodeString(params[6:10])
+	offset := int(binary.LittleEndian.Uint16(b))
+	s.downloads = append(s.downloads, offset)
+
+	remaining := s.total - offset
+	reply := eventFrame(fmt.Sprintf("+UUBTGI:0,13,1000%02x%02x", remaining&0xFF, remaining>>8))
+	frames := [][]byte{}
+	for seq := 0; seq < remaining; seq++ {
+		if len(s.downloads) == 1 && seq == s.drop {
+			continue
+		}
+		frames = append(frames, s.notification(seq))
+	}
+	frames = append(frames, eventFrame(fmt.Sprintf("+UUBTGI:0,13,1000%02x%02x", remaining&0xFF, remaining>>8)))
+	return [][]byte{reply}, frames
+}
+
+func TestDownloadSlotResume(t *testing.T) {
+	dongle := newFakeDongle(t)
+	defer dongle.unplug()
+	ub, err := u.NewUbloxBluetoothOnDevice(dongle.path, timeout)
+	if err != nil {
+		t.Fatalf("NewUbloxBluetoothOnDevice error %v", err)
+	}
+	defer ub.Close()
+
+	sensor := &slotSensor{total: 6, drop: 2}
+	dongle.reply("AT+UBTACLC=", eventFrame("+UUBTACLC:0,0,CE1A0B7E9D79r"))
+	dongle.responder("AT+UBTGW=0,13,10", sensor.download)
+	dongle.reply("AT+UBTGW=0,13,09", eventFrame("+UUBTGI:0,13,0900"))
+	ub.SetFlowControl(u.FlowControlOptions{StallTimeout: 50 * time.Millisecond})
+
+	err = ub.ConnectToDevice("CE1A0B7E9D79r", func() error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatalf("ConnectToDevice error %v", err)
+	}
+
+	sd := u.NewSlotDownload(1, &u.SlotInfoReply{Slot: 1, Dwords: 6, Bytes: 24})
+	err = ub.DownloadSlot(sd)
+	incomplete := u.IsIncompleteDownload(err)
+	if incomplete == nil || !reflect.DeepEqual(incomplete.Missing, []u.SequenceRange{{Start: 2, End: 3}}) || incomplete.Expected != 6 || incomplete.Err == nil {
+		t.Fatalf("first DownloadSlot got %v", err)
+	}
+
+	err = ub.DownloadSlot(sd)
+	if err != nil {
+		t.Fatalf("resumed DownloadSlot error %v", err)
+	}
+	if string(sd.Payload()) != hex.EncodeToString(sensor.data()) {
+		t.Errorf("payload %s", sd.Payload())
+	}
+	sensor.lock.Lock()
+	// the resume starts from the beginning of the slot and is aborted once the gap is filled
+	if !reflect.DeepEqual(sensor.downloads, []int{0, 0}) {
+		t.Errorf("downloads from %v", sensor.downloads)
+	}
+	sensor.lock.Unlock()
+
+	err = ub.ATCommand()
+	if err != nil {
+		t.Errorf("ATCommand after the downloads error %v", err)
+	}
+}
+
+func TestSensorSchedulerResumesSlots(t *testing.T) {
+	fake := &fakeSensors{
+		info:      map[string]*u.InfoReply{"CE1A0B7E9D79r": {}},
+		slots:     map[string]int{"CE1A0B7E9D79r": 1},
+		dropSlots: 1,
+	}
+	sink := &resultRecorder{}
+	s := u.NewSensorScheduler(fake, sink, u.SchedulerOptions{})
+	s.AddSensor(u.Sensor{
+		Address:  "CE1A0B7E9D79r",
+		Password: password,
+		Policy:   u.SensorPolicy{Fetch: u.FetchSlots},
+	})
+	s.RunDue(context.Background())
+
+	r := sink.results[0]
+	if r.Err != nil || len(r.Slots) != 1 || string(r.Slots[0].Data) != "0003" {
+		t.Errorf("result %+v", r)
+	}
+	downloads := 0
+	for _, c := range fake.calls {
+		if c == "DownloadSlot 0" {
+			downloads++
+		}
+	}
+	if downloads != 2 {
+		t.Errorf("expected the slot to be resumed once, calls %v", fake.calls)
+	}
+}
@@ -0,0 +1,213 @@
+package ubloxbluetooth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Advertising Data (AD) types, as assigned in the Bluetooth Core Specification Supplement.
+const (
+	ADTypeFlags                 = byte(0x01)
+	ADTypeIncomplete16BitUUIDs  = byte(0x02)
+	ADTypeComplete16BitUUIDs    = byte(0x03)
+	ADTypeIncomplete128BitUUIDs = byte(0x06)
+	ADTypeComplete128BitUUIDs   = byte(0x07)
+	ADTypeShortenedLocalName    = byte(0x08)
+	ADTypeCompleteLocalName     = byte(0x09)
+	ADTypeTxPowerLevel          = byte(0x0A)
+	ADTypeServiceData16BitUUID  = byte(0x16)
+	ADTypeManufacturerSpecific  = byte(0xFF)
+)
+
+// AdvertisingDataStructure is a single length-type-value element of an advertising packet
+type AdvertisingDataStructure struct {
+	Type byte
+	Data []byte
+}
+
+// ParseAdvertisingData splits the hex encoded advertising data, as reported by +UBTD,
+// into its AD structures.
+func ParseAdvertisingData(data string) ([]AdvertisingDataStructure, error) {
+	b, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("[ParseAdvertisingData] invalid hex data %q", data)
+	}
+
+	ads := []AdvertisingDataStructure{}
+	for i := 0; i < len(b); {
+		l := int(b[i])
+		if l == 0 {
+			// zero length marks the significant part of the packet as finished
+			break
+		}
+		if i+1+l > len(b) {
+			return ads, fmt.Errorf("[ParseAdvertisingData] AD structure at %d overruns data (length %d)", i, l)
+		}
+		ads = append(ads, AdvertisingDataStructure{
+			Type: b[i+1],
+			Data: b[i+2 : i+1+l],
+		})
+		i += 1 + l
+	}
+	return ads, nil
+}
+
+// reverseBytes returns a reversed copy of b, advertised UUIDs are little endian.
+func reverseBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+// NormaliseUUID strips hyphens and upper cases the UUID so that
+// "2456e1b9-26e2-8f83-e744-f34f01e9d701" and "2456E1B926E28F83E744F34F01E9D701" compare equal.
+func NormaliseUUID(uuid string) string {
+	return strings.ToUpper(strings.Replace(uuid, "-", "", -1))
+}
+
+// AdvertisedServiceUUIDs returns the normalised 16 and 128 bit service UUIDs found in the AD structures
+func AdvertisedServiceUUIDs(ads []AdvertisingDataStructure) []string {
+	uuids := []string{}
+	for _, ad := range ads {
+		size := 0
+		switch ad.Type {
+		case ADTypeIncomplete16BitUUIDs, ADTypeComplete16BitUUIDs:
+			size = 2
+		case ADTypeIncomplete128BitUUIDs, ADTypeComplete128BitUUIDs:
+			size = 16
+		default:
+			continue
+		}
+		for i := 0; i+size <= len(ad.Data); i += size {
+			uuids = append(uuids, NormaliseUUID(hex.EncodeToString(reverseBytes(ad.Data[i:i+size]))))
+		}
+	}
+	return uuids
+}
+
+// AdvertisedLocalName returns the complete, or failing that the shortened, local name.
+func AdvertisedLocalName(ads []AdvertisingDataStructure) string {
+	name := ""
+	for _, ad := range ads {
+		switch ad.Type {
+		case ADTypeCompleteLocalName:
+			return string(ad.Data)
+		case ADTypeShortenedLocalName:
+			name = string(ad.Data)
+		}
+	}
+	return name
+}
+
+// maxADStructureLength is the longest data a single length byte can describe alongside the type byte
+const maxADStructureLength = 254
+
+// EncodeAdvertisingData concatenates the AD structures into an advertising payload
+func EncodeAdvertisingData(ads ...AdvertisingDataStructure) ([]byte, error) {
+	b := []byte{}
+	for _, ad := range ads {
+		if len(ad.Data) > maxADStructureLength {
+			return nil, fmt.Errorf("[EncodeAdvertisingData] AD type %02X data too long (%d bytes)", ad.Type, len(ad.Data))
+		}
+		b = append(b, byte(len(ad.Data)+1), ad.Type)
+		b = append(b, ad.Data...)
+	}
+	return b, nil
+}
+
+// Company identifiers and service UUIDs used by the beacon formats
+var appleCompanyID = []byte{0x4C, 0x00}
+var eddystoneServiceUUID = []byte{0xAA, 0xFE}
+
+const iBeaconType = byte(0x02)
+const iBeaconLength = byte(0x15)
+const eddystoneUIDFrame = byte(0x00)
+const eddystoneURLFrame = byte(0x10)
+const eddystoneMaxURLLength = 17
+
+// IBeaconAdvertisingData builds the manufacturer specific AD structure of an iBeacon, `uuid` is the
+// proximity UUID and `txPower` the measured RSSI at 1 metre.
+func IBeaconAdvertisingData(uuid string, major uint16, minor uint16, txPower int8) ([]byte, error) {
+	u, err := hex.DecodeString(NormaliseUUID(uuid))
+	if err != nil || len(u) != 16 {
+		return nil, fmt.Errorf("[IBeaconAdvertisingData] invalid proximity UUID %q", uuid)
+	}
+
+	d := append([]byte{}, appleCompanyID...)
+	d = append(d, iBeaconType, iBeaconLength)
+	d = append(d, u...)
+	d = append(d, byte(major>>8), byte(major), byte(minor>>8), byte(minor), byte(txPower))
+	return EncodeAdvertisingData(AdvertisingDataStructure{Type: ADTypeManufacturerSpecific, Data: d})
+}
+
+func eddystoneAdvertisingData(frame []byte) ([]byte, error) {
+	return EncodeAdvertisingData(
+		AdvertisingDataStructure{Type: ADTypeComplete16BitUUIDs, Data: eddystoneServiceUUID},
+		AdvertisingDataStructure{Type: ADTypeServiceData16BitUUID, Data: append(append([]byte{}, eddystoneServiceUUID...), frame...)},
+	)
+}
+
+// EddystoneUIDAdvertisingData builds an Eddystone-UID frame from the 10 byte `namespace` and 6 byte
+// `instance`, `txPower` is the calibrated power at 0 metres.
+func EddystoneUIDAdvertisingData(namespace []byte, instance []byte, txPower int8) ([]byte, error) {
+	if len(namespace) != 10 || len(instance) != 6 {
+		return nil, fmt.Errorf("[EddystoneUIDAdvertisingData] namespace must be 10 bytes and instance 6 bytes (got %d and %d)", len(namespace), len(instance))
+	}
+
+	frame := []byte{eddystoneUIDFrame, byte(txPower)}
+	frame = append(frame, namespace...)
+	frame = append(frame, instance...)
+	frame = append(frame, 0x00, 0x00)
+	return eddystoneAdvertisingData(frame)
+}
+
+var eddystoneURLSchemes = []string{"http://www.", "https://www.", "http://", "https://"}
+
+var eddystoneURLExpansions = []string{
+	".com/", ".org/", ".edu/", ".net/", ".info/", ".biz/", ".gov/",
+	".com", ".org", ".edu", ".net", ".info", ".biz", ".gov",
+}
+
+// EddystoneURLAdvertisingData builds an Eddystone-URL frame, the encoded URL must fit in 17 bytes.
+func EddystoneURLAdvertisingData(url string, txPower int8) ([]byte, error) {
+	scheme := -1
+	for i, s := range eddystoneURLSchemes {
+		if strings.HasPrefix(url, s) {
+			scheme = i
+			url = url[len(s):]
+			break
+		}
+	}
+	if scheme == -1 {
+		return nil, fmt.Errorf("[EddystoneURLAdvertisingData] unsupported URL scheme %q", url)
+	}
+
+	encoded := []byte{}
+	for len(url) > 0 {
+		expanded := false
+		for code, e := range eddystoneURLExpansions {
+			if strings.HasPrefix(url, e) {
+				encoded = append(encoded, byte(code))
+				url = url[len(e):]
+				expanded = true
+				break
+			}
+		}
+		if !expanded {
+			if url[0] <= 0x20 || url[0] >= 0x7F {
+				return nil, fmt.Errorf("[EddystoneURLAdvertisingData] invalid URL character %q", url[0])
+			}
+			encoded = append(encoded, url[0])
+			url = url[1:]
+		}
+	}
+	if len(encoded) > eddystoneMaxURLLength {
+		return nil, fmt.Errorf("[EddystoneURLAdvertisingData] encoded URL too long (%d bytes)", len(encoded))
+	}
+
+	frame := []byte{eddystoneURLFrame, byte(txPower), byte(scheme)}
+	return eddystoneAdvertisingData(append(frame, encoded...))
+}
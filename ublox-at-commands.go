@@ -24,6 +24,14 @@ func EchoOffCommand() CmdResp {
 	}
 }
 
+// EchoOnCommand turns the echo back on
+func EchoOnCommand() CmdResp {
+	return CmdResp{
+		Cmd:  echoOn,
+		Resp: empty,
+	}
+}
+
 // RS232SettingsCommand gets or sets the ublox serial port settings
 func RS232SettingsCommand(cmd string) CmdResp {
 	if cmd == "" {
@@ -47,6 +55,31 @@ func WatchdogCommand(t int, v int) CmdResp {
 	}
 }
 
+// WatchdogQueryCommand reads all of the watchdog settings
+func WatchdogQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", watchdogSettings),
+		Resp: watchdogSettingsResponseString,
+	}
+}
+
+// InformationCommand builds the identification queries (+CGMI, +CGMM, +CGMR, +GMR and +CGSN)
+// which reply with unprefixed information text.
+func InformationCommand(cmd string) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s", cmd),
+		Resp: empty,
+	}
+}
+
+// LocalAddressCommand reads the module's address for the `iface` interface (1 is Bluetooth)
+func LocalAddressCommand(iface int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d", localAddress, iface),
+		Resp: localAddressResponseString,
+	}
+}
+
 // FactoryResetCommand sets the ublox device to its Factory settings
 func FactoryResetCommand() CmdResp {
 	return CmdResp{
@@ -63,6 +96,14 @@ func ModuleStartCommand(mode StartMode) CmdResp {
 	}
 }
 
+// ModuleStartQueryCommand reads the ublox device's Start mode
+func ModuleStartQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", moduleStartMode),
+		Resp: moduleStartModeResponseString,
+	}
+}
+
 // RebootCommand - demands a reboot
 func RebootCommand() CmdResp {
 	return CmdResp{
@@ -95,6 +136,14 @@ func DiscoveryCommand() CmdResp {
 	}
 }
 
+// ScanCommand - discovery with the type, active/passive mode, and duration (in milliseconds) specified
+func ScanCommand(discoveryType DiscoveryType, mode ScanMode, durationMs int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d,%d,%d", discovery, discoveryType, mode, durationMs),
+		Resp: discoveryResponseString,
+	}
+}
+
 // BLERole - for setting the role with one of the following constants:
 // bleDisabled  0
 // bleCentral 1
@@ -107,6 +156,14 @@ func BLERole(role int) CmdResp {
 	}
 }
 
+// BLERoleQueryCommand reads the current Bluetooth LE role
+func BLERoleQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", bleRole),
+		Resp: bleRoleResponseString,
+	}
+}
+
 // BLEConfig sets the Bluetooth LE config (see: 6.26.3 Defined values) from:
 // https://www.u-blox.com/sites/default/files/u-blox-SHO_ATCommands_%28UBX-14044127%29.pdf
 func BLEConfig(param int, val int) CmdResp {
@@ -116,6 +173,143 @@ func BLEConfig(param int, val int) CmdResp {
 	}
 }
 
+// BLEConfigQueryCommand reads all of the Bluetooth LE config parameters
+func BLEConfigQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", bleConfiguration),
+		Resp: bleConfigurationResponseString,
+	}
+}
+
+// LocalNameCommand sets the module's Bluetooth local name
+func LocalNameCommand(name string) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=\"%s\"", localName, name),
+		Resp: empty,
+	}
+}
+
+// LocalNameQueryCommand reads the module's Bluetooth local name
+func LocalNameQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", localName),
+		Resp: localNameResponseString,
+	}
+}
+
+// BondedDevicesCommand lists the devices that the module is bonded with
+func BondedDevicesCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s", bondedDevices),
+		Resp: bondedDevicesResponseString,
+	}
+}
+
+// PHYRequestCommand asks for the PHYs of the connection `handle`, a PHY of zero leaves the
+// choice to the peer
+func PHYRequestCommand(handle int, tx int, rx int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d,%d,%d", blePHYRequest, handle, tx, rx),
+		Resp: empty,
+	}
+}
+
+// PHYQueryCommand reads the PHYs of the connection `handle`
+func PHYQueryCommand(handle int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d", blePHYRequest, handle),
+		Resp: blePHYRequestResponseString,
+	}
+}
+
+// ConnectionParametersUpdateCommand asks the peer of connection `handle` for new parameters, the
+// intervals are in units of 1.25ms and the supervision timeout in units of 10ms
+func ConnectionParametersUpdateCommand(handle int, minInterval int, maxInterval int, latency int, timeout int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d,%d,%d,%d,%d", connectionParametersUpdate, handle, minInterval, maxInterval, latency, timeout),
+		Resp: empty,
+	}
+}
+
+// ConnectionStatusCommand reads every status property of the connection `handle`
+func ConnectionStatusCommand(handle int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d", connectionStatus, handle),
+		Resp: connectionStatusResponseString,
+	}
+}
+
+// BondCommand starts bonding with the device at `address`
+func BondCommand(address string) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%s", bond, address),
+		Resp: bondResponseString,
+	}
+}
+
+// UnbondCommand removes the bond with the device at `address`
+func UnbondCommand(address string) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%s", unbond, address),
+		Resp: empty,
+	}
+}
+
+// SecurityModeQueryCommand reads the security mode and type
+func SecurityModeQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", securityMode),
+		Resp: securityModeResponseString,
+	}
+}
+
+// SecurityModeCommand sets the security mode, which also sets the IO capabilities, and type
+func SecurityModeCommand(mode int, securityType int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d,%d", securityMode, mode, securityType),
+		Resp: empty,
+	}
+}
+
+// PairingModeQueryCommand reads whether pairing is allowed
+func PairingModeQueryCommand() CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s?", pairingMode),
+		Resp: pairingModeResponseString,
+	}
+}
+
+// PairingModeCommand sets whether pairing is allowed, 1 disables and 2 enables
+func PairingModeCommand(mode int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d", pairingMode, mode),
+		Resp: empty,
+	}
+}
+
+// UserPasskeyEntryCommand answers a +UUBTUPE request, `accept` false rejects the pairing
+func UserPasskeyEntryCommand(address string, accept bool, passkey int) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%s,%d,%06d", userPasskeyEntry, address, boolToInt(accept), passkey),
+		Resp: empty,
+	}
+}
+
+// UserConfirmationCommand answers a +UUBTUC numeric comparison request
+func UserConfirmationCommand(address string, accept bool) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%s,%d", userConfirmation, address, boolToInt(accept)),
+		Resp: empty,
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // BLEStoreConfig follows the BLEConfig commands, these only take effect after
 // the RebootCommand() is issued.
 func BLEStoreConfig() CmdResp {
@@ -125,6 +319,38 @@ func BLEStoreConfig() CmdResp {
 	}
 }
 
+// AdvertisingDataCommand sets the custom advertising data
+func AdvertisingDataCommand(data []byte) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%X", advertisingData, data),
+		Resp: empty,
+	}
+}
+
+// ScanResponseDataCommand sets the custom scan response data
+func ScanResponseDataCommand(data []byte) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%X", scanResponseData, data),
+		Resp: empty,
+	}
+}
+
+// DiscoverabilityModeCommand sets the GAP discoverability mode
+func DiscoverabilityModeCommand(mode DiscoverabilityMode) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d", discoverabilityMode, mode),
+		Resp: empty,
+	}
+}
+
+// ConnectabilityModeCommand sets the GAP connectability mode
+func ConnectabilityModeCommand(mode ConnectabilityMode) CmdResp {
+	return CmdResp{
+		Cmd:  fmt.Sprintf("AT%s=%d", connectabilityMode, mode),
+		Resp: empty,
+	}
+}
+
 // Constructs the command to connect to a device
 func ConnectCommand(address string) CmdResp {
 	return CmdResp{
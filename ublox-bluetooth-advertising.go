@@ -0,0 +1,216 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"time"
+)
+
+// DiscoverabilityMode is the GAP discoverability mode set by +UBTDM
+type DiscoverabilityMode int
+
+const (
+	// NonDiscoverable the module does not advertise as discoverable
+	NonDiscoverable DiscoverabilityMode = 1
+	// LimitedDiscoverable the module is discoverable for a limited period
+	LimitedDiscoverable DiscoverabilityMode = 2
+	// GeneralDiscoverable the module is always discoverable (module default)
+	GeneralDiscoverable DiscoverabilityMode = 3
+)
+
+// ConnectabilityMode is the GAP connectability mode set by +UBTCM
+type ConnectabilityMode int
+
+const (
+	// NonConnectable advertisements do not accept connections, e.g. a beacon
+	NonConnectable ConnectabilityMode = 1
+	// Connectable advertisements accept connections (module default)
+	Connectable ConnectabilityMode = 2
+)
+
+// The module adds the flags AD structure itself, leaving 28 of the 31 bytes for custom data
+const maxAdvertisingDataLength = 28
+const maxScanResponseDataLength = 31
+
+// advertising intervals are in units of 0.625ms
+const advertisingIntervalUnit = 625 * time.Microsecond
+const minAdvertisingIntervalUnits = 32
+const maxAdvertisingIntervalUnits = 16384
+
+// AdvertisingOptions describes how the module should advertise. Zero values are left unchanged.
+type AdvertisingOptions struct {
+	Data            []byte
+	ScanResponse    []byte
+	Discoverability DiscoverabilityMode
+	Connectability  ConnectabilityMode
+	MinInterval     time.Duration
+	MaxInterval     time.Duration
+	ChannelMap      int
+}
+
+// SetAdvertisingData sets the custom advertising data, use EncodeAdvertisingData or one of
+// the beacon builders to create it.
+func (ub *UbloxBluetooth) SetAdvertisingData(data []byte) error {
+	if len(data) > maxAdvertisingDataLength {
+		return fmt.Errorf("[SetAdvertisingData] data too long (%d bytes, maximum %d)", len(data), maxAdvertisingDataLength)
+	}
+	_, err := ub.writeAndWait(AdvertisingDataCommand(data), false)
+	return err
+}
+
+// SetScanResponseData sets the data returned to active scanners
+func (ub *UbloxBluetooth) SetScanResponseData(data []byte) error {
+	if len(data) > maxScanResponseDataLength {
+		return fmt.Errorf("[SetScanResponseData] data too long (%d bytes, maximum %d)", len(data), maxScanResponseDataLength)
+	}
+	_, err := ub.writeAndWait(ScanResponseDataCommand(data), false)
+	return err
+}
+
+// SetDiscoverabilityMode sets the module's GAP discoverability
+func (ub *UbloxBluetooth) SetDiscoverabilityMode(mode DiscoverabilityMode) error {
+	_, err := ub.writeAndWait(DiscoverabilityModeCommand(mode), false)
+	return err
+}
+
+// SetConnectabilityMode sets the module's GAP connectability
+func (ub *UbloxBluetooth) SetConnectabilityMode(mode ConnectabilityMode) error {
+	_, err := ub.writeAndWait(ConnectabilityModeCommand(mode), false)
+	return err
+}
+
+func advertisingIntervalUnits(d time.Duration) (int, error) {
+	units := int(d / advertisingIntervalUnit)
+	if units < minAdvertisingIntervalUnits || units > maxAdvertisingIntervalUnits {
+		return 0, fmt.Errorf("advertising interval %v out of range (%v to %v)", d,
+			minAdvertisingIntervalUnits*advertisingIntervalUnit, maxAdvertisingIntervalUnits*advertisingIntervalUnit)
+	}
+	return units, nil
+}
+
+// SetAdvertisingInterval sets the minimum and maximum advertising interval (20ms to 10.24s)
+func (ub *UbloxBluetooth) SetAdvertisingInterval(minInterval time.Duration, maxInterval time.Duration) error {
+	if minInterval > maxInterval {
+		return fmt.Errorf("[SetAdvertisingInterval] minimum %v is greater than maximum %v", minInterval, maxInterval)
+	}
+	minUnits, err := advertisingIntervalUnits(minInterval)
+	if err != nil {
+		return err
+	}
+	maxUnits, err := advertisingIntervalUnits(maxInterval)
+	if err != nil {
+		return err
+	}
+
+	_, err = ub.writeAndWait(BLEConfig(minAdvertisingInterval, minUnits), false)
+	if err != nil {
+		return err
+	}
+	_, err = ub.writeAndWait(BLEConfig(maxAdvertisingInterval, maxUnits), false)
+	return err
+}
+
+// ConfigureAdvertising adds the peripheral role to the module's configured role and applies the
+// advertising options before storing them with AT&W. When only one of MinInterval and
+// MaxInterval is set the other is taken from the module, widened if need be to keep min <= max.
+// As with ConfigureUblox a role change only takes effect after RebootUblox.
+func (ub *UbloxBluetooth) ConfigureAdvertising(opts AdvertisingOptions) error {
+	d, err := ub.writeAndWait(BLERoleQueryCommand(), true)
+	if err != nil {
+		return fmt.Errorf("[ConfigureAdvertising] BLE role error %v", err)
+	}
+	role, err := ProcessIntReply(d, bleRoleResponseString)
+	if err != nil {
+		return err
+	}
+	switch role {
+	case bleDisabled:
+		_, err = ub.writeAndWait(BLERole(blePeripheral), false)
+	case bleCentral:
+		_, err = ub.writeAndWait(BLERole(bleSimultaneous), false)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.MinInterval != 0 || opts.MaxInterval != 0 {
+		minInterval, maxInterval, err := ub.advertisingIntervals(opts.MinInterval, opts.MaxInterval)
+		if err != nil {
+			return err
+		}
+		err = ub.SetAdvertisingInterval(minInterval, maxInterval)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ChannelMap != 0 {
+		_, err = ub.writeAndWait(BLEConfig(advertisingChannelMap, opts.ChannelMap), false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Data != nil {
+		err = ub.SetAdvertisingData(opts.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ScanResponse != nil {
+		err = ub.SetScanResponseData(opts.ScanResponse)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Discoverability != 0 {
+		err = ub.SetDiscoverabilityMode(opts.Discoverability)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Connectability != 0 {
+		err = ub.SetConnectabilityMode(opts.Connectability)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = ub.writeAndWait(BLEStoreConfig(), false)
+	return err
+}
+
+// advertisingIntervals fills in whichever of `minInterval` and `maxInterval` is zero from the
+// module's current settings
+func (ub *UbloxBluetooth) advertisingIntervals(minInterval time.Duration, maxInterval time.Duration) (time.Duration, time.Duration, error) {
+	if minInterval != 0 && maxInterval != 0 {
+		return minInterval, maxInterval, nil
+	}
+
+	d, err := ub.writeAndWait(BLEConfigQueryCommand(), true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("[ConfigureAdvertising] BLE config error %v", err)
+	}
+	values, err := ProcessParameterListReply(d, bleConfigurationResponseString)
+	if err != nil {
+		return 0, 0, err
+	}
+	var params BLEConfigParams
+	params.Set(values)
+
+	if minInterval == 0 {
+		minInterval = time.Duration(params.MinAdvertisingInterval) * advertisingIntervalUnit
+		if minInterval > maxInterval {
+			minInterval = maxInterval
+		}
+	}
+	if maxInterval == 0 {
+		maxInterval = time.Duration(params.MaxAdvertisingInterval) * advertisingIntervalUnit
+		if maxInterval < minInterval {
+			maxInterval = minInterval
+		}
+	}
+	return minInterval, maxInterval, nil
+}
@@ -0,0 +1,134 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+	"github.com/pkg/errors"
+)
+
+// DefaultProbeRates are the rates tried by DetectBaudRate, most likely first
+var DefaultProbeRates = []int{1000000, 115200, 921600, 460800, 230400, 57600, 38400, 19200}
+
+const baudProbeTimeout = 500 * time.Millisecond
+const baudProbeAttempts = 2
+const changeAfterConfirm = 1
+
+// probeBaudRate sets the host to `rate` and checks that the module answers an AT command
+func (ub *UbloxBluetooth) probeBaudRate(rate int) error {
+	br, err := serial.BaudRateFromInt(rate)
+	if err != nil {
+		return err
+	}
+
+	err = ub.SetCommsRate(br)
+	if err != nil {
+		return err
+	}
+	err = ub.port().Flush()
+	if err != nil {
+		return err
+	}
+	modeSwitchDelay()
+
+	// the first attempt may fail on bytes left over from the previous rate
+	for i := 0; i < baudProbeAttempts; i++ {
+		err = ub.probeATCommand(baudProbeTimeout)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// probeATCommand issues an AT command, waiting `timeout` rather than the module's timeout for its reply
+func (ub *UbloxBluetooth) probeATCommand(timeout time.Duration) error {
+	r := ATCommand()
+	err := ub.Write(r.Cmd)
+	if err != nil {
+		return err
+	}
+	_, err = ub.waitForResponse(r.Resp, false, timeout)
+	return err
+}
+
+// DetectBaudRate finds the rate that the module is using by trying each of `rates` in turn
+// (DefaultProbeRates when nil). The host is left at the detected rate.
+func (ub *UbloxBluetooth) DetectBaudRate(rates []int) (int, error) {
+	if rates == nil {
+		rates = DefaultProbeRates
+	}
+	for _, rate := range rates {
+		if ub.probeBaudRate(rate) == nil {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("[DetectBaudRate] module did not respond at any of %v", rates)
+}
+
+// NegotiateBaudRate moves both the module and the host to `target`. The module is told to change
+// after confirming the +UMRS command, the host follows and the link is verified before the setting
+// is stored with AT&W. If the module cannot be reached at `target` both sides are returned to the
+// original rate and an error is returned.
+func (ub *UbloxBluetooth) NegotiateBaudRate(target int) error {
+	_, err := serial.BaudRateFromInt(target)
+	if err != nil {
+		return errors.Wrap(err, "[NegotiateBaudRate] error")
+	}
+
+	original := ub.port().BaudRate().Int()
+	if ub.ATCommand() != nil {
+		original, err = ub.DetectBaudRate(nil)
+		if err != nil {
+			return errors.Wrap(err, "[NegotiateBaudRate] error")
+		}
+	}
+
+	settings, err := ub.GetRS232Settings()
+	if err != nil {
+		return errors.Wrap(err, "[NegotiateBaudRate] GetRS232Settings error")
+	}
+	if settings.BaudRate == target && original == target {
+		return nil
+	}
+
+	settings.BaudRate = target
+	settings.ChangeAfterConfirm = changeAfterConfirm
+	_, err = ub.writeAndWait(RS232SettingsCommand(rs232Arguments(*settings)), false)
+	if err != nil {
+		// the module has refused the rate, the host has not changed
+		return errors.Wrapf(err, "[NegotiateBaudRate] module refused %d", target)
+	}
+
+	err = ub.probeBaudRate(target)
+	if err != nil {
+		return ub.rollbackBaudRate(original, settings, err)
+	}
+
+	_, err = ub.writeAndWait(BLEStoreConfig(), false)
+	return err
+}
+
+func (ub *UbloxBluetooth) rollbackBaudRate(original int, settings *RS232SettingsReply, cause error) error {
+	if ub.probeBaudRate(original) == nil {
+		return errors.Wrapf(cause, "[NegotiateBaudRate] verification failed, module still at %d", original)
+	}
+
+	current, err := ub.DetectBaudRate(nil)
+	if err != nil {
+		return errors.Wrapf(cause, "[NegotiateBaudRate] verification failed and module lost (%v)", err)
+	}
+
+	settings.BaudRate = original
+	_, err = ub.writeAndWait(RS232SettingsCommand(rs232Arguments(*settings)), false)
+	if err != nil {
+		return errors.Wrapf(cause, "[NegotiateBaudRate] verification failed and module left at %d (%v)", current, err)
+	}
+
+	err = ub.probeBaudRate(original)
+	if err != nil {
+		return errors.Wrapf(cause, "[NegotiateBaudRate] verification failed and module lost after rollback (%v)", err)
+	}
+	return errors.Wrapf(cause, "[NegotiateBaudRate] verification failed, rolled back to %d", original)
+}
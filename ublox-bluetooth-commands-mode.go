@@ -19,8 +19,7 @@ func (ub *UbloxBluetooth) EnterDataMode() error {
 	if err != nil {
 		return errors.Wrap(err, "[EnterDataMode] error")
 	}
-	ub.currentMode = dataMode
-	ub.serialPort.SetEDMFlag(true)
+	ub.setMode(dataMode, true)
 	modeSwitchDelay()
 	return nil
 }
@@ -32,25 +31,23 @@ func (ub *UbloxBluetooth) EnterExtendedDataMode() error {
 	if err != nil {
 		return errors.Wrap(err, "[EnterExtendedDataMode] error")
 	}
-	ub.currentMode = extendedDataMode
-	ub.serialPort.SetEDMFlag(true)
+	ub.setMode(extendedDataMode, true)
 	modeSwitchDelay()
 	return nil
 }
 
 // EnterCommandMode sends the Escape Sequence required to return the Command Mode (AT)
 func (ub *UbloxBluetooth) EnterCommandMode() error {
-	err := ub.serialPort.ToggleDTR()
+	err := ub.port().ToggleDTR()
 	if err != nil {
 		return errors.Wrap(err, "[EnterCommandMode] error")
 	}
-	ub.currentMode = commandMode
-	ub.serialPort.SetEDMFlag(false)
+	ub.setMode(commandMode, false)
 	modeSwitchDelay()
 	return nil
 }
 
 // ResetUblox calls the Serial port's ResetViaDTR
 func (ub *UbloxBluetooth) ResetUblox() error {
-	return ub.serialPort.ResetViaDTR()
+	return ub.port().ResetViaDTR()
 }
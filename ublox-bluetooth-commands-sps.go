@@ -74,7 +74,7 @@ func (ub *UbloxBluetooth) DisconnectDeviceSPS(peerHandle int) error {
 
 // WriteSPS writes the bytes to the serial port service
 func (ub *UbloxBluetooth) WriteSPS(d []byte) error {
-	if ub.currentMode != dataMode {
+	if ub.mode() != dataMode {
 		return fmt.Errorf("WriteSPS error. Not in Data Mode")
 	}
 	return ub.WriteBytes(d)
@@ -8,11 +8,15 @@ import (
 )
 
 func (ub *UbloxBluetooth) writeAndWait(r CmdResp, waitForData bool) ([]byte, error) {
-	err := ub.Write(r.Cmd)
+	id, err := ub.write(r.Cmd)
 	if err != nil {
 		return nil, err
 	}
-	return ub.WaitForResponse(r.Resp, waitForData)
+	d, err := ub.WaitForResponse(r.Resp, waitForData)
+	if err != nil {
+		ub.dropReply(id)
+	}
+	return d, err
 }
 
 // ATCommand issues a straight AT command - used to test connection
@@ -75,10 +79,17 @@ func (ub *UbloxBluetooth) PeerList() error {
 	return nil
 }
 
-// DiscoveryCommand issues the Discover command and calls the DiscoveryReplyHandler
+// DiscoveryCommand issues the Discover command and calls the DiscoveryReplyHandler. It returns
+// ErrRadioBusy while a device is connected.
 func (ub *UbloxBluetooth) DiscoveryCommand(fn DiscoveryReplyHandler) error {
+	release, err := ub.acquireDiscoveryRadio()
+	if err != nil {
+		return errors.Wrap(err, "[DiscoveryCommand] error")
+	}
+	defer release()
+
 	dc := DiscoveryCommand()
-	err := ub.Write(dc.Cmd)
+	err = ub.Write(dc.Cmd)
 	if err != nil {
 		return err
 	}
@@ -95,81 +106,99 @@ func (ub *UbloxBluetooth) DiscoveryCommand(fn DiscoveryReplyHandler) error {
 }
 
 // ConnectToDevice attempts to connect to the device with the specified address.
+// The radio is held from the connect until the device disconnects, any Scan started
+// until then, including from `onConnect`, returns ErrRadioBusy. Another connection waits
+// for the disconnect, returning ErrRadioBusy if it does not come.
 func (ub *UbloxBluetooth) ConnectToDevice(address string, onConnect DeviceEvent, onDisconnect DeviceEvent) error {
+	release, err := ub.acquireConnectionRadio()
+	if err != nil {
+		return errors.Wrap(err, "[ConnectToDevice] error")
+	}
 	d, err := ub.writeAndWait(ConnectCommand(address), true)
 	if err != nil {
+		release()
 		return err
 	}
 
 	cr, err := NewConnectionReply(string(d))
 	if err != nil {
+		release()
 		return err
 	}
 
-	ub.connectedDevice = cr
-	ub.disconnectHandler = onDisconnect
-	ub.disconnectExpected = false
+	ub.setConnection(cr, onDisconnect, release)
 	return onConnect()
 }
 
+// handleUnexpectedDisconnection is called by the reader when the device drops the connection.
+// The disconnect handler runs on its own goroutine, as the reader must keep going for any
+// commands that it issues.
 func (ub *UbloxBluetooth) handleUnexpectedDisconnection() {
-	ub.connectedDevice = nil
-	ub.disconnectHandler = nil
-	ub.disconnectExpected = false
-	if ub.disconnectHandler != nil {
-		ub.ErrorChannel <- ub.disconnectHandler()
+	handler := ub.clearConnection()
+	if handler != nil {
+		go handler()
 	}
 }
 
-// DisconnectFromDevice issues the disconnect command using the handle from the ConnectionReply
+// Connected returns true from ConnectToDevice until the device disconnects
+func (ub *UbloxBluetooth) Connected() bool {
+	return ub.connection() != nil
+}
+
+// DisconnectFromDevice issues the disconnect command using the handle from the ConnectionReply.
+// The connection is forgotten, and the radio released, even if the disconnect fails.
 func (ub *UbloxBluetooth) DisconnectFromDevice() error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	ub.disconnectExpected = true
+	ub.expectDisconnect(true)
 
-	d, err := ub.writeAndWait(DisconnectCommand(ub.connectedDevice.Handle), true)
+	d, err := ub.writeAndWait(DisconnectCommand(cr.Handle), true)
 	if err != nil {
+		ub.clearConnection()
 		return err
 	}
 
 	ok, err := ProcessDisconnectReply(d)
 	if !ok {
+		ub.clearConnection()
 		return fmt.Errorf("Incorrect disconnect reply %q", d)
 	}
-	ub.connectedDevice = nil
-	ub.disconnectHandler = nil
-	ub.disconnectExpected = false
+	ub.clearConnection()
 	return err
 }
 
 // EnableIndications instructs the connected device to initialise indiciations
 func (ub *UbloxBluetooth) EnableIndications() error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	_, err := ub.writeAndWait(WriteCharacteristicConfigurationCommand(ub.connectedDevice.Handle, commandCCCDHandle, 2), false)
+	_, err := ub.writeAndWait(WriteCharacteristicConfigurationCommand(cr.Handle, commandCCCDHandle, 2), false)
 	return err
 }
 
 // EnableNotifications instructs the connected device to initialise notifications
 func (ub *UbloxBluetooth) EnableNotifications() error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	_, err := ub.writeAndWait(WriteCharacteristicConfigurationCommand(ub.connectedDevice.Handle, dataCCCDHandle, 1), false)
+	_, err := ub.writeAndWait(WriteCharacteristicConfigurationCommand(cr.Handle, dataCCCDHandle, 1), false)
 	return err
 }
 
 // ReadCharacterisitic reads the connected device's BT Characteristics
 func (ub *UbloxBluetooth) ReadCharacterisitic() ([]byte, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return nil, fmt.Errorf("ConnectionReply is nil")
 	}
-	d, err := ub.writeAndWait(ReadCharacterisiticCommand(ub.connectedDevice.Handle, commandValueHandle), true)
+	d, err := ub.writeAndWait(ReadCharacterisiticCommand(cr.Handle, commandValueHandle), true)
 	if err != nil {
 		return nil, errors.Wrapf(err, "ReadCharacterisitic error")
 	}
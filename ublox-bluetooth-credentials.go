@@ -0,0 +1,442 @@
+package ubloxbluetooth
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrNoPassword is returned when a CredentialProvider has no password for a sensor
+var ErrNoPassword = fmt.Errorf("no password for sensor")
+
+// CredentialProvider returns the password that unlocks a sensor
+type CredentialProvider interface {
+	Password(address string) ([]byte, error)
+}
+
+// StaticCredentials holds passwords by sensor address, with Default for any other sensor
+type StaticCredentials struct {
+	Passwords map[string][]byte
+	Default   []byte
+}
+
+// Password returns the sensor's password, or the Default
+func (sc StaticCredentials) Password(address string) ([]byte, error) {
+	for a, p := range sc.Passwords {
+		if addressKey(a) == addressKey(address) {
+			return p, nil
+		}
+	}
+	if sc.Default != nil {
+		return sc.Default, nil
+	}
+	return nil, errors.Wrapf(ErrNoPassword, "[Password] %s", address)
+}
+
+// DefaultPasswordVariable is the environment variable EnvCredentials reads by default
+const DefaultPasswordVariable = "UBLOX_SENSOR_PASSWORD"
+
+// EnvCredentials reads passwords from the environment: Prefix_<ADDRESS> for one sensor, such as
+// UBLOX_SENSOR_PASSWORD_CE1A0B7E9D79, then Prefix for every sensor. Prefix defaults to
+// DefaultPasswordVariable.
+type EnvCredentials struct {
+	Prefix string
+}
+
+// Password returns the sensor's password from the environment
+func (ec EnvCredentials) Password(address string) ([]byte, error) {
+	prefix := ec.Prefix
+	if prefix == "" {
+		prefix = DefaultPasswordVariable
+	}
+	p, ok := os.LookupEnv(prefix + "_" + addressKey(address))
+	if !ok {
+		p, ok = os.LookupEnv(prefix)
+	}
+	if !ok {
+		return nil, errors.Wrapf(ErrNoPassword, "[Password] %s is not set", prefix)
+	}
+	return []byte(p), nil
+}
+
+// CredentialStore is a CredentialProvider that can change passwords safely: a new password is
+// first recorded as pending, then committed once the sensor has been seen to accept it.
+type CredentialStore interface {
+	CredentialProvider
+	// Pending returns the password being rotated to, if there is one
+	Pending(address string) ([]byte, bool, error)
+	// SetPending records the password being rotated to
+	SetPending(address string, password []byte) error
+	// Commit makes the pending password the sensor's password, discarding the old one
+	Commit(address string) error
+	// Discard drops the pending password, keeping the old one
+	Discard(address string) error
+}
+
+// DefaultKeystoreIterations is the PBKDF2 iteration count for a new Keystore
+const DefaultKeystoreIterations = 100000
+
+// Keystore is a CredentialStore kept in a file encrypted with AES-256-GCM, under a key derived
+// from a passphrase with PBKDF2-HMAC-SHA256
+type Keystore struct {
+	path       string
+	key        []byte
+	mu         sync.Mutex
+	salt       []byte
+	iterations int
+	entries    map[string]*keystoreEntry
+}
+
+type keystoreEntry struct {
+	Password string `json:"password,omitempty"`
+	Pending  string `json:"pending,omitempty"`
+}
+
+type keystoreFile struct {
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// OpenKeystore opens the keystore at `path`, creating it if there is no file
+func OpenKeystore(path string, passphrase string) (*Keystore, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("[OpenKeystore] passphrase is empty")
+	}
+	ks := &Keystore{path: path, entries: map[string]*keystoreEntry{}}
+
+	f := keystoreFile{}
+	err := readJSONFile(path, &f)
+	if err != nil {
+		return nil, errors.Wrap(err, "[OpenKeystore] error")
+	}
+	if f.Data == nil {
+		ks.salt = make([]byte, 16)
+		_, err = rand.Read(ks.salt)
+		if err != nil {
+			return nil, errors.Wrap(err, "[OpenKeystore] error")
+		}
+		ks.iterations = DefaultKeystoreIterations
+		ks.key = pbkdf2.Key([]byte(passphrase), ks.salt, ks.iterations, 32, sha256.New)
+		return ks, nil
+	}
+
+	ks.salt = f.Salt
+	ks.iterations = f.Iterations
+	ks.key = pbkdf2.Key([]byte(passphrase), ks.salt, ks.iterations, 32, sha256.New)
+	aead, err := ks.cipher()
+	if err != nil {
+		return nil, errors.Wrap(err, "[OpenKeystore] error")
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[OpenKeystore] %s cannot be decrypted, the passphrase is wrong or the file is damaged", path)
+	}
+	err = json.Unmarshal(plain, &ks.entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "[OpenKeystore] error")
+	}
+	return ks, nil
+}
+
+func (ks *Keystore) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(ks.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// save encrypts and writes the entries. Callers hold ks.mu.
+func (ks *Keystore) save() error {
+	plain, err := json.Marshal(ks.entries)
+	if err != nil {
+		return err
+	}
+	aead, err := ks.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	f := keystoreFile{Salt: ks.salt, Iterations: ks.iterations, Nonce: nonce, Data: aead.Seal(nil, nonce, plain, nil)}
+	return writeJSONFileMode(ks.path, &f, 0600)
+}
+
+// update changes an entry with fn and saves the keystore, leaving it unchanged if the save fails
+func (ks *Keystore) update(address string, fn func(e *keystoreEntry) error) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key := addressKey(address)
+	previous, existed := ks.entries[key]
+	e := &keystoreEntry{}
+	if existed {
+		*e = *previous
+	}
+	err := fn(e)
+	if err != nil {
+		return err
+	}
+	ks.entries[key] = e
+	err = ks.save()
+	if err != nil {
+		if existed {
+			ks.entries[key] = previous
+		} else {
+			delete(ks.entries, key)
+		}
+	}
+	return err
+}
+
+// Set records the sensor's password
+func (ks *Keystore) Set(address string, password []byte) error {
+	err := ks.update(address, func(e *keystoreEntry) error {
+		e.Password = hex.EncodeToString(password)
+		return nil
+	})
+	return errors.Wrap(err, "[Set] error")
+}
+
+// Password returns the sensor's password
+func (ks *Keystore) Password(address string) ([]byte, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	e, ok := ks.entries[addressKey(address)]
+	if !ok || e.Password == "" {
+		return nil, errors.Wrapf(ErrNoPassword, "[Password] %s", address)
+	}
+	return hex.DecodeString(e.Password)
+}
+
+// Pending returns the password being rotated to, if there is one
+func (ks *Keystore) Pending(address string) ([]byte, bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	e, ok := ks.entries[addressKey(address)]
+	if !ok || e.Pending == "" {
+		return nil, false, nil
+	}
+	p, err := hex.DecodeString(e.Pending)
+	return p, err == nil, err
+}
+
+// SetPending records the password being rotated to
+func (ks *Keystore) SetPending(address string, password []byte) error {
+	err := ks.update(address, func(e *keystoreEntry) error {
+		e.Pending = hex.EncodeToString(password)
+		return nil
+	})
+	return errors.Wrap(err, "[SetPending] error")
+}
+
+// Commit makes the pending password the sensor's password
+func (ks *Keystore) Commit(address string) error {
+	err := ks.update(address, func(e *keystoreEntry) error {
+		if e.Pending == "" {
+			return fmt.Errorf("%s has no pending password", address)
+		}
+		e.Password = e.Pending
+		e.Pending = ""
+		return nil
+	})
+	return errors.Wrap(err, "[Commit] error")
+}
+
+// Discard drops the pending password
+func (ks *Keystore) Discard(address string) error {
+	err := ks.update(address, func(e *keystoreEntry) error {
+		e.Pending = ""
+		return nil
+	})
+	return errors.Wrap(err, "[Discard] error")
+}
+
+// PasswordAlphabet is the characters used by GeneratePassword
+const PasswordAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+// GeneratePassword returns a random password of `length` characters from PasswordAlphabet
+func GeneratePassword(length int) ([]byte, error) {
+	// random bytes from the last partial run of the alphabet are rejected, so that every
+	// character is equally likely
+	limit := 256 - 256%len(PasswordAlphabet)
+	password := make([]byte, 0, length)
+	b := make([]byte, length)
+	for len(password) < length {
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, errors.Wrap(err, "[GeneratePassword] error")
+		}
+		for _, r := range b {
+			if int(r) < limit && len(password) < length {
+				password = append(password, PasswordAlphabet[int(r)%len(PasswordAlphabet)])
+			}
+		}
+	}
+	return password, nil
+}
+
+// RotationClient is the part of UbloxBluetooth that RotatePassword uses
+type RotationClient interface {
+	ConnectToDevice(address string, onConnect DeviceEvent, onDisconnect DeviceEvent) error
+	DisconnectFromDevice() error
+	EnableIndications() error
+	UnlockDevice(password []byte) (bool, error)
+	ChangePassword(password []byte) error
+}
+
+// RotationResult is the outcome of rotating one sensor's password
+type RotationResult struct {
+	Address string
+	Err     error
+}
+
+// RotatePassword changes the sensor's password to `newPassword`. The new password is recorded as
+// pending before the change and only committed, discarding the old one, once the sensor has
+// unlocked with it on a fresh connection. A rotation that cannot be settled, because the sensor
+// cannot be reached, is settled by the next call for the sensor. RotatePassword relies on the
+// experimental ChangePassword.
+func RotatePassword(client RotationClient, store CredentialStore, address string, newPassword []byte) error {
+	current, err := store.Password(address)
+	if err != nil {
+		return errors.Wrap(err, "[RotatePassword] error")
+	}
+
+	pending, ok, err := store.Pending(address)
+	if err != nil {
+		return errors.Wrap(err, "[RotatePassword] Pending error")
+	}
+	if ok {
+		current, err = settleRotation(client, store, address, current, pending)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(current, newPassword) {
+			return nil
+		}
+	}
+
+	err = store.SetPending(address, newPassword)
+	if err != nil {
+		return errors.Wrap(err, "[RotatePassword] SetPending error")
+	}
+	changeErr := withUnlockedSensor(client, address, current, func() error {
+		return client.ChangePassword(newPassword)
+	})
+
+	// the change may have been applied even if its reply was lost, so check either way
+	now, err := settleRotation(client, store, address, current, newPassword)
+	if err != nil {
+		if changeErr != nil {
+			return errors.Wrapf(err, "[RotatePassword] ChangePassword error %v", changeErr)
+		}
+		return err
+	}
+	if !bytes.Equal(now, newPassword) {
+		if changeErr != nil {
+			return errors.Wrap(changeErr, "[RotatePassword] ChangePassword error")
+		}
+		return fmt.Errorf("[RotatePassword] %s still unlocks with its old password", address)
+	}
+	return nil
+}
+
+// settleRotation finds which of the old and pending passwords the sensor has, commits or
+// discards the pending one to match, and returns the sensor's password
+func settleRotation(client RotationClient, store CredentialStore, address string, old []byte, pending []byte) ([]byte, error) {
+	err := withUnlockedSensor(client, address, pending, nil)
+	if err == nil {
+		err = store.Commit(address)
+		if err != nil {
+			return nil, errors.Wrap(err, "[RotatePassword] Commit error")
+		}
+		return pending, nil
+	}
+	pendingErr := err
+
+	err = withUnlockedSensor(client, address, old, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[RotatePassword] %s unlocks with neither password, both are kept: %v, %v", address, pendingErr, err)
+	}
+	err = store.Discard(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "[RotatePassword] Discard error")
+	}
+	return old, nil
+}
+
+// withUnlockedSensor connects to and unlocks the sensor, then calls fn if it is not nil
+func withUnlockedSensor(client RotationClient, address string, password []byte, fn func() error) error {
+	var sessionErr error
+	err := client.ConnectToDevice(address, func() error {
+		sessionErr = func() error {
+			err := client.EnableIndications()
+			if err != nil {
+				return errors.Wrap(err, "EnableIndications error")
+			}
+			unlocked, err := client.UnlockDevice(password)
+			if err != nil {
+				return errors.Wrap(err, "UnlockDevice error")
+			}
+			if !unlocked {
+				return fmt.Errorf("%s did not unlock", address)
+			}
+			if fn != nil {
+				return fn()
+			}
+			return nil
+		}()
+		return client.DisconnectFromDevice()
+	}, func() error {
+		return nil
+	})
+	if sessionErr != nil {
+		return sessionErr
+	}
+	return err
+}
+
+// RotatePasswords rotates each sensor's password in turn to one from `generate`. A failure
+// leaves that sensor on one of its passwords and moves on to the next.
+func RotatePasswords(ctx context.Context, client RotationClient, store CredentialStore, addresses []string, generate func(address string) ([]byte, error)) ([]RotationResult, error) {
+	results := []RotationResult{}
+	for _, address := range addresses {
+		select {
+		case <-ctx.Done():
+			return results, ctx.Err()
+		default:
+		}
+		p, err := generate(address)
+		if err == nil {
+			err = RotatePassword(client, store, address, p)
+		}
+		results = append(results, RotationResult{Address: address, Err: err})
+	}
+	return results, nil
+}
+
+// passwordFor returns the explicit password, or the one from `creds`
+func passwordFor(address string, password []byte, creds CredentialProvider) ([]byte, error) {
+	if password != nil {
+		return password, nil
+	}
+	if creds == nil {
+		return nil, errors.Wrapf(ErrNoPassword, "[passwordFor] %s has no password and there is no CredentialProvider", address)
+	}
+	return creds.Password(address)
+}
@@ -0,0 +1,265 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultMaxCredit is the largest credit window a download grows to. It is DefaultCredit, the
+// host FIFO's size, so the window only grows beyond that when MaxCredit is raised.
+const DefaultMaxCredit = DefaultCredit
+
+// DefaultInitialCredit is the credit sent with the download command. It is half the host FIFO,
+// leaving the window room to grow.
+const DefaultInitialCredit = DefaultCredit / 2
+
+// DefaultMinCredit is the smallest credit window a download shrinks to
+const DefaultMinCredit = 4
+
+// DefaultStallTimeout is the shortest pause in notifications after which credits are sent again
+const DefaultStallTimeout = 500 * time.Millisecond
+
+// creditLimit is the most credit that one credit command can carry
+const creditLimit = 255
+
+// maxCreditStalls is the number of stalls in a row after which a download gives up
+const maxCreditStalls = 3
+
+// ErrDownloadStalled is returned when a download makes no progress despite credits being re-sent
+var ErrDownloadStalled = fmt.Errorf("download stalled")
+
+// DownloadStats describe a completed or failed download
+type DownloadStats struct {
+	Notifications int
+	Expected      int
+	Bytes         int
+	Duration      time.Duration
+	// CreditsSent counts the credits granted after the initial credit
+	CreditsSent int
+	Stalls      int
+	// Window is the credit window at the end of the download, MaxWindow the largest it reached
+	Window    int
+	MaxWindow int
+	Complete  bool
+}
+
+// BytesPerSecond is the payload throughput achieved
+func (s DownloadStats) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// NotificationsPerSecond is the notification rate achieved
+func (s DownloadStats) NotificationsPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Notifications) / s.Duration.Seconds()
+}
+
+// DownloadReportHandler is given the stats of each download
+type DownloadReportHandler func(s DownloadStats)
+
+// FlowControlOptions configure the credits granted to a sensor during downloads
+type FlowControlOptions struct {
+	// InitialCredit is sent with the download command, defaults to DefaultInitialCredit
+	InitialCredit int
+	// MinCredit and MaxCredit bound the credit window, defaulting to DefaultMinCredit and DefaultMaxCredit
+	MinCredit int
+	MaxCredit int
+	// StallTimeout defaults to DefaultStallTimeout, it is lengthened when notifications arrive slowly
+	StallTimeout time.Duration
+	Report       DownloadReportHandler
+}
+
+func (o FlowControlOptions) withDefaults() FlowControlOptions {
+	if o.MaxCredit <= 0 {
+		o.MaxCredit = DefaultMaxCredit
+	}
+	if o.MaxCredit > creditLimit {
+		o.MaxCredit = creditLimit
+	}
+	if o.MinCredit <= 0 {
+		o.MinCredit = DefaultMinCredit
+	}
+	if o.MinCredit > o.MaxCredit {
+		o.MinCredit = o.MaxCredit
+	}
+	if o.InitialCredit <= 0 {
+		o.InitialCredit = DefaultInitialCredit
+	}
+	o.InitialCredit = clampCredit(o.InitialCredit, o.MinCredit, o.MaxCredit)
+	if o.StallTimeout <= 0 {
+		o.StallTimeout = DefaultStallTimeout
+	}
+	return o
+}
+
+func clampCredit(c int, min int, max int) int {
+	if c < min {
+		return min
+	}
+	if c > max {
+		return max
+	}
+	return c
+}
+
+// FlowController decides the credits to grant during a download. The window grows by a quarter
+// each time a full window arrives without a stall, and halves on a stall or a failed credit
+// write. Credit is never granted beyond the notifications still expected, nor beyond the window
+// except for the single credit sent after a stall.
+type FlowController struct {
+	opts        FlowControlOptions
+	remaining   int
+	outstanding int
+	window      int
+	sinceChange int
+	started     time.Time
+	last        time.Time
+	gap         time.Duration
+	stalls      int
+	probed      bool
+	probedAt    int
+	stats       DownloadStats
+}
+
+// NewFlowController starts the flow control of a download of `expected` notifications, for which
+// opts.InitialCredit has already been sent
+func NewFlowController(opts FlowControlOptions, expected int, now time.Time) *FlowController {
+	opts = opts.withDefaults()
+	return &FlowController{
+		opts:        opts,
+		remaining:   expected,
+		outstanding: opts.InitialCredit,
+		window:      opts.InitialCredit,
+		started:     now,
+		last:        now,
+		stats:       DownloadStats{Expected: expected, Window: opts.InitialCredit, MaxWindow: opts.InitialCredit},
+	}
+}
+
+// Received records a notification of `n` bytes, returning the credit to grant now
+func (f *FlowController) Received(n int, now time.Time) int {
+	d := now.Sub(f.last)
+	if f.stats.Notifications == 0 {
+		f.gap = d
+	} else {
+		f.gap = (f.gap*7 + d) / 8
+	}
+	f.last = now
+	f.stats.Notifications++
+	f.stats.Bytes += n
+	f.stalls = 0
+	if f.remaining > 0 {
+		f.remaining--
+	}
+	if f.outstanding > 0 {
+		f.outstanding--
+	}
+
+	f.sinceChange++
+	if f.sinceChange >= f.window {
+		growth := f.window / 4
+		if growth < 1 {
+			growth = 1
+		}
+		f.setWindow(f.window + growth)
+	}
+
+	if f.outstanding > f.window/2 {
+		return 0
+	}
+	return f.grant(f.window - f.outstanding)
+}
+
+// Stalled is called when no notification has arrived for StallTimeout, returning the credit to
+// send. The sensor may just be slow, so a single credit is sent rather than the window again.
+// Only if the sensor answers that and stalls again is the credit it held taken as lost.
+func (f *FlowController) Stalled(now time.Time) (int, error) {
+	f.stalls++
+	f.stats.Stalls++
+	if f.stalls > maxCreditStalls {
+		return 0, fmt.Errorf("[Stalled] no notifications after %d stalls, %d of %d received: %v", maxCreditStalls, f.stats.Notifications, f.stats.Expected, ErrDownloadStalled)
+	}
+	f.setWindow(f.window / 2)
+	f.last = now
+	if f.remaining == 0 {
+		return 0, nil
+	}
+	if f.probed && f.stats.Notifications > f.probedAt {
+		f.probed = false
+		f.outstanding = 0
+		return f.grant(f.window), nil
+	}
+	f.probed = true
+	f.probedAt = f.stats.Notifications
+	f.outstanding++
+	f.stats.CreditsSent++
+	return 1, nil
+}
+
+// CreditFailed takes back `credit` that the sensor did not accept and shrinks the window
+func (f *FlowController) CreditFailed(credit int) {
+	f.outstanding -= credit
+	if f.outstanding < 0 {
+		f.outstanding = 0
+	}
+	f.stats.CreditsSent -= credit
+	f.setWindow(f.window / 2)
+}
+
+// StallTimeout is the pause after which the download is taken to have stalled, at least
+// opts.StallTimeout and longer when notifications arrive slowly
+func (f *FlowController) StallTimeout() time.Duration {
+	t := 8 * f.gap
+	if t < f.opts.StallTimeout {
+		t = f.opts.StallTimeout
+	}
+	return t
+}
+
+// Stats returns the stats of the download so far
+func (f *FlowController) Stats(now time.Time) DownloadStats {
+	s := f.stats
+	s.Duration = now.Sub(f.started)
+	s.Window = f.window
+	s.Complete = f.remaining == 0
+	return s
+}
+
+// grant limits the credit to the notifications not already covered, and to one credit command
+func (f *FlowController) grant(credit int) int {
+	if uncovered := f.remaining - f.outstanding; credit > uncovered {
+		credit = uncovered
+	}
+	if credit > creditLimit {
+		credit = creditLimit
+	}
+	if credit <= 0 {
+		return 0
+	}
+	f.outstanding += credit
+	f.stats.CreditsSent += credit
+	return credit
+}
+
+func (f *FlowController) setWindow(w int) {
+	f.window = clampCredit(w, f.opts.MinCredit, f.opts.MaxCredit)
+	f.sinceChange = 0
+	if f.window > f.stats.MaxWindow {
+		f.stats.MaxWindow = f.window
+	}
+}
+
+// SetFlowControl configures the credits granted during downloads
+func (ub *UbloxBluetooth) SetFlowControl(opts FlowControlOptions) {
+	ub.flowControl = opts.withDefaults()
+}
+
+// initialCreditString is the hex credit sent with a download command
+func (ub *UbloxBluetooth) initialCreditString() string {
+	return uint8ToString(uint8(ub.flowControl.withDefaults().InitialCredit))
+}
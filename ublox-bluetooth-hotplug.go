@@ -0,0 +1,221 @@
+package ubloxbluetooth
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+	"github.com/pkg/errors"
+)
+
+// ErrDeviceGone is the cause of errors returned while the dongle is unplugged
+var ErrDeviceGone = serial.ErrDeviceGone
+
+// reattachRetryDelay is the wait before trying again when a reappeared device cannot be opened,
+// udev may not have finished setting it up.
+const reattachRetryDelay = time.Second
+
+// HotPlugEventType identifies a HotPlugEvent
+type HotPlugEventType int
+
+const (
+	// ModuleDetached is sent when the dongle is unplugged
+	ModuleDetached HotPlugEventType = iota
+	// ModuleReattached is sent when the dongle has been reopened and initialised
+	ModuleReattached
+)
+
+// HotPlugEvent reports a change to the dongle. For ModuleDetached Err holds the error that
+// detected the removal, for ModuleReattached it holds any error from the Initialise function.
+type HotPlugEvent struct {
+	Type   HotPlugEventType
+	Device *serial.DeviceInfo
+	Err    error
+}
+
+// HotPlugHandler is called with each HotPlugEvent
+type HotPlugHandler func(HotPlugEvent)
+
+// HotPlugOptions configure EnableHotPlug
+type HotPlugOptions struct {
+	// Watcher waits for the dongle to return, a serial.PollingWatcher when nil
+	Watcher serial.DeviceWatcher
+	// Filter selects the dongle to wait for, the one currently open when empty
+	Filter serial.DeviceFilter
+	// Initialise is run after the dongle is reopened, before ModuleReattached is sent
+	Initialise func(*UbloxBluetooth) error
+	// Handler receives the hot-plug events
+	Handler HotPlugHandler
+}
+
+type hotPlug struct {
+	options HotPlugOptions
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// EnableHotPlug makes the UbloxBluetooth survive the dongle being unplugged. Commands fail with
+// ErrDeviceGone until the same dongle reappears, when it is reopened and initialised again.
+func (ub *UbloxBluetooth) EnableHotPlug(options HotPlugOptions) {
+	if options.Watcher == nil {
+		options.Watcher = serial.PollingWatcher{}
+	}
+	if options.Filter == (serial.DeviceFilter{}) {
+		options.Filter = ub.currentDeviceFilter()
+	}
+
+	ub.hotPlugLock.Lock()
+	defer ub.hotPlugLock.Unlock()
+	if ub.hotPlug != nil {
+		ub.hotPlug.options = options
+		return
+	}
+	ub.hotPlug = &hotPlug{
+		options: options,
+		stop:    make(chan struct{}),
+	}
+}
+
+// getHotPlug returns the hot-plug state, nil if EnableHotPlug has not been called
+func (ub *UbloxBluetooth) getHotPlug() *hotPlug {
+	ub.hotPlugLock.Lock()
+	defer ub.hotPlugLock.Unlock()
+	return ub.hotPlug
+}
+
+// IsAttached returns false from the dongle being unplugged until it has been reopened
+func (ub *UbloxBluetooth) IsAttached() bool {
+	return atomic.LoadInt32(&ub.gone) == 0
+}
+
+// currentDeviceFilter identifies the open dongle by serial number if it has one, as it may
+// return with a different device node.
+func (ub *UbloxBluetooth) currentDeviceFilter() serial.DeviceFilter {
+	path := ub.port().Path()
+	d, err := serial.FindDevice(serial.DeviceFilter{Path: path})
+	if err != nil {
+		return serial.DeviceFilter{Path: path}
+	}
+	if d.SerialNumber != "" {
+		return serial.DeviceFilter{VendorID: d.VendorID, ProductID: d.ProductID, SerialNumber: d.SerialNumber}
+	}
+	if d.ByIDPath != "" {
+		return serial.DeviceFilter{Path: d.ByIDPath}
+	}
+	return serial.DeviceFilter{Path: path}
+}
+
+// detachment returns a channel that is closed while the dongle is unplugged, failing any
+// command waiting on the reader
+func (ub *UbloxBluetooth) detachment() <-chan struct{} {
+	ub.detachLock.Lock()
+	defer ub.detachLock.Unlock()
+	return ub.detached
+}
+
+// deviceGone is called by the reader, which then exits, when the serial port reports ErrDeviceGone
+func (ub *UbloxBluetooth) deviceGone(err error) {
+	ub.detachLock.Lock()
+	atomic.StoreInt32(&ub.gone, 1)
+	close(ub.detached)
+	ub.detachLock.Unlock()
+
+	hp := ub.getHotPlug()
+	if hp != nil {
+		hp.wg.Add(1)
+		go ub.reattach(hp, err)
+	}
+}
+
+func (hp *hotPlug) send(e HotPlugEvent) {
+	if hp.options.Handler != nil {
+		hp.options.Handler(e)
+	}
+}
+
+// reattach releases the lost serial port, waits for the dongle to return and reopens it
+func (ub *UbloxBluetooth) reattach(hp *hotPlug, cause error) {
+	defer hp.wg.Done()
+
+	ub.attachment.Lock()
+	if ub.closed {
+		ub.attachment.Unlock()
+		return
+	}
+	ub.stopReader()
+	ub.serialPort.Close()
+	handler := ub.clearConnection()
+	ub.attachment.Unlock()
+
+	if handler != nil {
+		// the connection went with the dongle
+		handler()
+	}
+	hp.send(HotPlugEvent{Type: ModuleDetached, Err: cause})
+
+	for {
+		d, err := hp.options.Watcher.WaitForDevice(hp.options.Filter, hp.stop)
+		if err != nil {
+			return
+		}
+
+		ub.attachment.Lock()
+		if ub.closed {
+			ub.attachment.Unlock()
+			return
+		}
+		err = ub.reopen(d.Path)
+		ub.attachment.Unlock()
+		if err == nil {
+			hp.send(HotPlugEvent{Type: ModuleReattached, Device: d, Err: ub.initialise(hp)})
+			return
+		}
+
+		select {
+		case <-hp.stop:
+			return
+		case <-time.After(reattachRetryDelay):
+		}
+	}
+}
+
+// reopen opens the serial port at `path` and restarts the reader as NewUbloxBluetooth does
+func (ub *UbloxBluetooth) reopen(path string) error {
+	sp, err := serial.OpenSerialPortPath(path, ub.timeout)
+	if err != nil {
+		return err
+	}
+
+	err = sp.Flush()
+	if err == nil && !ub.serialPort.FlowControl() {
+		// the module keeps a stored flow control change
+		err = sp.SetFlowControl(false, ub.timeout)
+	}
+	if err != nil {
+		sp.Close()
+		return err
+	}
+
+	if ub.devicePath != "" {
+		ub.devicePath = path
+	}
+	ub.serialPort = sp
+	ub.currentMode = extendedDataMode
+	ub.forgetModuleInfo()
+	sp.SetEDMFlag(true)
+	ub.startReader()
+
+	ub.detachLock.Lock()
+	ub.detached = make(chan struct{})
+	atomic.StoreInt32(&ub.gone, 0)
+	ub.detachLock.Unlock()
+	return nil
+}
+
+func (ub *UbloxBluetooth) initialise(hp *hotPlug) error {
+	if hp.options.Initialise == nil {
+		return nil
+	}
+	return errors.Wrap(hp.options.Initialise(ub), "[EnableHotPlug] Initialise error")
+}
@@ -0,0 +1,299 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PHY is a Bluetooth LE physical layer, as a bit in the +UBTLEPHYR masks
+type PHY int
+
+const (
+	// PHYAuto leaves the choice of PHY to the peer
+	PHYAuto PHY = 0
+	// PHY1M is the 1 Mbit/s PHY every device supports
+	PHY1M PHY = 1
+	// PHY2M is the 2 Mbit/s PHY, for faster downloads at short range
+	PHY2M PHY = 2
+	// PHYCoded is the long range coded PHY
+	PHYCoded PHY = 4
+)
+
+func (p PHY) String() string {
+	switch p {
+	case PHYAuto:
+		return "auto"
+	case PHY1M:
+		return "1M"
+	case PHY2M:
+		return "2M"
+	case PHYCoded:
+		return "coded"
+	}
+	return fmt.Sprintf("PHY(%d)", int(p))
+}
+
+// Connection parameter units and limits from the Bluetooth core specification
+const (
+	connectionIntervalUnit = 1250 * time.Microsecond
+	supervisionTimeoutUnit = 10 * time.Millisecond
+
+	MinConnectionInterval = 7500 * time.Microsecond
+	MaxConnectionInterval = 4 * time.Second
+	MaxPeripheralLatency  = 499
+	MinSupervisionTimeout = 100 * time.Millisecond
+	MaxSupervisionTimeout = 32 * time.Second
+)
+
+// ConnectionParameters are asked of the peer by UpdateConnectionParameters. The intervals are
+// rounded down to 1.25ms and the supervision timeout to 10ms.
+type ConnectionParameters struct {
+	MinInterval        time.Duration
+	MaxInterval        time.Duration
+	Latency            int
+	SupervisionTimeout time.Duration
+}
+
+// Validate checks the parameters are ones a peer could accept
+func (p ConnectionParameters) Validate() error {
+	if p.MinInterval < MinConnectionInterval || p.MaxInterval > MaxConnectionInterval || p.MinInterval > p.MaxInterval {
+		return fmt.Errorf("[Validate] connection interval %v to %v must be within %v to %v", p.MinInterval, p.MaxInterval, MinConnectionInterval, MaxConnectionInterval)
+	}
+	if p.Latency < 0 || p.Latency > MaxPeripheralLatency {
+		return fmt.Errorf("[Validate] latency %d must be from 0 to %d", p.Latency, MaxPeripheralLatency)
+	}
+	if p.SupervisionTimeout < MinSupervisionTimeout || p.SupervisionTimeout > MaxSupervisionTimeout {
+		return fmt.Errorf("[Validate] supervision timeout %v must be from %v to %v", p.SupervisionTimeout, MinSupervisionTimeout, MaxSupervisionTimeout)
+	}
+	// the link must survive the peer skipping `Latency` events at the longest interval
+	if p.SupervisionTimeout <= time.Duration(1+p.Latency)*p.MaxInterval*2 {
+		return fmt.Errorf("[Validate] supervision timeout %v must exceed %v", p.SupervisionTimeout, time.Duration(1+p.Latency)*p.MaxInterval*2)
+	}
+	return nil
+}
+
+// RequestPHY asks for the PHYs of the current connection and waits for the peer to agree them,
+// returning the PHYs in use
+func (ub *UbloxBluetooth) RequestPHY(tx PHY, rx PHY) (*PHYUpdate, error) {
+	cr := ub.connection()
+	if cr == nil {
+		return nil, fmt.Errorf("ConnectionReply is nil")
+	}
+	handle := cr.Handle
+
+	// drop any update left from earlier
+	select {
+	case <-ub.phyUpdates:
+	default:
+	}
+
+	_, err := ub.writeAndWait(PHYRequestCommand(handle, int(tx), int(rx)), false)
+	if err != nil {
+		return nil, errors.Wrap(err, "[RequestPHY] error")
+	}
+
+	deadline := time.After(ub.timeout)
+	for {
+		select {
+		case u := <-ub.phyUpdates:
+			if u.Handle != handle {
+				continue
+			}
+			if u.Status != 0 {
+				return u, fmt.Errorf("[RequestPHY] update failed with status %d", u.Status)
+			}
+			return u, nil
+		case <-deadline:
+			return nil, fmt.Errorf("[RequestPHY] Timeout")
+		}
+	}
+}
+
+// ReadPHY reads the transmit and receive PHYs of the current connection
+func (ub *UbloxBluetooth) ReadPHY() (PHY, PHY, error) {
+	cr := ub.connection()
+	if cr == nil {
+		return PHYAuto, PHYAuto, fmt.Errorf("ConnectionReply is nil")
+	}
+
+	d, err := ub.writeAndWait(PHYQueryCommand(cr.Handle), true)
+	if err != nil {
+		return PHYAuto, PHYAuto, errors.Wrap(err, "[ReadPHY] error")
+	}
+	_, tx, rx, err := ProcessPHYReply(d)
+	if err != nil {
+		return PHYAuto, PHYAuto, errors.Wrap(err, "[ReadPHY] error")
+	}
+	ub.updateLink(func(cr *ConnectionReply) {
+		cr.Link.TxPHY = PHY(tx)
+		cr.Link.RxPHY = PHY(rx)
+	})
+	return PHY(tx), PHY(rx), nil
+}
+
+// UpdateConnectionParameters asks the peer for new connection parameters. The peer applies them
+// after a few connection events, when ReadLinkParameters reports them.
+func (ub *UbloxBluetooth) UpdateConnectionParameters(p ConnectionParameters) error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+	err := p.Validate()
+	if err != nil {
+		return errors.Wrap(err, "[UpdateConnectionParameters] error")
+	}
+
+	c := ConnectionParametersUpdateCommand(cr.Handle,
+		int(p.MinInterval/connectionIntervalUnit), int(p.MaxInterval/connectionIntervalUnit),
+		p.Latency, int(p.SupervisionTimeout/supervisionTimeoutUnit))
+	_, err = ub.writeAndWait(c, false)
+	return errors.Wrap(err, "[UpdateConnectionParameters] error")
+}
+
+// ReadLinkParameters reads the connection interval, latency, supervision timeout, MTU and PHYs
+// of the current connection
+func (ub *UbloxBluetooth) ReadLinkParameters() (*LinkParameters, error) {
+	cr := ub.connection()
+	if cr == nil {
+		return nil, fmt.Errorf("ConnectionReply is nil")
+	}
+
+	d, err := ub.writeAndWait(ConnectionStatusCommand(cr.Handle), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadLinkParameters] error")
+	}
+	status, err := ProcessConnectionStatusReply(d)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadLinkParameters] error")
+	}
+
+	var link LinkParameters
+	ub.updateLink(func(cr *ConnectionReply) {
+		l := &cr.Link
+		if v, ok := status[statusConnectionInterval]; ok {
+			l.Interval = time.Duration(v) * connectionIntervalUnit
+		}
+		if v, ok := status[statusPeripheralLatency]; ok {
+			l.Latency = v
+		}
+		if v, ok := status[statusSupervisionTimeout]; ok {
+			l.SupervisionTimeout = time.Duration(v) * supervisionTimeoutUnit
+		}
+		if v, ok := status[statusMTU]; ok {
+			l.MTU = v
+		}
+		if v, ok := status[statusTxPHY]; ok {
+			l.TxPHY = PHY(v)
+		}
+		if v, ok := status[statusRxPHY]; ok {
+			l.RxPHY = PHY(v)
+		}
+		link = *l
+	})
+	return &link, nil
+}
+
+// ReadMTU reads the negotiated ATT MTU of the current connection
+func (ub *UbloxBluetooth) ReadMTU() (int, error) {
+	link, err := ub.ReadLinkParameters()
+	if err != nil {
+		return 0, errors.Wrap(err, "[ReadMTU] error")
+	}
+	return link.MTU, nil
+}
+
+// LinkParameters returns the last known parameters of the current connection without asking the module
+func (ub *UbloxBluetooth) LinkParameters() (LinkParameters, error) {
+	var link LinkParameters
+	connected := false
+	ub.updateLink(func(cr *ConnectionReply) {
+		link = cr.Link
+		connected = true
+	})
+	if !connected {
+		return link, fmt.Errorf("ConnectionReply is nil")
+	}
+	return link, nil
+}
+
+// setConnection records the connected device, its disconnect handler and the release of the
+// radio it holds. The connection is locked against the reader, which updates the link of the
+// device and clears it on an unexpected disconnect.
+func (ub *UbloxBluetooth) setConnection(cr *ConnectionReply, onDisconnect DeviceEvent, release func()) {
+	ub.linkLock.Lock()
+	defer ub.linkLock.Unlock()
+	ub.connectedDevice = cr
+	ub.disconnectHandler = onDisconnect
+	ub.disconnectExpected = false
+	ub.releaseConnection = release
+}
+
+// clearConnection forgets the connected device, and any disconnect it expected, and releases the
+// radio, returning the disconnect handler
+func (ub *UbloxBluetooth) clearConnection() DeviceEvent {
+	ub.linkLock.Lock()
+	handler := ub.disconnectHandler
+	release := ub.releaseConnection
+	ub.connectedDevice = nil
+	ub.disconnectHandler = nil
+	ub.disconnectExpected = false
+	ub.releaseConnection = nil
+	ub.linkLock.Unlock()
+
+	if release != nil {
+		release()
+	}
+	return handler
+}
+
+// connection returns the connected device, or nil. Only its Link changes while connected, and
+// that is read through updateLink.
+func (ub *UbloxBluetooth) connection() *ConnectionReply {
+	ub.linkLock.Lock()
+	defer ub.linkLock.Unlock()
+	return ub.connectedDevice
+}
+
+// expectDisconnect tells the reader whether the next disconnect event was asked for
+func (ub *UbloxBluetooth) expectDisconnect(expected bool) {
+	ub.linkLock.Lock()
+	defer ub.linkLock.Unlock()
+	ub.disconnectExpected = expected
+}
+
+func (ub *UbloxBluetooth) isDisconnectExpected() bool {
+	ub.linkLock.Lock()
+	defer ub.linkLock.Unlock()
+	return ub.disconnectExpected
+}
+
+func (ub *UbloxBluetooth) updateLink(fn func(cr *ConnectionReply)) {
+	ub.linkLock.Lock()
+	defer ub.linkLock.Unlock()
+	if cr := ub.connectedDevice; cr != nil {
+		fn(cr)
+	}
+}
+
+// handlePHYUpdate records the PHYs of a +UUBTLEPHYU event and passes it to any RequestPHY
+func (ub *UbloxBluetooth) handlePHYUpdate(data []byte) error {
+	u, err := ProcessPHYUpdateEvent(data)
+	if err != nil {
+		return errors.Wrap(err, "[handlePHYUpdate] error")
+	}
+	if u.Status == 0 {
+		ub.updateLink(func(cr *ConnectionReply) {
+			if cr.Handle == u.Handle {
+				cr.Link.TxPHY = u.TxPHY
+				cr.Link.RxPHY = u.RxPHY
+			}
+		})
+	}
+	select {
+	case ub.phyUpdates <- u:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package ubloxbluetooth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ModuleBackupVersion is the version of the backup file format written by SaveModuleBackup
+const ModuleBackupVersion = 1
+
+// ModuleBackup is a snapshot of the module's configuration. Bond keys cannot be read from the
+// module so BondedDevices is only a record of which devices will need to be paired again.
+type ModuleBackup struct {
+	Version       int
+	Created       time.Time
+	Config        ModuleConfig
+	BondedDevices []string
+}
+
+// BondedDevices returns the addresses of the devices that the module is bonded with
+func (ub *UbloxBluetooth) BondedDevices() ([]string, error) {
+	d, err := ub.writeAndWait(BondedDevicesCommand(), false)
+	if err != nil {
+		return nil, err
+	}
+	return ProcessBondedDevicesReply(d)
+}
+
+// BackupModuleConfig reads the module's configuration and bonded devices into a ModuleBackup
+func (ub *UbloxBluetooth) BackupModuleConfig() (*ModuleBackup, error) {
+	cfg, err := ub.ReadModuleConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "[BackupModuleConfig] ReadModuleConfig error")
+	}
+
+	bonded, err := ub.BondedDevices()
+	if err != nil {
+		return nil, errors.Wrap(err, "[BackupModuleConfig] BondedDevices error")
+	}
+
+	return &ModuleBackup{
+		Version:       ModuleBackupVersion,
+		Created:       time.Now().UTC(),
+		Config:        *cfg,
+		BondedDevices: bonded,
+	}, nil
+}
+
+// RestoreModuleConfig applies the backed up configuration, which may come from this or another
+// module. The baud rate is left as it is, as changing it would leave the host at the wrong rate.
+func (ub *UbloxBluetooth) RestoreModuleConfig(b *ModuleBackup) ([]ConfigChange, error) {
+	if b.Version != ModuleBackupVersion {
+		return nil, fmt.Errorf("[RestoreModuleConfig] unsupported backup version %d", b.Version)
+	}
+
+	rs232, err := ub.GetRS232Settings()
+	if err != nil {
+		return nil, errors.Wrap(err, "[RestoreModuleConfig] GetRS232Settings error")
+	}
+
+	desired := b.Config
+	desired.RS232.BaudRate = rs232.BaudRate
+	return ub.ApplyModuleConfig(&desired)
+}
+
+// SaveModuleBackup writes the backup as JSON to the file at `path`, replacing any earlier backup
+// only once the new one is on disk
+func SaveModuleBackup(path string, b *ModuleBackup) error {
+	return errors.Wrap(writeJSONFile(path, b), "[SaveModuleBackup] error")
+}
+
+// LoadModuleBackup reads a backup written by SaveModuleBackup
+func LoadModuleBackup(path string) (*ModuleBackup, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "[LoadModuleBackup] read error")
+	}
+
+	b := &ModuleBackup{}
+	err = json.Unmarshal(d, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "[LoadModuleBackup] unmarshal error")
+	}
+	if b.Version < 1 || b.Version > ModuleBackupVersion {
+		return nil, fmt.Errorf("[LoadModuleBackup] unsupported backup version %d", b.Version)
+	}
+	return b, nil
+}
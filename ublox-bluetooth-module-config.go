@@ -0,0 +1,297 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// rs232FlowControlOn is the +UMRS flow control setting for RTS/CTS, any other value turns it off
+const rs232FlowControlOn = 1
+
+// rs232Setting names the RS232 ConfigChange
+const rs232Setting = "RS232 settings"
+
+// BLEConfigParams holds the +UBTLECFG parameters. Intervals are in the module's units:
+// 0.625ms for advertising and 1.25ms for connection intervals, the link loss timeout is in ms.
+// Parameters reported by the module that are not named here are kept in Other.
+type BLEConfigParams struct {
+	MinAdvertisingInterval int
+	MaxAdvertisingInterval int
+	AdvertisingChannelMap  int
+	MinConnectionInterval  int
+	MaxConnectionInterval  int
+	ConnectionLatency      int
+	LinkLossTimeout        int
+	Other                  map[int]int
+}
+
+func (p *BLEConfigParams) namedParams() map[int]*int {
+	return map[int]*int{
+		minAdvertisingInterval: &p.MinAdvertisingInterval,
+		maxAdvertisingInterval: &p.MaxAdvertisingInterval,
+		advertisingChannelMap:  &p.AdvertisingChannelMap,
+		minConnectionInterval:  &p.MinConnectionInterval,
+		maxConnectionInterval:  &p.MaxConnectionInterval,
+		connectionLatency:      &p.ConnectionLatency,
+		linkLossTimeout:        &p.LinkLossTimeout,
+	}
+}
+
+// Values returns all of the parameters keyed by their +UBTLECFG tag
+func (p *BLEConfigParams) Values() map[int]int {
+	values := map[int]int{}
+	for tag, v := range p.Other {
+		values[tag] = v
+	}
+	for tag, ptr := range p.namedParams() {
+		values[tag] = *ptr
+	}
+	return values
+}
+
+// Set assigns the parameters from a map of +UBTLECFG tag to value
+func (p *BLEConfigParams) Set(values map[int]int) {
+	named := p.namedParams()
+	for tag, v := range values {
+		if ptr, ok := named[tag]; ok {
+			*ptr = v
+			continue
+		}
+		if p.Other == nil {
+			p.Other = map[int]int{}
+		}
+		p.Other[tag] = v
+	}
+}
+
+// WatchdogSettings holds the +UDWS settings
+type WatchdogSettings struct {
+	// InactivityTimeout in milliseconds, zero disables
+	InactivityTimeout int
+	// DisconnectReset is 1 when the module resets on the disconnection of the last peer
+	DisconnectReset int
+}
+
+// EchoMode is the desired AT echo state. The module does not report it, so it is left out of Diff
+// and ApplyModuleConfig writes it whenever it is not EchoUnchanged.
+type EchoMode int
+
+const (
+	// EchoUnchanged leaves the echo as it is
+	EchoUnchanged EchoMode = 0
+	// EchoOn turns the echo on
+	EchoOn EchoMode = 1
+	// EchoOff turns the echo off
+	EchoOff EchoMode = 2
+)
+
+// ModuleConfig is the typed model of the module's stored configuration
+type ModuleConfig struct {
+	BLERole   int
+	BLEConfig BLEConfigParams
+	RS232     RS232SettingsReply
+	StartMode StartMode
+	Watchdog  WatchdogSettings
+	LocalName string
+	Echo      EchoMode
+}
+
+// ConfigChange is a single difference between two ModuleConfigs
+type ConfigChange struct {
+	Setting        string
+	Current        string
+	Desired        string
+	RequiresReboot bool
+	command        CmdResp
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Setting, c.Current, c.Desired)
+}
+
+// ReadModuleConfig queries the module for each of the settings in the ModuleConfig
+func (ub *UbloxBluetooth) ReadModuleConfig() (*ModuleConfig, error) {
+	cfg := &ModuleConfig{}
+
+	d, err := ub.writeAndWait(BLERoleQueryCommand(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadModuleConfig] BLE role error")
+	}
+	cfg.BLERole, err = ProcessIntReply(d, bleRoleResponseString)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err = ub.writeAndWait(BLEConfigQueryCommand(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadModuleConfig] BLE config error")
+	}
+	params, err := ProcessParameterListReply(d, bleConfigurationResponseString)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BLEConfig.Set(params)
+
+	rs232, err := ub.GetRS232Settings()
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadModuleConfig] RS232 settings error")
+	}
+	cfg.RS232 = *rs232
+
+	d, err = ub.writeAndWait(ModuleStartQueryCommand(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadModuleConfig] start mode error")
+	}
+	mode, err := ProcessIntReply(d, moduleStartModeResponseString)
+	if err != nil {
+		return nil, err
+	}
+	cfg.StartMode = StartMode(mode)
+
+	d, err = ub.writeAndWait(WatchdogQueryCommand(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadModuleConfig] watchdog error")
+	}
+	wd, err := ProcessParameterListReply(d, watchdogSettingsResponseString)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Watchdog.InactivityTimeout = wd[inactivityTimeoutType]
+	cfg.Watchdog.DisconnectReset = wd[disconnectResetType]
+
+	d, err = ub.writeAndWait(LocalNameQueryCommand(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadModuleConfig] local name error")
+	}
+	cfg.LocalName, err = ProcessLocalNameReply(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func intChange(setting string, current int, desired int, reboot bool, cmd CmdResp) []ConfigChange {
+	if current == desired {
+		return nil
+	}
+	return []ConfigChange{{
+		Setting:        setting,
+		Current:        fmt.Sprintf("%d", current),
+		Desired:        fmt.Sprintf("%d", desired),
+		RequiresReboot: reboot,
+		command:        cmd,
+	}}
+}
+
+func rs232Arguments(rs RS232SettingsReply) string {
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d", rs.BaudRate, rs.FlowControl, rs.DataBits, rs.StopBits, rs.Parity, rs.ChangeAfterConfirm)
+}
+
+// Diff returns the changes needed to turn `cfg` into `desired`
+func (cfg *ModuleConfig) Diff(desired *ModuleConfig) []ConfigChange {
+	changes := []ConfigChange{}
+	changes = append(changes, intChange("BLE role", cfg.BLERole, desired.BLERole, true, BLERole(desired.BLERole))...)
+
+	current := cfg.BLEConfig.Values()
+	wanted := desired.BLEConfig.Values()
+	tags := []int{}
+	for tag := range wanted {
+		tags = append(tags, tag)
+	}
+	sort.Ints(tags)
+	for _, tag := range tags {
+		c, ok := current[tag]
+		if !ok {
+			// the module did not report it, so there is nothing to compare against
+			continue
+		}
+		changes = append(changes, intChange(fmt.Sprintf("BLE config %d", tag), c, wanted[tag], true, BLEConfig(tag, wanted[tag]))...)
+	}
+
+	if desired.RS232 != cfg.RS232 {
+		changes = append(changes, ConfigChange{
+			Setting:        rs232Setting,
+			Current:        rs232Arguments(cfg.RS232),
+			Desired:        rs232Arguments(desired.RS232),
+			RequiresReboot: desired.RS232.ChangeAfterConfirm == 0,
+			command:        RS232SettingsCommand(rs232Arguments(desired.RS232)),
+		})
+	}
+
+	changes = append(changes, intChange("start mode", int(cfg.StartMode), int(desired.StartMode), true, ModuleStartCommand(desired.StartMode))...)
+	changes = append(changes, intChange("watchdog inactivity timeout", cfg.Watchdog.InactivityTimeout, desired.Watchdog.InactivityTimeout, false,
+		WatchdogCommand(inactivityTimeoutType, desired.Watchdog.InactivityTimeout))...)
+	changes = append(changes, intChange("watchdog disconnect reset", cfg.Watchdog.DisconnectReset, desired.Watchdog.DisconnectReset, false,
+		WatchdogCommand(disconnectResetType, desired.Watchdog.DisconnectReset))...)
+
+	if desired.LocalName != cfg.LocalName {
+		changes = append(changes, ConfigChange{
+			Setting: "local name",
+			Current: cfg.LocalName,
+			Desired: desired.LocalName,
+			command: LocalNameCommand(desired.LocalName),
+		})
+	}
+
+	return changes
+}
+
+// ApplyModuleConfig reads the module's configuration and writes only the settings which differ
+// from `desired`, which is normally a ModuleConfig from ReadModuleConfig that has been modified.
+// Changes are stored with AT&W and the module is only rebooted when one of them needs it.
+// The changes made are returned, a desired Echo is always written but is not one of them.
+func (ub *UbloxBluetooth) ApplyModuleConfig(desired *ModuleConfig) ([]ConfigChange, error) {
+	current, err := ub.ReadModuleConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if desired.RS232.BaudRate != current.RS232.BaudRate {
+		return nil, fmt.Errorf("[ApplyModuleConfig] baud rate changes must be made with NegotiateBaudRate")
+	}
+
+	changes := current.Diff(desired)
+	if len(changes) == 0 && desired.Echo == EchoUnchanged {
+		return changes, nil
+	}
+
+	if desired.Echo != EchoUnchanged {
+		cmd := EchoOffCommand()
+		if desired.Echo == EchoOn {
+			cmd = EchoOnCommand()
+		}
+		_, err = ub.writeAndWait(cmd, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "[ApplyModuleConfig] echo error")
+		}
+	}
+
+	reboot := false
+	for _, c := range changes {
+		_, err = ub.writeAndWait(c.command, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[ApplyModuleConfig] %s error", c.Setting)
+		}
+		reboot = reboot || c.RequiresReboot
+		if c.Setting == rs232Setting && desired.RS232.ChangeAfterConfirm == changeAfterConfirm {
+			// the module has already changed, so the host follows
+			err = ub.port().SetFlowControl(desired.RS232.FlowControl == rs232FlowControlOn, ub.timeout)
+			if err != nil {
+				return nil, errors.Wrap(err, "[ApplyModuleConfig] host flow control error")
+			}
+		}
+	}
+
+	_, err = ub.writeAndWait(BLEStoreConfig(), false)
+	if err != nil {
+		return changes, errors.Wrap(err, "[ApplyModuleConfig] store error")
+	}
+
+	if reboot {
+		err = ub.RebootUblox()
+	}
+	return changes, err
+}
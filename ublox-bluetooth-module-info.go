@@ -0,0 +1,165 @@
+package ubloxbluetooth
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ModuleInfo identifies the u-blox module and the firmware it is running
+type ModuleInfo struct {
+	Manufacturer     string
+	Model            string
+	FirmwareVersion  string
+	SerialNumber     string
+	BluetoothAddress string
+}
+
+// ParseFirmwareVersion extracts the numeric major, minor and patch values from a firmware
+// version such as "2.0.0-017". Missing values are returned as zero.
+func ParseFirmwareVersion(version string) (int, int, int, error) {
+	v := [3]int{}
+	s := strings.SplitN(version, "-", 2)[0]
+	for i, t := range strings.SplitN(s, ".", 3) {
+		n, err := strconv.Atoi(t)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("[ParseFirmwareVersion] invalid version %q", version)
+		}
+		v[i] = n
+	}
+	return v[0], v[1], v[2], nil
+}
+
+// FirmwareAtLeast returns true if the module's firmware is the version given or later
+func (m *ModuleInfo) FirmwareAtLeast(major int, minor int, patch int) bool {
+	fwMajor, fwMinor, fwPatch, err := ParseFirmwareVersion(m.FirmwareVersion)
+	if err != nil {
+		return false
+	}
+	if fwMajor != major {
+		return fwMajor > major
+	}
+	if fwMinor != minor {
+		return fwMinor > minor
+	}
+	return fwPatch >= patch
+}
+
+// queryInformationText issues an identification command and returns its unprefixed reply, which
+// the reader only passes on while this waits for it
+func (ub *UbloxBluetooth) queryInformationText(cmd string) (string, error) {
+	atomic.StoreInt32(&ub.expectingText, 1)
+	defer atomic.StoreInt32(&ub.expectingText, 0)
+
+	r := InformationCommand(cmd)
+	id, err := ub.write(r.Cmd)
+	if err != nil {
+		return "", err
+	}
+
+	text := ""
+	received := false
+	for {
+		select {
+		case data := <-ub.DataChannel:
+			if !received && !bytes.HasPrefix(data, []byte("+")) && !bytes.HasPrefix(data, []byte(at)) {
+				text = strings.Trim(string(data), "\"")
+				received = true
+			} else {
+				err := handleUnsolicitedMessage(data)
+				if err != nil {
+					return "", err
+				}
+			}
+		case <-ub.CompletedChannel:
+			if !received {
+				return "", fmt.Errorf("no information text in reply to %s", r.Cmd)
+			}
+			return text, nil
+		case e := <-ub.ErrorChannel:
+			return "", e
+		case <-ub.detachment():
+			return "", errors.Wrap(ErrDeviceGone, "[queryInformationText] error")
+		case <-time.After(ub.timeout):
+			ub.dropReply(id)
+			return "", fmt.Errorf("Timeout")
+		}
+	}
+}
+
+// ModuleInfo returns a copy of the module's identity, querying the module the first time it is called.
+func (ub *UbloxBluetooth) ModuleInfo() (*ModuleInfo, error) {
+	ub.moduleInfoLock.Lock()
+	cached := ub.moduleInfo
+	ub.moduleInfoLock.Unlock()
+	if cached != nil {
+		mi := *cached
+		return &mi, nil
+	}
+	return ub.RefreshModuleInfo()
+}
+
+// forgetModuleInfo drops the cached identity when the module is replaced
+func (ub *UbloxBluetooth) forgetModuleInfo() {
+	ub.moduleInfoLock.Lock()
+	defer ub.moduleInfoLock.Unlock()
+	ub.moduleInfo = nil
+	ub.moduleGeneration++
+}
+
+// RefreshModuleInfo queries the module for its identity and replaces the cached ModuleInfo
+func (ub *UbloxBluetooth) RefreshModuleInfo() (*ModuleInfo, error) {
+	var err error
+	mi := &ModuleInfo{}
+	ub.moduleInfoLock.Lock()
+	generation := ub.moduleGeneration
+	ub.moduleInfoLock.Unlock()
+
+	mi.Manufacturer, err = ub.queryInformationText(manufacturerIdentification)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ModuleInfo] manufacturer error")
+	}
+
+	mi.Model, err = ub.queryInformationText(modelIdentification)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ModuleInfo] model error")
+	}
+
+	mi.FirmwareVersion, err = ub.queryInformationText(firmwareVersion)
+	if err != nil {
+		// older firmware only supports the +GMR form
+		mi.FirmwareVersion, err = ub.queryInformationText(firmwareVersionAlternative)
+		if err != nil {
+			return nil, errors.Wrap(err, "[ModuleInfo] firmware version error")
+		}
+	}
+
+	mi.SerialNumber, err = ub.queryInformationText(serialNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ModuleInfo] serial number error")
+	}
+
+	d, err := ub.writeAndWait(LocalAddressCommand(bluetoothInterface), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ModuleInfo] local address error")
+	}
+	values, err := splitOutATResponses(d, localAddressResponseString)
+	if err != nil {
+		return nil, errors.Wrap(err, "[ModuleInfo] local address error")
+	}
+	mi.BluetoothAddress = values[0]
+
+	ub.moduleInfoLock.Lock()
+	if generation == ub.moduleGeneration {
+		// a module replaced during the queries is not given this one's identity
+		ub.moduleInfo = mi
+	}
+	ub.moduleInfoLock.Unlock()
+	c := *mi
+	return &c, nil
+}
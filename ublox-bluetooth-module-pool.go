@@ -0,0 +1,324 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RobHumphris/ublox-bluetooth/serial"
+	"github.com/pkg/errors"
+)
+
+// ErrNoModules is returned by the ModulePool when no healthy module is left to run a job
+var ErrNoModules = fmt.Errorf("no healthy u-blox module")
+
+// DefaultHealthCheckInterval is used when the PoolOptions HealthCheckInterval is not set
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// PoolJob is a unit of work run on one module, such as connecting to a sensor and downloading
+// its data. A job may be retried on another module so it must be safe to run again.
+type PoolJob func(ub *UbloxBluetooth) error
+
+// PoolOptions configure NewModulePool
+type PoolOptions struct {
+	// Timeout is passed to NewUbloxBluetoothOnDevice
+	Timeout time.Duration
+	// Devices are the device paths to open, when empty every device that matches Filter is used
+	Devices []string
+	// Filter selects the discovered devices, all the serial.KnownAdapters when empty
+	Filter serial.DeviceFilter
+	// ConcurrencyPerModule is the number of jobs each module runs at once, 1 when not set.
+	// The jobs on a module share its reply channels so only raise this for jobs that allow it.
+	ConcurrencyPerModule int
+	// Initialise is run on each module after it is opened, and after it is reattached
+	Initialise func(*UbloxBluetooth) error
+	// HealthCheck tests a module, ATCommand when nil
+	HealthCheck func(*UbloxBluetooth) error
+	// HealthCheckInterval is how often idle modules are checked
+	HealthCheckInterval time.Duration
+	// HotPlug enables hot-plug support on each module
+	HotPlug bool
+}
+
+// PoolModuleStatus is a snapshot of one module in the pool
+type PoolModuleStatus struct {
+	Path      string
+	Healthy   bool
+	Active    int
+	Completed uint64
+	Failed    uint64
+	LastError error
+}
+
+type poolModule struct {
+	path      string
+	ub        *UbloxBluetooth
+	healthy   bool
+	active    int
+	completed uint64
+	failed    uint64
+	lastError error
+}
+
+// ModulePool spreads jobs across several u-blox modules. Jobs go to the healthy module with
+// the fewest running jobs, and a job that fails on a module which then fails its health check
+// is moved to another module.
+type ModulePool struct {
+	options PoolOptions
+	mu      sync.Mutex
+	changed chan struct{}
+	modules []*poolModule
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewModulePool opens and health checks every module. Modules that cannot be opened are left
+// out, an error is only returned if none can be used.
+func NewModulePool(options PoolOptions) (*ModulePool, error) {
+	if options.ConcurrencyPerModule <= 0 {
+		options.ConcurrencyPerModule = 1
+	}
+	if options.HealthCheck == nil {
+		options.HealthCheck = (*UbloxBluetooth).ATCommand
+	}
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+
+	paths := options.Devices
+	if len(paths) == 0 {
+		devices, err := serial.ListUbloxDevices()
+		if err != nil {
+			return nil, errors.Wrap(err, "[NewModulePool] error")
+		}
+		for _, d := range devices {
+			if options.Filter.Matches(d) {
+				paths = append(paths, d.Path)
+			}
+		}
+	}
+
+	p := &ModulePool{
+		options: options,
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	var lastErr error
+	for _, path := range paths {
+		m, err := p.open(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p.modules = append(p.modules, m)
+	}
+	if len(p.modules) == 0 {
+		if lastErr == nil {
+			lastErr = serial.PathNotFound
+		}
+		return nil, errors.Wrap(lastErr, "[NewModulePool] no modules opened")
+	}
+
+	go p.healthChecker()
+	return p, nil
+}
+
+func (p *ModulePool) open(path string) (*poolModule, error) {
+	ub, err := NewUbloxBluetoothOnDevice(path, p.options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.options.Initialise != nil {
+		err = p.options.Initialise(ub)
+		if err != nil {
+			ub.Close()
+			return nil, errors.Wrapf(err, "[NewModulePool] %s Initialise error", path)
+		}
+	}
+
+	m := &poolModule{path: path, ub: ub}
+	m.lastError = p.options.HealthCheck(ub)
+	m.healthy = m.lastError == nil
+
+	if p.options.HotPlug {
+		ub.EnableHotPlug(HotPlugOptions{
+			Initialise: p.options.Initialise,
+			Handler: func(e HotPlugEvent) {
+				p.setHealth(m, e.Type == ModuleReattached && e.Err == nil, e.Err)
+			},
+		})
+	}
+	return m, nil
+}
+
+// notify wakes every job waiting for a module, callers hold p.mu
+func (p *ModulePool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *ModulePool) setHealth(m *poolModule, healthy bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.healthy = healthy
+	m.lastError = err
+	p.notify()
+}
+
+// acquire picks the healthy module, not in `tried`, with the fewest running jobs. If they are
+// all busy the returned channel is closed when that may have changed.
+func (p *ModulePool) acquire(tried map[*poolModule]bool) (*poolModule, <-chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *poolModule
+	available := false
+	for _, m := range p.modules {
+		if !m.healthy || tried[m] {
+			continue
+		}
+		available = true
+		if m.active < p.options.ConcurrencyPerModule && (best == nil || m.active < best.active) {
+			best = m
+		}
+	}
+	if !available {
+		return nil, nil, ErrNoModules
+	}
+	if best == nil {
+		return nil, p.changed, nil
+	}
+	best.active++
+	return best, nil, nil
+}
+
+func (p *ModulePool) release(m *poolModule, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.active--
+	if err == nil {
+		m.completed++
+	} else {
+		m.failed++
+	}
+	p.notify()
+}
+
+// Run runs `job` on a module, waiting for one to be free. If the job fails and the module
+// then fails its health check the job is run again on another module, otherwise the job's
+// error is returned.
+func (p *ModulePool) Run(ctx context.Context, job PoolJob) error {
+	tried := map[*poolModule]bool{}
+	var lastErr error
+	for {
+		m, wait, err := p.acquire(tried)
+		if err != nil {
+			if lastErr != nil {
+				return errors.Wrapf(err, "[Run] last module error %v", lastErr)
+			}
+			return err
+		}
+		if m == nil {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err = job(m.ub)
+		if err == nil {
+			p.release(m, nil)
+			return nil
+		}
+
+		// the slot is held through the check so that no job starts on the module meanwhile
+		checkErr := p.options.HealthCheck(m.ub)
+		if checkErr != nil {
+			p.setHealth(m, false, checkErr)
+		}
+		p.release(m, err)
+		if checkErr == nil {
+			return err
+		}
+		tried[m] = true
+		lastErr = err
+	}
+}
+
+// Submit runs `job` in the background, the returned channel receives its result
+func (p *ModulePool) Submit(ctx context.Context, job PoolJob) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- p.Run(ctx, job)
+	}()
+	return result
+}
+
+// HealthCheck checks every module that is not running a job
+func (p *ModulePool) HealthCheck() {
+	for _, m := range p.modules {
+		p.mu.Lock()
+		idle := m.active == 0
+		if idle {
+			// hold a slot so that no job starts during the check
+			m.active++
+		}
+		p.mu.Unlock()
+		if !idle {
+			continue
+		}
+
+		err := p.options.HealthCheck(m.ub)
+
+		p.mu.Lock()
+		m.active--
+		m.healthy = err == nil
+		m.lastError = err
+		p.notify()
+		p.mu.Unlock()
+	}
+}
+
+func (p *ModulePool) healthChecker() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(p.options.HealthCheckInterval):
+			p.HealthCheck()
+		}
+	}
+}
+
+// Status returns a snapshot of each module in the pool
+func (p *ModulePool) Status() []PoolModuleStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]PoolModuleStatus, len(p.modules))
+	for i, m := range p.modules {
+		status[i] = PoolModuleStatus{
+			Path:      m.path,
+			Healthy:   m.healthy,
+			Active:    m.active,
+			Completed: m.completed,
+			Failed:    m.failed,
+			LastError: m.lastError,
+		}
+	}
+	return status
+}
+
+// Close stops the health checks and closes every module, jobs should have finished first.
+func (p *ModulePool) Close() {
+	close(p.stop)
+	<-p.done
+	for _, m := range p.modules {
+		m.ub.Close()
+	}
+}
@@ -0,0 +1,233 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PresenceEventType enumerates the changes reported by the PresenceRegistry
+type PresenceEventType int
+
+const (
+	// DeviceAppeared is raised the first time a device is seen, or when it is seen again after being lost
+	DeviceAppeared PresenceEventType = iota
+	// DeviceUpdated is raised each time a known device is seen again
+	DeviceUpdated
+	// DeviceLost is raised when a device has not been seen for the LostAfter period
+	DeviceLost
+	// ScanFailed is raised when a background discovery returns an error
+	ScanFailed
+)
+
+func (t PresenceEventType) String() string {
+	switch t {
+	case DeviceAppeared:
+		return "appeared"
+	case DeviceUpdated:
+		return "updated"
+	case DeviceLost:
+		return "lost"
+	case ScanFailed:
+		return "scan failed"
+	}
+	return "unknown"
+}
+
+// PresenceEvent describes a change to a device's presence. Device is a copy of the registry
+// entry at the time of the event, Err is only set for ScanFailed.
+type PresenceEvent struct {
+	Type   PresenceEventType
+	Device ScannedDevice
+	Err    error
+}
+
+// DefaultLostAfter is used when the PresenceRegistry's LostAfter is not set
+const DefaultLostAfter = 60 * time.Second
+
+// PresenceRegistry keeps the latest ScannedDevice for each Bluetooth address and works out
+// which devices have appeared, been updated or been lost.
+type PresenceRegistry struct {
+	LostAfter time.Duration
+	mu        sync.Mutex
+	devices   DeviceTable
+}
+
+// NewPresenceRegistry returns an empty registry that considers devices lost after `lostAfter`
+func NewPresenceRegistry(lostAfter time.Duration) *PresenceRegistry {
+	if lostAfter <= 0 {
+		lostAfter = DefaultLostAfter
+	}
+	return &PresenceRegistry{
+		LostAfter: lostAfter,
+		devices:   DeviceTable{},
+	}
+}
+
+// Observe merges the results of a scan into the registry and returns the resulting events.
+func (pr *PresenceRegistry) Observe(table DeviceTable) []PresenceEvent {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	events := []PresenceEvent{}
+	for key, seen := range table {
+		known, ok := pr.devices[key]
+		if !ok {
+			d := *seen
+			pr.devices[key] = &d
+			events = append(events, PresenceEvent{Type: DeviceAppeared, Device: d})
+			continue
+		}
+
+		known.AverageRssi = (rssiSmoothing * seen.AverageRssi) + ((1 - rssiSmoothing) * known.AverageRssi)
+		known.Rssi = seen.Rssi
+		known.Sightings += seen.Sightings
+		known.LastSeen = seen.LastSeen
+		if seen.DeviceName != "" {
+			known.DeviceName = seen.DeviceName
+		}
+		if seen.AdvertisingData != "" {
+			known.AdvertisingData = seen.AdvertisingData
+		}
+		if seen.ScanResponseData != "" {
+			known.ScanResponseData = seen.ScanResponseData
+		}
+		for _, uuid := range seen.ServiceUUIDs {
+			if !known.HasService(uuid) {
+				known.ServiceUUIDs = append(known.ServiceUUIDs, uuid)
+			}
+		}
+		events = append(events, PresenceEvent{Type: DeviceUpdated, Device: *known})
+	}
+	return events
+}
+
+// Expire removes the devices which have not been seen since `now` less LostAfter and returns a
+// DeviceLost event for each.
+func (pr *PresenceRegistry) Expire(now time.Time) []PresenceEvent {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	events := []PresenceEvent{}
+	for key, d := range pr.devices {
+		if now.Sub(d.LastSeen) > pr.LostAfter {
+			delete(pr.devices, key)
+			events = append(events, PresenceEvent{Type: DeviceLost, Device: *d})
+		}
+	}
+	return events
+}
+
+// Devices returns a copy of the devices currently considered present
+func (pr *PresenceRegistry) Devices() DeviceTable {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	table := DeviceTable{}
+	for key, d := range pr.devices {
+		c := *d
+		table[key] = &c
+	}
+	return table
+}
+
+// DefaultPresenceInterval is the pause between background discoveries
+const DefaultPresenceInterval = 5 * time.Second
+
+// PresenceTrackerOptions configures the PresenceTracker. Scan.Results is ignored, use Events.
+type PresenceTrackerOptions struct {
+	Scan      ScanOptions
+	Interval  time.Duration
+	LostAfter time.Duration
+}
+
+// PresenceTracker repeatedly runs discovery while the module is idle and reports the presence
+// of devices on its Events channel.
+type PresenceTracker struct {
+	Events   chan PresenceEvent
+	Registry *PresenceRegistry
+	ub       *UbloxBluetooth
+	opts     PresenceTrackerOptions
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewPresenceTracker creates a tracker that uses this module, call Start to begin scanning.
+func (ub *UbloxBluetooth) NewPresenceTracker(opts PresenceTrackerOptions) *PresenceTracker {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPresenceInterval
+	}
+	opts.Scan.Results = nil
+	return &PresenceTracker{
+		Events:   make(chan PresenceEvent, 16),
+		Registry: NewPresenceRegistry(opts.LostAfter),
+		ub:       ub,
+		opts:     opts,
+	}
+}
+
+// Start launches the background scanner. It runs until Stop is called or `ctx` is done.
+func (pt *PresenceTracker) Start(ctx context.Context) {
+	ctx, pt.cancel = context.WithCancel(ctx)
+	pt.done = make(chan struct{})
+	go pt.run(ctx)
+}
+
+// Stop ends the background scanner, waiting for any discovery in progress to complete,
+// and closes the Events channel.
+func (pt *PresenceTracker) Stop() {
+	if pt.cancel == nil {
+		return
+	}
+	pt.cancel()
+	<-pt.done
+	pt.cancel = nil
+}
+
+func (pt *PresenceTracker) run(ctx context.Context) {
+	defer close(pt.done)
+	defer close(pt.Events)
+
+	for {
+		// only scan while the module has nothing better to do, this keeps discovery
+		// from delaying ConnectToDevice and the downloads that follow it.
+		if pt.ub.IsIdle() {
+			table, err := pt.ub.Scan(ctx, pt.opts.Scan)
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Cause(err) == ErrRadioBusy {
+				// a connection started since IsIdle, the registry is left as it was
+			} else if err != nil {
+				if !pt.emit(ctx, []PresenceEvent{{Type: ScanFailed, Err: err}}) {
+					return
+				}
+			} else if !pt.emit(ctx, pt.Registry.Observe(table)) {
+				return
+			}
+		}
+
+		if !pt.emit(ctx, pt.Registry.Expire(time.Now())) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pt.opts.Interval):
+		}
+	}
+}
+
+func (pt *PresenceTracker) emit(ctx context.Context, events []PresenceEvent) bool {
+	for _, e := range events {
+		select {
+		case pt.Events <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,406 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RolloutClient is the part of UbloxBluetooth that a Rollout uses
+type RolloutClient interface {
+	ConnectToDevice(address string, onConnect DeviceEvent, onDisconnect DeviceEvent) error
+	DisconnectFromDevice() error
+	EnableNotifications() error
+	EnableIndications() error
+	UnlockDevice(password []byte) (bool, error)
+	ReadConfig() (*ConfigReply, error)
+	WriteConfig(cfg *ConfigReply) error
+	ReadName() (string, error)
+	WriteName(name string) error
+}
+
+// DesiredConfig is the part of a sensor's config and name that a fleet file sets, nil values
+// are left as the sensor has them
+type DesiredConfig struct {
+	Name                  *string  `json:"name,omitempty"`
+	AdvertisingIntervalMs *int     `json:"advertisingIntervalMs,omitempty"`
+	SampleIntervalSeconds *int     `json:"sampleIntervalSeconds,omitempty"`
+	Mode                  *string  `json:"mode,omitempty"`
+	AccelRangeG           *int     `json:"accelRangeG,omitempty"`
+	AccelDataRateHz       *int     `json:"accelDataRateHz,omitempty"`
+	TemperatureOffsetC    *float64 `json:"temperatureOffsetC,omitempty"`
+}
+
+// merge returns d with the values set in `override` replacing its own
+func (d DesiredConfig) merge(override *DesiredConfig) DesiredConfig {
+	if override == nil {
+		return d
+	}
+	if override.Name != nil {
+		d.Name = override.Name
+	}
+	if override.AdvertisingIntervalMs != nil {
+		d.AdvertisingIntervalMs = override.AdvertisingIntervalMs
+	}
+	if override.SampleIntervalSeconds != nil {
+		d.SampleIntervalSeconds = override.SampleIntervalSeconds
+	}
+	if override.Mode != nil {
+		d.Mode = override.Mode
+	}
+	if override.AccelRangeG != nil {
+		d.AccelRangeG = override.AccelRangeG
+	}
+	if override.AccelDataRateHz != nil {
+		d.AccelDataRateHz = override.AccelDataRateHz
+	}
+	if override.TemperatureOffsetC != nil {
+		d.TemperatureOffsetC = override.TemperatureOffsetC
+	}
+	return d
+}
+
+// apply returns `current` with the desired values set
+func (d DesiredConfig) apply(current SensorConfig) (SensorConfig, error) {
+	if d.AdvertisingIntervalMs != nil {
+		current.AdvertisingInterval = time.Duration(*d.AdvertisingIntervalMs) * time.Millisecond
+	}
+	if d.SampleIntervalSeconds != nil {
+		current.SampleInterval = time.Duration(*d.SampleIntervalSeconds) * time.Second
+	}
+	if d.Mode != nil {
+		mode, err := ParseSensorMode(*d.Mode)
+		if err != nil {
+			return current, err
+		}
+		current.Mode = mode
+	}
+	if d.AccelRangeG != nil {
+		current.AccelRange = *d.AccelRangeG
+	}
+	if d.AccelDataRateHz != nil {
+		current.AccelDataRate = *d.AccelDataRateHz
+	}
+	if d.TemperatureOffsetC != nil {
+		current.TemperatureOffset = *d.TemperatureOffsetC
+	}
+	return current, nil
+}
+
+// FleetSensor is one sensor in a SensorGroup, Config overrides the group's
+type FleetSensor struct {
+	Address string         `json:"address"`
+	Config  *DesiredConfig `json:"config,omitempty"`
+}
+
+// SensorGroup is a set of sensors that share a DesiredConfig
+type SensorGroup struct {
+	Name    string        `json:"name"`
+	Config  DesiredConfig `json:"config"`
+	Sensors []FleetSensor `json:"sensors"`
+}
+
+// FleetConfig declares the config wanted on each sensor, a sensor may only be in one group
+type FleetConfig struct {
+	Groups []SensorGroup `json:"groups"`
+}
+
+// LoadFleetConfig reads and validates a FleetConfig JSON file
+func LoadFleetConfig(path string) (*FleetConfig, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "[LoadFleetConfig] error")
+	}
+	fc := &FleetConfig{}
+	err = json.Unmarshal(d, fc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[LoadFleetConfig] %s error", path)
+	}
+	err = fc.Validate()
+	if err != nil {
+		return nil, errors.Wrapf(err, "[LoadFleetConfig] %s error", path)
+	}
+	return fc, nil
+}
+
+// Validate checks that no sensor is listed twice and that every desired value is one a sensor
+// can hold
+func (fc *FleetConfig) Validate() error {
+	seen := map[string]string{}
+	for _, target := range fc.targets() {
+		key := addressKey(target.address)
+		if target.address == "" {
+			return fmt.Errorf("[Validate] group %s has a sensor with no address", target.group)
+		}
+		if group, ok := seen[key]; ok {
+			return fmt.Errorf("[Validate] sensor %s is in groups %s and %s", target.address, group, target.group)
+		}
+		seen[key] = target.group
+
+		// check the desired values against a config that is otherwise valid
+		base := SensorConfig{AdvertisingInterval: time.Second, SampleInterval: time.Minute, AccelRange: AccelRanges[0]}
+		c, err := target.desired.apply(base)
+		if err == nil {
+			err = c.Validate()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "[Validate] sensor %s in group %s", target.address, target.group)
+		}
+	}
+	return nil
+}
+
+type rolloutTarget struct {
+	group   string
+	address string
+	desired DesiredConfig
+}
+
+func (fc *FleetConfig) targets() []rolloutTarget {
+	targets := []rolloutTarget{}
+	for _, g := range fc.Groups {
+		for _, s := range g.Sensors {
+			targets = append(targets, rolloutTarget{group: g.Name, address: s.Address, desired: g.Config.merge(s.Config)})
+		}
+	}
+	return targets
+}
+
+// RolloutStatus is the outcome of a rollout on one sensor
+type RolloutStatus string
+
+const (
+	// RolloutApplied is a sensor that had changes written
+	RolloutApplied RolloutStatus = "applied"
+	// RolloutUnchanged is a sensor that already had the desired config
+	RolloutUnchanged RolloutStatus = "unchanged"
+	// RolloutPlanned is a sensor with changes that a dry run did not write
+	RolloutPlanned RolloutStatus = "planned"
+	// RolloutFailed is a sensor that could not be read or written
+	RolloutFailed RolloutStatus = "failed"
+	// RolloutSkipped is a sensor that an earlier run already brought up to date
+	RolloutSkipped RolloutStatus = "skipped"
+)
+
+// RolloutResult is a rollout's record of one sensor
+type RolloutResult struct {
+	Address string        `json:"address"`
+	Group   string        `json:"group"`
+	Status  RolloutStatus `json:"status"`
+	// Changes describes each value that differed from the desired one
+	Changes []string  `json:"changes,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	At      time.Time `json:"at"`
+	// Desired is the desired config that the result is for, so that a changed file is applied again
+	Desired string `json:"desired"`
+}
+
+// RolloutOptions configure a Rollout
+type RolloutOptions struct {
+	// DryRun reads each sensor and reports the changes without writing them
+	DryRun bool
+	// JournalPath, when set, is a file recording each sensor's result. Sensors it records as
+	// up to date with the same desired config are skipped, so an interrupted rollout resumes.
+	JournalPath string
+	// Password returns the password for a sensor, in place of Credentials
+	Password func(address string) []byte
+	// Credentials supply the sensors' passwords when there is no Password func
+	Credentials CredentialProvider
+	// Progress is called with each sensor's result
+	Progress func(r RolloutResult)
+}
+
+// Rollout applies a FleetConfig to its sensors, one at a time
+type Rollout struct {
+	client  RolloutClient
+	fleet   *FleetConfig
+	options RolloutOptions
+	journal map[string]RolloutResult
+	now     func() time.Time
+}
+
+// NewRollout validates the fleet config and loads the journal of any earlier run
+func NewRollout(client RolloutClient, fleet *FleetConfig, options RolloutOptions) (*Rollout, error) {
+	err := fleet.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewRollout] error")
+	}
+	if options.Password == nil && options.Credentials == nil {
+		return nil, fmt.Errorf("[NewRollout] Password or Credentials is required")
+	}
+
+	r := &Rollout{client: client, fleet: fleet, options: options, journal: map[string]RolloutResult{}, now: time.Now}
+	if options.JournalPath != "" {
+		err = readJSONFile(options.JournalPath, &r.journal)
+		if err != nil {
+			return nil, errors.Wrap(err, "[NewRollout] journal error")
+		}
+	}
+	return r, nil
+}
+
+// Run applies the config to every sensor, returning a result per sensor in file order. Sensor
+// failures are in the results, the error returned is from ctx or the journal.
+func (r *Rollout) Run(ctx context.Context) ([]RolloutResult, error) {
+	results := []RolloutResult{}
+	for _, target := range r.fleet.targets() {
+		select {
+		case <-ctx.Done():
+			return results, ctx.Err()
+		default:
+		}
+
+		d, _ := json.Marshal(target.desired)
+		desired := string(d)
+		key := addressKey(target.address)
+
+		res := RolloutResult{Address: target.address, Group: target.group, Desired: desired, At: r.now()}
+		previous, ok := r.journal[key]
+		if ok && previous.Desired == desired && (previous.Status == RolloutApplied || previous.Status == RolloutUnchanged || previous.Status == RolloutSkipped) {
+			res.Status = RolloutSkipped
+		} else {
+			changes, err := r.applyTo(target)
+			res.Changes = changes
+			switch {
+			case err != nil:
+				res.Status = RolloutFailed
+				res.Error = err.Error()
+			case len(changes) == 0:
+				res.Status = RolloutUnchanged
+			case r.options.DryRun:
+				res.Status = RolloutPlanned
+			default:
+				res.Status = RolloutApplied
+			}
+		}
+		results = append(results, res)
+
+		if r.options.JournalPath != "" && !r.options.DryRun && res.Status != RolloutSkipped {
+			r.journal[key] = res
+			err := writeJSONFile(r.options.JournalPath, r.journal)
+			if err != nil {
+				return results, errors.Wrap(err, "[Run] journal error")
+			}
+		}
+		if r.options.Progress != nil {
+			r.options.Progress(res)
+		}
+	}
+	return results, nil
+}
+
+// applyTo connects to the sensor and writes whatever differs from the desired config
+func (r *Rollout) applyTo(target rolloutTarget) ([]string, error) {
+	var changes []string
+	var applyErr error
+	err := r.client.ConnectToDevice(target.address, func() error {
+		changes, applyErr = r.applyConnected(target)
+		return r.client.DisconnectFromDevice()
+	}, func() error {
+		return nil
+	})
+	if applyErr != nil {
+		return changes, applyErr
+	}
+	return changes, err
+}
+
+func (r *Rollout) applyConnected(target rolloutTarget) ([]string, error) {
+	c := r.client
+	err := c.EnableNotifications()
+	if err != nil {
+		return nil, errors.Wrap(err, "[Rollout] EnableNotifications error")
+	}
+	err = c.EnableIndications()
+	if err != nil {
+		return nil, errors.Wrap(err, "[Rollout] EnableIndications error")
+	}
+	var password []byte
+	if r.options.Password != nil {
+		password = r.options.Password(target.address)
+	}
+	password, err = passwordFor(target.address, password, r.options.Credentials)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Rollout] error")
+	}
+	unlocked, err := c.UnlockDevice(password)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Rollout] UnlockDevice error")
+	}
+	if !unlocked {
+		return nil, fmt.Errorf("[Rollout] %s did not unlock", target.address)
+	}
+
+	changes := []string{}
+	writeName := false
+	if target.desired.Name != nil {
+		name, err := c.ReadName()
+		if err != nil {
+			return nil, errors.Wrap(err, "[Rollout] ReadName error")
+		}
+		if name != *target.desired.Name {
+			changes = append(changes, fmt.Sprintf("name %q -> %q", name, *target.desired.Name))
+			writeName = true
+		}
+	}
+
+	raw, err := c.ReadConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "[Rollout] ReadConfig error")
+	}
+	current := raw.SensorConfig()
+	wanted, err := target.desired.apply(current)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Rollout] error")
+	}
+	changes = append(changes, configChanges(current, wanted)...)
+	if len(changes) == 0 || r.options.DryRun {
+		return changes, nil
+	}
+
+	if writeName {
+		err = c.WriteName(*target.desired.Name)
+		if err != nil {
+			return changes, errors.Wrap(err, "[Rollout] WriteName error")
+		}
+	}
+	if wanted != current {
+		cr, err := wanted.ConfigReply()
+		if err != nil {
+			return changes, errors.Wrap(err, "[Rollout] error")
+		}
+		err = c.WriteConfig(cr)
+		if err != nil {
+			return changes, errors.Wrap(err, "[Rollout] WriteConfig error")
+		}
+		readBack, err := c.ReadConfig()
+		if err != nil {
+			return changes, errors.Wrap(err, "[Rollout] ReadConfig error")
+		}
+		if *readBack != *cr {
+			return changes, errors.Wrapf(ErrConfigNotApplied, "[Rollout] wrote %+v read %+v", *cr, *readBack)
+		}
+	}
+	return changes, nil
+}
+
+// configChanges describes each field that differs between two configs
+func configChanges(from SensorConfig, to SensorConfig) []string {
+	changes := []string{}
+	add := func(name string, a interface{}, b interface{}) {
+		if a != b {
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", name, a, b))
+		}
+	}
+	add("advertising interval", from.AdvertisingInterval, to.AdvertisingInterval)
+	add("sample interval", from.SampleInterval, to.SampleInterval)
+	add("mode", from.Mode, to.Mode)
+	add("accelerometer range", from.AccelRange, to.AccelRange)
+	add("accelerometer data rate", from.AccelDataRate, to.AccelDataRate)
+	add("temperature offset", from.TemperatureOffset, to.TemperatureOffset)
+	return changes
+}
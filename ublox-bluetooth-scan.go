@@ -0,0 +1,271 @@
+package ubloxbluetooth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DiscoveryType is the +UBTD discovery type
+type DiscoveryType int
+
+const (
+	// DiscoverAllWithDuplicates reports every advertisement received, devices may be seen many times
+	DiscoverAllWithDuplicates DiscoveryType = 1
+	// DiscoverGeneral reports devices in general discoverable mode
+	DiscoverGeneral DiscoveryType = 2
+	// DiscoverLimited reports devices in limited discoverable mode
+	DiscoverLimited DiscoveryType = 3
+	// DiscoverAllNoDuplicates reports each device only once
+	DiscoverAllNoDuplicates DiscoveryType = 4
+)
+
+// ScanMode selects active scanning (scan requests are sent) or passive (listen only)
+type ScanMode int
+
+const (
+	// ActiveScan requests the scan response from each advertiser
+	ActiveScan ScanMode = 1
+	// PassiveScan only listens for advertisements
+	PassiveScan ScanMode = 2
+)
+
+// +UBTD data_type values
+const scanResponseDataType = 1
+const advertisingDataType = 2
+
+// DefaultScanDuration is used when ScanOptions.Duration is not set
+const DefaultScanDuration = 5 * time.Second
+
+const minScanDuration = 10 * time.Millisecond
+const maxScanDuration = 40 * time.Second
+
+// rssiSmoothing is the weight given to the newest reading in the RSSI moving average
+const rssiSmoothing = 0.25
+
+// ScanOptions configures a Scan. Zero values select the module defaults and disable the filters.
+type ScanOptions struct {
+	DiscoveryType DiscoveryType
+	Mode          ScanMode
+	Duration      time.Duration
+	// MinRSSI excludes devices whose average RSSI is below this value (dBm), zero disables the filter
+	MinRSSI int
+	// NamePrefix only accepts devices whose name starts with the prefix
+	NamePrefix string
+	// Addresses only accepts devices in the list, the address type suffix ('r' or 'p') is optional
+	Addresses []string
+	// ServiceUUID only accepts devices advertising the 16 or 128 bit service UUID
+	ServiceUUID string
+	// Results, when not nil, receives each device the first time that it matches the filters.
+	// The channel is not closed by Scan.
+	Results chan<- *ScannedDevice
+}
+
+// ScannedDevice is the consolidated view of all the discovery replies from a single device
+type ScannedDevice struct {
+	BluetoothAddress string
+	DeviceName       string
+	Rssi             int
+	AverageRssi      float64
+	FirstSeen        time.Time
+	LastSeen         time.Time
+	Sightings        int
+	AdvertisingData  string
+	ScanResponseData string
+	ServiceUUIDs     []string
+}
+
+// DeviceTable holds the ScannedDevices keyed by Bluetooth address
+type DeviceTable map[string]*ScannedDevice
+
+func addressKey(address string) string {
+	a := strings.ToUpper(address)
+	if len(a) == 13 {
+		a = a[:12]
+	}
+	return a
+}
+
+func (sd *ScannedDevice) update(dr *DiscoveryReply, at time.Time) {
+	if sd.Sightings == 0 {
+		sd.FirstSeen = at
+		sd.AverageRssi = float64(dr.Rssi)
+	} else {
+		sd.AverageRssi = (rssiSmoothing * float64(dr.Rssi)) + ((1 - rssiSmoothing) * sd.AverageRssi)
+	}
+	sd.Sightings++
+	sd.LastSeen = at
+	sd.Rssi = dr.Rssi
+
+	switch dr.DataType {
+	case scanResponseDataType:
+		sd.ScanResponseData = dr.Data
+	case advertisingDataType:
+		sd.AdvertisingData = dr.Data
+	}
+
+	ads, _ := ParseAdvertisingData(dr.Data)
+	for _, uuid := range AdvertisedServiceUUIDs(ads) {
+		if !sd.HasService(uuid) {
+			sd.ServiceUUIDs = append(sd.ServiceUUIDs, uuid)
+		}
+	}
+
+	name := strings.Trim(dr.DeviceName, "\"")
+	if name == "" {
+		name = AdvertisedLocalName(ads)
+	}
+	if name != "" {
+		sd.DeviceName = name
+	}
+}
+
+// HasService returns true if the device has advertised the service UUID
+func (sd *ScannedDevice) HasService(uuid string) bool {
+	uuid = NormaliseUUID(uuid)
+	for _, u := range sd.ServiceUUIDs {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the device passes all of the options' filters
+func (opts *ScanOptions) Matches(sd *ScannedDevice) bool {
+	if opts.MinRSSI != 0 && sd.AverageRssi < float64(opts.MinRSSI) {
+		return false
+	}
+	if opts.NamePrefix != "" && !strings.HasPrefix(sd.DeviceName, opts.NamePrefix) {
+		return false
+	}
+	if opts.ServiceUUID != "" && !sd.HasService(opts.ServiceUUID) {
+		return false
+	}
+	if len(opts.Addresses) > 0 {
+		key := addressKey(sd.BluetoothAddress)
+		for _, a := range opts.Addresses {
+			if addressKey(a) == key {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (opts *ScanOptions) command() CmdResp {
+	dt := opts.DiscoveryType
+	if dt == 0 {
+		dt = DiscoverAllNoDuplicates
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = ActiveScan
+	}
+	return ScanCommand(dt, mode, int(opts.duration()/time.Millisecond))
+}
+
+func (opts *ScanOptions) duration() time.Duration {
+	d := opts.Duration
+	if d == 0 {
+		d = DefaultScanDuration
+	}
+	if d < minScanDuration {
+		d = minScanDuration
+	} else if d > maxScanDuration {
+		d = maxScanDuration
+	}
+	return d
+}
+
+// Scan runs a discovery configured by `opts` and returns the table of the devices that matched
+// the filters. Scan waits for any other discovery to complete first, but returns ErrRadioBusy
+// while a device is connected. Cancelling `ctx` stops results being reported, but Scan still
+// waits for the module to complete the discovery so that its replies are not mistaken for those
+// of the next command.
+func (ub *UbloxBluetooth) Scan(ctx context.Context, opts ScanOptions) (DeviceTable, error) {
+	release, err := ub.acquireDiscoveryRadio()
+	if err != nil {
+		return nil, errors.Wrap(err, "[Scan] error")
+	}
+	defer release()
+
+	sc := opts.command()
+	id, err := ub.write(sc.Cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	all := DeviceTable{}
+	matched := DeviceTable{}
+	err = ub.handleScan(ctx, sc.Resp, opts.duration()+ub.timeout, func(d []byte) error {
+		dr, err := ProcessDiscoveryReply(d)
+		if err == ErrUnexpectedResponse {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		key := addressKey(dr.BluetoothAddress)
+		sd, ok := all[key]
+		if !ok {
+			sd = &ScannedDevice{BluetoothAddress: dr.BluetoothAddress}
+			all[key] = sd
+		}
+		sd.update(dr, time.Now())
+
+		if !opts.Matches(sd) {
+			return nil
+		}
+		if _, ok := matched[key]; !ok {
+			matched[key] = sd
+			if opts.Results != nil {
+				c := *sd
+				select {
+				case opts.Results <- &c:
+				case <-ctx.Done():
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ub.dropReply(id)
+	}
+	return matched, err
+}
+
+// handleScan passes discovery replies to `fn` until the module completes the discovery.
+// Once `ctx` is done, or `fn` fails, replies are drained but no longer passed on.
+func (ub *UbloxBluetooth) handleScan(ctx context.Context, expectedResponse string, wait time.Duration, fn func([]byte) error) error {
+	var err error
+	expected := []byte(expectedResponse)
+	done := ctx.Done()
+	deadline := time.After(wait)
+	for {
+		select {
+		case data := <-ub.DataChannel:
+			if err == nil && bytes.HasPrefix(data, expected) {
+				err = fn(data)
+			}
+		case <-ub.CompletedChannel:
+			return err
+		case e := <-ub.ErrorChannel:
+			return e
+		case <-ub.detachment():
+			return errors.Wrap(ErrDeviceGone, "[handleScan] error")
+		case <-done:
+			if err == nil {
+				err = ctx.Err()
+			}
+			done = nil
+		case <-deadline:
+			return fmt.Errorf("Timeout")
+		}
+	}
+}
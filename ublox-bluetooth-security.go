@@ -0,0 +1,232 @@
+package ubloxbluetooth
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// SecurityMode is the module's +UBTSM security mode, which also sets its IO capabilities
+type SecurityMode int
+
+const (
+	// SecurityDisabled pairs without any security
+	SecurityDisabled SecurityMode = 1
+	// SecurityJustWorks encrypts the link without authentication
+	SecurityJustWorks SecurityMode = 2
+	// SecurityDisplayOnly shows a passkey for the peer to enter
+	SecurityDisplayOnly SecurityMode = 3
+	// SecurityDisplayYesNo shows a value for numeric comparison
+	SecurityDisplayYesNo SecurityMode = 4
+	// SecurityKeyboardOnly enters the passkey that the peer shows
+	SecurityKeyboardOnly SecurityMode = 5
+	// SecurityOutOfBand exchanges keys out of band
+	SecurityOutOfBand SecurityMode = 6
+)
+
+// SecurityType restricts the pairing methods that the module accepts
+type SecurityType int
+
+const (
+	// SecurityAllowLegacy accepts legacy pairing as well as LE Secure Connections
+	SecurityAllowLegacy SecurityType = 0
+	// SecuritySecureConnectionsOnly only accepts LE Secure Connections
+	SecuritySecureConnectionsOnly SecurityType = 1
+)
+
+// SecuritySettings are the +UBTSM settings
+type SecuritySettings struct {
+	Mode SecurityMode
+	Type SecurityType
+}
+
+// BondStatus is the status reported by a +UUBTB bond event
+type BondStatus int
+
+const (
+	// BondSucceeded is a completed bond
+	BondSucceeded BondStatus = 0
+	// BondTimedOut is a bond that the peer did not complete in time
+	BondTimedOut BondStatus = 1
+	// BondFailed is a bond that was refused or failed
+	BondFailed BondStatus = 2
+	// BondFailedMIC is a bond that failed its integrity check, usually from a wrong passkey
+	BondFailedMIC BondStatus = 3
+)
+
+// BondError is returned by Bond when the module reports anything but BondSucceeded
+type BondError struct {
+	Address string
+	Status  BondStatus
+}
+
+func (e *BondError) Error() string {
+	return fmt.Sprintf("bond with %s failed with status %d", e.Address, e.Status)
+}
+
+// PairingHandler answers the module's requests during pairing. A nil function rejects the
+// request it would answer, or ignores the event.
+type PairingHandler struct {
+	// PasskeyEntry returns the passkey shown by the device at `address`, false rejects the pairing
+	PasskeyEntry func(address string) (int, bool)
+	// NumericComparison returns true when `value` matches the value shown by the device
+	NumericComparison func(address string, value int) bool
+	// PasskeyDisplay shows the passkey to be entered on the device
+	PasskeyDisplay func(address string, passkey int)
+	// Bonded is told the outcome of every bond, including those the peer starts
+	Bonded func(address string, status BondStatus)
+}
+
+// GetSecuritySettings reads the module's security mode and type
+func (ub *UbloxBluetooth) GetSecuritySettings() (*SecuritySettings, error) {
+	d, err := ub.writeAndWait(SecurityModeQueryCommand(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetSecuritySettings] error")
+	}
+	mode, securityType, err := ProcessSecurityModeReply(d)
+	if err != nil {
+		return nil, errors.Wrap(err, "[GetSecuritySettings] error")
+	}
+	return &SecuritySettings{Mode: SecurityMode(mode), Type: SecurityType(securityType)}, nil
+}
+
+// SetSecuritySettings sets the module's security mode and type
+func (ub *UbloxBluetooth) SetSecuritySettings(s SecuritySettings) error {
+	if s.Mode < SecurityDisabled || s.Mode > SecurityOutOfBand {
+		return fmt.Errorf("[SetSecuritySettings] unknown security mode %d", s.Mode)
+	}
+	_, err := ub.writeAndWait(SecurityModeCommand(int(s.Mode), int(s.Type)), false)
+	return errors.Wrap(err, "[SetSecuritySettings] error")
+}
+
+// GetPairingMode returns true if the module accepts pairing
+func (ub *UbloxBluetooth) GetPairingMode() (bool, error) {
+	d, err := ub.writeAndWait(PairingModeQueryCommand(), true)
+	if err != nil {
+		return false, errors.Wrap(err, "[GetPairingMode] error")
+	}
+	mode, err := ProcessIntReply(d, pairingModeResponseString)
+	if err != nil {
+		return false, errors.Wrap(err, "[GetPairingMode] error")
+	}
+	return mode == 2, nil
+}
+
+// SetPairingMode allows or refuses pairing
+func (ub *UbloxBluetooth) SetPairingMode(enabled bool) error {
+	mode := 1
+	if enabled {
+		mode = 2
+	}
+	_, err := ub.writeAndWait(PairingModeCommand(mode), false)
+	return errors.Wrap(err, "[SetPairingMode] error")
+}
+
+// SetPairingHandler sets the handler for pairing requests, nil rejects them all
+func (ub *UbloxBluetooth) SetPairingHandler(h *PairingHandler) {
+	ub.securityLock.Lock()
+	defer ub.securityLock.Unlock()
+	ub.pairingHandler = h
+}
+
+func (ub *UbloxBluetooth) getPairingHandler() *PairingHandler {
+	ub.securityLock.Lock()
+	defer ub.securityLock.Unlock()
+	if ub.pairingHandler == nil {
+		return &PairingHandler{}
+	}
+	return ub.pairingHandler
+}
+
+// Bond bonds with the device at `address`, the PairingHandler answers any passkey or comparison
+// requests. A failed bond returns a *BondError.
+func (ub *UbloxBluetooth) Bond(address string) error {
+	release, err := ub.acquireRadio()
+	if err != nil {
+		return errors.Wrap(err, "[Bond] error")
+	}
+	defer release()
+
+	d, err := ub.writeAndWait(BondCommand(address), true)
+	if err != nil {
+		return errors.Wrap(err, "[Bond] error")
+	}
+	bonded, status, err := ProcessAddressIntEvent(d, bondResponse)
+	if err != nil {
+		return errors.Wrap(err, "[Bond] error")
+	}
+	if BondStatus(status) != BondSucceeded {
+		return &BondError{Address: bonded, Status: BondStatus(status)}
+	}
+	return nil
+}
+
+// Unbond removes the bond with the device at `address`
+func (ub *UbloxBluetooth) Unbond(address string) error {
+	_, err := ub.writeAndWait(UnbondCommand(address), false)
+	return errors.Wrap(err, "[Unbond] error")
+}
+
+// UnbondAll removes every bond
+func (ub *UbloxBluetooth) UnbondAll() error {
+	return ub.Unbond(unbondAllAddress)
+}
+
+// handleSecurityEvent passes pairing events to the PairingHandler. Passkey and comparison
+// requests are answered from their own goroutine, as the handler may wait for a user, and are
+// not passed on. Returns true if `data` has been consumed.
+func (ub *UbloxBluetooth) handleSecurityEvent(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, userPasskeyEntryResponse):
+		address, _, err := ProcessAddressIntEvent(data, userPasskeyEntryResponse)
+		if err == nil {
+			go func() {
+				h := ub.getPairingHandler()
+				passkey, accept := 0, false
+				if h.PasskeyEntry != nil {
+					passkey, accept = h.PasskeyEntry(address)
+				}
+				ub.answerSecurityRequest(UserPasskeyEntryCommand(address, accept, passkey))
+			}()
+		}
+		return true
+	case bytes.HasPrefix(data, userConfirmationResponse):
+		address, value, err := ProcessAddressIntEvent(data, userConfirmationResponse)
+		if err == nil {
+			go func() {
+				h := ub.getPairingHandler()
+				accept := h.NumericComparison != nil && h.NumericComparison(address, value)
+				ub.answerSecurityRequest(UserConfirmationCommand(address, accept))
+			}()
+		}
+		return true
+	case bytes.HasPrefix(data, userPasskeyDisplayResponse):
+		address, passkey, err := ProcessAddressIntEvent(data, userPasskeyDisplayResponse)
+		h := ub.getPairingHandler()
+		if err == nil && h.PasskeyDisplay != nil {
+			go h.PasskeyDisplay(address, passkey)
+		}
+		return true
+	case bytes.HasPrefix(data, bondResponse):
+		// Bond waits for this too, so it is passed on
+		address, status, err := ProcessAddressIntEvent(data, bondResponse)
+		h := ub.getPairingHandler()
+		if err == nil && h.Bonded != nil {
+			go h.Bonded(address, BondStatus(status))
+		}
+	}
+	return false
+}
+
+// answerSecurityRequest writes the answer without taking the place of the command in progress,
+// its OK or ERROR is matched to it and dropped by handleGeneralMessage
+func (ub *UbloxBluetooth) answerSecurityRequest(r CmdResp) {
+	var b []byte
+	if ub.mode() == extendedDataMode {
+		b = NewEDMATCommand(r.Cmd)
+	} else {
+		b = append([]byte(r.Cmd), tail...)
+	}
+	ub.writeCommand(b, true)
+}
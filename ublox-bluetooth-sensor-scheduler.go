@@ -0,0 +1,651 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SensorClient is the part of UbloxBluetooth that the SensorScheduler uses
+type SensorClient interface {
+	Scan(ctx context.Context, opts ScanOptions) (DeviceTable, error)
+	ConnectToDevice(address string, onConnect DeviceEvent, onDisconnect DeviceEvent) error
+	DisconnectFromDevice() error
+	EnableNotifications() error
+	EnableIndications() error
+	UnlockDevice(password []byte) (bool, error)
+	GetInfo() (*InfoReply, error)
+	SetTime(t time.Time) error
+	GetVersion() (*VersionReply, error)
+	ReadConfig() (*ConfigReply, error)
+	WriteConfig(cfg *ConfigReply) error
+	DownloadEventLog(startingIndex int, fn DownloadNotificationHandler) error
+	ReadSlotCount() (*SlotCountReply, error)
+	ReadSlotInfo(slotNumber int) (*SlotInfoReply, error)
+	DownloadSlot(sd *SlotDownload) error
+}
+
+// FetchFlags select what the SensorScheduler collects from a sensor
+type FetchFlags int
+
+const (
+	// FetchInfo reads the sensor's InfoReply, it is always read when FetchEvents is set
+	FetchInfo FetchFlags = 1 << iota
+	// FetchVersion reads the sensor's VersionReply
+	FetchVersion
+	// FetchConfig reads the sensor's ConfigReply
+	FetchConfig
+	// FetchEvents downloads the events logged since the last collection
+	FetchEvents
+	// FetchSlots downloads the slots recorded since the last collection
+	FetchSlots
+)
+
+// DefaultSensorInterval is used when a SensorPolicy has no Interval
+const DefaultSensorInterval = 24 * time.Hour
+
+// DefaultRetryBackoff is used when a SensorPolicy has no RetryBackoff
+const DefaultRetryBackoff = time.Minute
+
+// maxSlotResumes is the number of times an incomplete slot download is resumed in one collection
+const maxSlotResumes = 2
+
+// SensorPolicy controls when and how a sensor is collected
+type SensorPolicy struct {
+	// Interval between successful collections
+	Interval time.Duration
+	// Priority orders sensors that are due at the same time, highest first
+	Priority int
+	// Fetch is what to collect
+	Fetch FetchFlags
+	// Config is written to the sensor when it differs from the sensor's own
+	Config *ConfigReply
+	// MinRSSI skips the sensor when the last discovery saw it below this value (dBm), or did
+	// not see it at all. Zero disables the check.
+	MinRSSI int
+	// MaxRetries is the number of retries after a failure before waiting for the next Interval
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, it doubles with each further failure
+	RetryBackoff time.Duration
+	// SyncClock sets the sensor's clock when it is further than this from the host's. The
+	// offset is measured whenever the info is read, zero never sets the clock.
+	SyncClock time.Duration
+}
+
+// Sensor is a VEH sensor to be collected by the SensorScheduler
+type Sensor struct {
+	Address string
+	// Password unlocks the sensor, when nil it comes from SchedulerOptions.Credentials
+	Password []byte
+	Policy   SensorPolicy
+}
+
+// SensorState is the scheduler's record of a sensor's collections
+type SensorState struct {
+	NextDue     time.Time
+	LastAttempt time.Time
+	LastSuccess time.Time
+	// Failures is the number of consecutive failed collections
+	Failures  int
+	LastError error
+	// NextEventIndex is the index of the first event not yet downloaded
+	NextEventIndex int
+	// SlotsDownloaded is the number of slots already downloaded
+	SlotsDownloaded int
+}
+
+// SlotData is one slot downloaded from a sensor
+type SlotData struct {
+	Info *SlotInfoReply
+	Data []byte
+}
+
+// SensorResult is the outcome of one collection
+type SensorResult struct {
+	Address    string
+	Started    time.Time
+	Finished   time.Time
+	Skipped    bool
+	SkipReason string
+	Info       *InfoReply
+	// Clock holds the clock offset measured when the info was read, followed by a Set entry
+	// if the clock was then set
+	Clock         ClockHistory
+	Version       *VersionReply
+	Config        *ConfigReply
+	ConfigUpdated bool
+	// FirstEventIndex is the sequence number of the first of the Events
+	FirstEventIndex int
+	Events          [][]byte
+	Slots           []SlotData
+	Err             error
+}
+
+// ResultSink receives each SensorResult
+type ResultSink interface {
+	SensorResult(r *SensorResult) error
+}
+
+// SchedulerOptions configure a SensorScheduler
+type SchedulerOptions struct {
+	// Scan, when not nil, is run before each round of collections that includes a sensor
+	// with a MinRSSI, and its results replace the sightings.
+	Scan *ScanOptions
+	// Store, when not nil, receives everything collected and decides which events and
+	// slots are new, in place of the SensorState.
+	Store SensorStore
+	// Credentials supply the password of each Sensor that has none
+	Credentials CredentialProvider
+	// ErrorHandler, when not nil, is given the errors that Run carries on after, such as
+	// a failing sink
+	ErrorHandler func(err error)
+	// ErrorBackoff is Run's shortest wait after such an error, DefaultRetryBackoff when not set
+	ErrorBackoff time.Duration
+}
+
+type scheduledSensor struct {
+	sensor Sensor
+	state  SensorState
+}
+
+// SensorScheduler collects a list of sensors on a single module, each according to its own
+// SensorPolicy, and sends the results to a ResultSink.
+type SensorScheduler struct {
+	client    SensorClient
+	sink      ResultSink
+	options   SchedulerOptions
+	mu        sync.Mutex
+	sensors   map[string]*scheduledSensor
+	sightings DeviceTable
+	wake      chan struct{}
+	now       func() time.Time
+}
+
+// NewSensorScheduler returns a scheduler with no sensors
+func NewSensorScheduler(client SensorClient, sink ResultSink, options SchedulerOptions) *SensorScheduler {
+	return &SensorScheduler{
+		client:  client,
+		sink:    sink,
+		options: options,
+		sensors: map[string]*scheduledSensor{},
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+}
+
+// AddSensor adds or replaces a sensor, a new sensor is due straight away
+func (s *SensorScheduler) AddSensor(sensor Sensor) {
+	if sensor.Policy.Interval <= 0 {
+		sensor.Policy.Interval = DefaultSensorInterval
+	}
+	if sensor.Policy.RetryBackoff <= 0 {
+		sensor.Policy.RetryBackoff = DefaultRetryBackoff
+	}
+
+	s.mu.Lock()
+	key := addressKey(sensor.Address)
+	ss, ok := s.sensors[key]
+	if ok {
+		ss.sensor = sensor
+	} else {
+		s.sensors[key] = &scheduledSensor{sensor: sensor, state: SensorState{NextDue: s.now()}}
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RemoveSensor stops collecting the sensor
+func (s *SensorScheduler) RemoveSensor(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sensors, addressKey(address))
+}
+
+// State returns a copy of the sensor's state
+func (s *SensorScheduler) State(address string) (SensorState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sensors[addressKey(address)]
+	if !ok {
+		return SensorState{}, false
+	}
+	return ss.state, true
+}
+
+// SetState replaces a sensor's state, such as one saved from an earlier run
+func (s *SensorScheduler) SetState(address string, state SensorState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sensors[addressKey(address)]
+	if !ok {
+		return fmt.Errorf("[SetState] unknown sensor %s", address)
+	}
+	ss.state = state
+	return nil
+}
+
+// UpdateSightings replaces the discovery results used for the MinRSSI checks
+func (s *SensorScheduler) UpdateSightings(table DeviceTable) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sightings = table
+}
+
+// due returns the sensors due at `now`, highest priority first then most overdue first
+func (s *SensorScheduler) due(now time.Time) []*scheduledSensor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := []*scheduledSensor{}
+	for _, ss := range s.sensors {
+		if !ss.state.NextDue.After(now) {
+			due = append(due, ss)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].sensor.Policy.Priority != due[j].sensor.Policy.Priority {
+			return due[i].sensor.Policy.Priority > due[j].sensor.Policy.Priority
+		}
+		return due[i].state.NextDue.Before(due[j].state.NextDue)
+	})
+	return due
+}
+
+// nextDue returns the earliest time that a sensor is due, false if there are no sensors
+func (s *SensorScheduler) nextDue() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	found := false
+	for _, ss := range s.sensors {
+		if !found || ss.state.NextDue.Before(next) {
+			next = ss.state.NextDue
+			found = true
+		}
+	}
+	return next, found
+}
+
+// outOfRange returns a reason if the last discovery says that the sensor cannot be reached
+func (s *SensorScheduler) outOfRange(sensor Sensor) string {
+	if sensor.Policy.MinRSSI == 0 {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sightings == nil {
+		return ""
+	}
+	sd, ok := s.sightings[addressKey(sensor.Address)]
+	if !ok {
+		return "not seen in discovery"
+	}
+	if int(sd.AverageRssi) < sensor.Policy.MinRSSI {
+		return fmt.Sprintf("RSSI %.0f below %d", sd.AverageRssi, sensor.Policy.MinRSSI)
+	}
+	return ""
+}
+
+func (s *SensorScheduler) needsScan(due []*scheduledSensor) bool {
+	if s.options.Scan == nil {
+		return false
+	}
+	for _, ss := range due {
+		if ss.sensor.Policy.MinRSSI != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RunDue collects every sensor that is due, returning the number collected successfully.
+// Collection errors go to the sink. A failed scan skips the sensors that need it, with the scan
+// error in their results. Scan, store and sink errors do not stop the other sensors, the first
+// of them is returned once every sensor has been tried.
+func (s *SensorScheduler) RunDue(ctx context.Context) (int, error) {
+	due := s.due(s.now())
+	var scanErr error
+	if s.needsScan(due) {
+		table, err := s.client.Scan(ctx, *s.options.Scan)
+		if err != nil {
+			scanErr = errors.Wrap(err, "[RunDue] Scan error")
+		} else {
+			s.UpdateSightings(table)
+		}
+	}
+
+	firstErr := scanErr
+	collected := 0
+	for _, ss := range due {
+		select {
+		case <-ctx.Done():
+			return collected, ctx.Err()
+		default:
+		}
+
+		s.mu.Lock()
+		sensor := ss.sensor
+		state := ss.state
+		s.mu.Unlock()
+
+		r := &SensorResult{Address: sensor.Address, Started: s.now()}
+		reason := s.outOfRange(sensor)
+		if scanErr != nil && sensor.Policy.MinRSSI != 0 {
+			r.Skipped = true
+			r.SkipReason = "scan failed"
+			r.Err = scanErr
+			state.NextDue = r.Started.Add(sensor.Policy.RetryBackoff)
+		} else if reason != "" {
+			r.Skipped = true
+			r.SkipReason = reason
+			state.NextDue = r.Started.Add(sensor.Policy.RetryBackoff)
+		} else {
+			r.Err = s.collect(sensor, &state, r)
+			s.schedule(sensor, &state, r)
+			if r.Err == nil {
+				collected++
+			}
+		}
+		r.Finished = s.now()
+
+		s.mu.Lock()
+		ss.state = state
+		s.mu.Unlock()
+
+		if s.options.Store != nil && !r.Skipped {
+			err := s.storeResult(sensor, r)
+			if err != nil && firstErr == nil {
+				firstErr = errors.Wrap(err, "[RunDue] store error")
+			}
+		}
+
+		if s.sink != nil {
+			err := s.sink.SensorResult(r)
+			if err != nil && firstErr == nil {
+				firstErr = errors.Wrap(err, "[RunDue] sink error")
+			}
+		}
+	}
+	return collected, firstErr
+}
+
+// schedule updates the retry state after a collection
+func (s *SensorScheduler) schedule(sensor Sensor, state *SensorState, r *SensorResult) {
+	state.LastAttempt = r.Started
+	state.LastError = r.Err
+	if r.Err == nil {
+		state.LastSuccess = r.Started
+		state.Failures = 0
+		state.NextDue = r.Started.Add(sensor.Policy.Interval)
+		return
+	}
+
+	state.Failures++
+	if state.Failures > sensor.Policy.MaxRetries {
+		state.Failures = 0
+		state.NextDue = r.Started.Add(sensor.Policy.Interval)
+		return
+	}
+	backoff := sensor.Policy.RetryBackoff << uint(state.Failures-1)
+	if backoff <= 0 || backoff > sensor.Policy.Interval {
+		backoff = sensor.Policy.Interval
+	}
+	state.NextDue = r.Started.Add(backoff)
+}
+
+// collect connects to the sensor and fetches what its policy asks for
+func (s *SensorScheduler) collect(sensor Sensor, state *SensorState, r *SensorResult) error {
+	var collectErr error
+	err := s.client.ConnectToDevice(sensor.Address, func() error {
+		collectErr = s.fetch(sensor, state, r)
+		return s.client.DisconnectFromDevice()
+	}, func() error {
+		return nil
+	})
+	if collectErr != nil {
+		return collectErr
+	}
+	return err
+}
+
+func (s *SensorScheduler) fetch(sensor Sensor, state *SensorState, r *SensorResult) error {
+	c := s.client
+	fetch := sensor.Policy.Fetch
+
+	err := c.EnableNotifications()
+	if err != nil {
+		return errors.Wrap(err, "[collect] EnableNotifications error")
+	}
+	err = c.EnableIndications()
+	if err != nil {
+		return errors.Wrap(err, "[collect] EnableIndications error")
+	}
+	password, err := passwordFor(sensor.Address, sensor.Password, s.options.Credentials)
+	if err != nil {
+		return errors.Wrap(err, "[collect] error")
+	}
+	unlocked, err := c.UnlockDevice(password)
+	if err != nil {
+		return errors.Wrap(err, "[collect] UnlockDevice error")
+	}
+	if !unlocked {
+		return fmt.Errorf("[collect] %s did not unlock", sensor.Address)
+	}
+
+	if fetch&(FetchInfo|FetchEvents) != 0 || sensor.Policy.SyncClock > 0 {
+		sent := s.now()
+		r.Info, err = c.GetInfo()
+		if err != nil {
+			return errors.Wrap(err, "[collect] GetInfo error")
+		}
+		r.Clock = ClockHistory{NewClockOffset(r.Info.CurrentTime, sent, s.now())}
+	}
+	if sensor.Policy.SyncClock > 0 {
+		offset := r.Clock[0].Offset
+		if offset < 0 {
+			offset = -offset
+		}
+		if offset > sensor.Policy.SyncClock {
+			now := s.now()
+			err = c.SetTime(now)
+			if err != nil {
+				return errors.Wrap(err, "[collect] SetTime error")
+			}
+			r.Clock = append(r.Clock, clockSetAt(now))
+		}
+	}
+	if fetch&FetchVersion != 0 {
+		r.Version, err = c.GetVersion()
+		if err != nil {
+			return errors.Wrap(err, "[collect] GetVersion error")
+		}
+	}
+	if fetch&FetchConfig != 0 || sensor.Policy.Config != nil {
+		r.Config, err = c.ReadConfig()
+		if err != nil {
+			return errors.Wrap(err, "[collect] ReadConfig error")
+		}
+	}
+	if sensor.Policy.Config != nil && *sensor.Policy.Config != *r.Config {
+		err = c.WriteConfig(sensor.Policy.Config)
+		if err != nil {
+			return errors.Wrap(err, "[collect] WriteConfig error")
+		}
+		r.ConfigUpdated = true
+	}
+
+	if fetch&FetchEvents != 0 {
+		err = s.fetchEvents(state, r)
+		if err != nil {
+			return err
+		}
+	}
+	if fetch&FetchSlots != 0 {
+		return s.fetchSlots(state, r)
+	}
+	return nil
+}
+
+// fetchEvents downloads the events after NextEventIndex, or every event held on the first collection
+func (s *SensorScheduler) fetchEvents(state *SensorState, r *SensorResult) error {
+	start := state.NextEventIndex
+	if s.options.Store != nil {
+		last, ok, err := s.options.Store.LastEventSequence(r.Address)
+		if err != nil {
+			return errors.Wrap(err, "[collect] LastEventSequence error")
+		}
+		if ok {
+			start = last + 1
+		}
+	}
+	oldest := r.Info.CurrentSequenceNumber - r.Info.RecordsCount
+	if start < oldest || start > r.Info.CurrentSequenceNumber {
+		start = oldest
+	}
+	if start == r.Info.CurrentSequenceNumber {
+		return nil
+	}
+	r.FirstEventIndex = start
+
+	err := s.client.DownloadEventLog(start, func(b []byte) error {
+		r.Events = append(r.Events, b)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "[collect] DownloadEventLog error")
+	}
+	state.NextEventIndex = r.Info.CurrentSequenceNumber
+	return nil
+}
+
+// fetchSlots downloads the slots recorded since the last collection
+func (s *SensorScheduler) fetchSlots(state *SensorState, r *SensorResult) error {
+	count, err := s.client.ReadSlotCount()
+	if err != nil {
+		return errors.Wrap(err, "[collect] ReadSlotCount error")
+	}
+	if count.Count < state.SlotsDownloaded || s.options.Store != nil {
+		// the slots have been erased, or the store decides which are new
+		state.SlotsDownloaded = 0
+	}
+
+	for slot := state.SlotsDownloaded; slot < count.Count; slot++ {
+		info, err := s.client.ReadSlotInfo(slot)
+		if err != nil {
+			return errors.Wrapf(err, "[collect] ReadSlotInfo %d error", slot)
+		}
+		if s.options.Store != nil {
+			stored, err := s.options.Store.HasSlot(r.Address, info.Time, slot)
+			if err != nil {
+				return errors.Wrap(err, "[collect] HasSlot error")
+			}
+			if stored {
+				continue
+			}
+		}
+		download := NewSlotDownload(slot, info)
+		err = s.client.DownloadSlot(download)
+		for resumes := 0; IsIncompleteDownload(err) != nil && resumes < maxSlotResumes; resumes++ {
+			err = s.client.DownloadSlot(download)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "[collect] DownloadSlot %d error", slot)
+		}
+		r.Slots = append(r.Slots, SlotData{Info: info, Data: download.Payload()})
+		state.SlotsDownloaded = slot + 1
+	}
+	return nil
+}
+
+// storeResult saves whatever was collected, even if the collection then failed
+func (s *SensorScheduler) storeResult(sensor Sensor, r *SensorResult) error {
+	store := s.options.Store
+	var config *SensorConfigRecord
+	if r.ConfigUpdated {
+		config = &SensorConfigRecord{At: r.Started, Config: *sensor.Policy.Config, Written: true}
+	} else if r.Config != nil {
+		config = &SensorConfigRecord{At: r.Started, Config: *r.Config}
+	}
+	if r.Err == nil || r.Info != nil || r.Version != nil || config != nil {
+		err := store.UpdateMetadata(r.Address, r.Version, r.Info, config, r.Started)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.Clock) > 0 {
+		err := store.AddClockOffsets(r.Address, r.Clock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.Events) > 0 {
+		events := make([]EventRecord, len(r.Events))
+		for i, e := range r.Events {
+			events[i] = EventRecord{Sequence: eventSequence(r.FirstEventIndex, i), Data: e, Received: r.Finished}
+		}
+		_, err := store.AddEvents(r.Address, events)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.Slots) > 0 {
+		slots := make([]SlotRecording, len(r.Slots))
+		for i, sd := range r.Slots {
+			slots[i] = SlotRecording{Time: sd.Info.Time, Slot: sd.Info.Slot, Info: *sd.Info, Data: sd.Data, Received: r.Finished}
+		}
+		_, err := store.AddSlots(r.Address, slots)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run collects sensors as they fall due until ctx is cancelled. Other errors from RunDue go to
+// the ErrorHandler and Run backs off before carrying on.
+func (s *SensorScheduler) Run(ctx context.Context) error {
+	for {
+		_, err := s.RunDue(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		wait := DefaultSensorInterval
+		next, ok := s.nextDue()
+		if ok {
+			wait = next.Sub(s.now())
+		}
+		if err != nil {
+			if s.options.ErrorHandler != nil {
+				s.options.ErrorHandler(err)
+			}
+			backoff := s.options.ErrorBackoff
+			if backoff <= 0 {
+				backoff = DefaultRetryBackoff
+			}
+			if wait < backoff {
+				wait = backoff
+			}
+		}
+		if wait <= 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.wake:
+		case <-time.After(wait):
+		}
+	}
+}
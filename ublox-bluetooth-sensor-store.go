@@ -0,0 +1,604 @@
+package ubloxbluetooth
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EventRecord is one event log record from a sensor. Revision is set by the store.
+type EventRecord struct {
+	Sequence int
+	Data     []byte
+	Received time.Time
+	Revision uint64
+}
+
+// eventSequence is the sequence number of notification `n` of an event log download from `start`.
+// DownloadEventLog starts at a record index, GetInfo counts the log in the same records, and the
+// sensor sends each record in a notification of its own, so the n'th notification is record start+n.
+func eventSequence(start int, n int) int {
+	return start + n
+}
+
+// SlotRecording is one slot downloaded from a sensor, identified by its Time and Slot number.
+// Revision is set by the store.
+type SlotRecording struct {
+	Time     int
+	Slot     int
+	Info     SlotInfoReply
+	Data     []byte
+	Received time.Time
+	Revision uint64
+}
+
+// SensorConfigRecord is a configuration read from, or written to, a sensor
+type SensorConfigRecord struct {
+	At      time.Time
+	Config  ConfigReply
+	Written bool
+}
+
+// SensorMetadata is what the store knows about a sensor other than its recorded data
+type SensorMetadata struct {
+	Address       string
+	Version       *VersionReply
+	Info          *InfoReply
+	ConfigHistory []SensorConfigRecord
+	// Clock corrects the sensor's timestamps, including those already stored
+	Clock         ClockHistory
+	LastCollected time.Time
+}
+
+// SensorChanges are the records added to the store after a revision
+type SensorChanges struct {
+	Events   []EventRecord
+	Slots    []SlotRecording
+	Revision uint64
+}
+
+// SensorStore holds the data downloaded from sensors. Every record added is given a revision,
+// increasing for each sensor, so that each consumer can ask for what is new since its last sync.
+type SensorStore interface {
+	// UpdateMetadata records the replies from a collection, nil values are left unchanged.
+	// The config is added to the history when it differs from the latest entry.
+	UpdateMetadata(address string, version *VersionReply, info *InfoReply, config *SensorConfigRecord, at time.Time) error
+	// AddClockOffsets appends measurements to the sensor's clock history
+	AddClockOffsets(address string, offsets []ClockOffset) error
+	// Metadata returns the sensor's metadata, nil if the sensor is unknown
+	Metadata(address string) (*SensorMetadata, error)
+	// Sensors returns the address of every sensor in the store
+	Sensors() ([]string, error)
+	// AddEvents stores the records whose sequence numbers are new, returning the number stored
+	AddEvents(address string, events []EventRecord) (int, error)
+	// AddSlots stores the recordings whose time and slot number are new, returning the number stored
+	AddSlots(address string, slots []SlotRecording) (int, error)
+	// LastEventSequence returns the highest event sequence number stored for the sensor
+	LastEventSequence(address string) (int, bool, error)
+	// HasSlot returns true if the recording is already stored
+	HasSlot(address string, slotTime int, slot int) (bool, error)
+	// Changes returns the records with a revision after `since`
+	Changes(address string, since uint64) (*SensorChanges, error)
+	// SyncCursor returns the revision that `consumer` has synced the sensor up to
+	SyncCursor(consumer string, address string) (uint64, error)
+	// SetSyncCursor records that `consumer` has synced the sensor up to `revision`
+	SetSyncCursor(consumer string, address string, revision uint64) error
+	Close() error
+}
+
+const metadataFile = "metadata.json"
+const eventsFile = "events.jsonl"
+const slotsFile = "slots.jsonl"
+const cursorsFile = "cursors.json"
+
+type slotKey struct {
+	time int
+	slot int
+}
+
+type storedSensor struct {
+	dir          string
+	metadata     *SensorMetadata
+	events       []EventRecord
+	sequences    map[int]bool
+	slots        []SlotRecording
+	slotKeys     map[slotKey]bool
+	lastSequence int
+	revision     uint64
+}
+
+// FileStore is a SensorStore kept in a directory, one sub directory per sensor. Events and slot
+// recordings are appended to JSON lines files and everything is loaded into memory when opened.
+type FileStore struct {
+	dir     string
+	mu      sync.Mutex
+	sensors map[string]*storedSensor
+	cursors map[string]map[string]uint64
+}
+
+// OpenFileStore opens the store in `dir`, creating it if needed
+func OpenFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "[OpenFileStore] error")
+	}
+
+	fs := &FileStore{
+		dir:     dir,
+		sensors: map[string]*storedSensor{},
+		cursors: map[string]map[string]uint64{},
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "[OpenFileStore] error")
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		s, err := loadStoredSensor(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "[OpenFileStore] %s error", e.Name())
+		}
+		fs.sensors[e.Name()] = s
+	}
+
+	err = readJSONFile(filepath.Join(dir, cursorsFile), &fs.cursors)
+	if err != nil {
+		return nil, errors.Wrap(err, "[OpenFileStore] cursors error")
+	}
+	return fs, nil
+}
+
+func newStoredSensor(dir string) *storedSensor {
+	return &storedSensor{
+		dir:          dir,
+		sequences:    map[int]bool{},
+		slotKeys:     map[slotKey]bool{},
+		lastSequence: -1,
+	}
+}
+
+func loadStoredSensor(dir string) (*storedSensor, error) {
+	s := newStoredSensor(dir)
+	err := readJSONFile(filepath.Join(dir, metadataFile), &s.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// a line broken by an earlier failed append is skipped, the records after it are still good
+	err = readJSONLines(filepath.Join(dir, eventsFile), func(b []byte) error {
+		e := EventRecord{}
+		if json.Unmarshal(b, &e) == nil {
+			s.addEvent(e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readJSONLines(filepath.Join(dir, slotsFile), func(b []byte) error {
+		r := SlotRecording{}
+		if json.Unmarshal(b, &r) == nil {
+			s.addSlot(r)
+		}
+		return nil
+	})
+	return s, err
+}
+
+// readJSONFile unmarshals the file at `path` into `v`, leaving `v` alone if there is no file
+func readJSONFile(path string, v interface{}) error {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(d, v)
+}
+
+// writeJSONFile replaces the file at `path` with `v` by writing a temporary file and renaming it
+func writeJSONFile(path string, v interface{}) error {
+	return writeJSONFileMode(path, v, 0644)
+}
+
+func writeJSONFileMode(path string, v interface{}, perm os.FileMode) error {
+	d, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(d)
+	if err == nil {
+		// the data must be on disk before the rename can replace the old file with it
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory, so that a rename in it survives a crash
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// readJSONLines calls fn with each line of the file at `path`. A partial last line, left by
+// a crash part way through an append, is cut off so that the next append starts cleanly.
+func readJSONLines(path string, fn func([]byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	complete := int64(0)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 {
+				return os.Truncate(path, complete)
+			}
+			return nil
+		}
+		err = fn(line)
+		if err != nil {
+			return err
+		}
+		complete += int64(len(line))
+	}
+}
+
+// appendJSONLines adds a line to the file at `path` for each of `values`. If the append fails
+// the file is truncated back to its old size, so that no partial line is left for later lines
+// to follow.
+func appendJSONLines(path string, values []interface{}) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, v := range values {
+		var d []byte
+		d, err = json.Marshal(v)
+		if err != nil {
+			break
+		}
+		w.Write(d)
+		w.WriteByte('\n')
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(fi.Size())
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (s *storedSensor) addEvent(e EventRecord) {
+	s.events = append(s.events, e)
+	s.sequences[e.Sequence] = true
+	if e.Sequence > s.lastSequence {
+		s.lastSequence = e.Sequence
+	}
+	if e.Revision > s.revision {
+		s.revision = e.Revision
+	}
+}
+
+func (s *storedSensor) addSlot(r SlotRecording) {
+	s.slots = append(s.slots, r)
+	s.slotKeys[slotKey{r.Time, r.Slot}] = true
+	if r.Revision > s.revision {
+		s.revision = r.Revision
+	}
+}
+
+// sensor returns the sensor's record, creating it when `create` is set. Callers hold fs.mu.
+func (fs *FileStore) sensor(address string, create bool) (*storedSensor, error) {
+	key := addressKey(address)
+	s, ok := fs.sensors[key]
+	if ok || !create {
+		return s, nil
+	}
+	s = newStoredSensor(filepath.Join(fs.dir, key))
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	fs.sensors[key] = s
+	return s, nil
+}
+
+// UpdateMetadata records the replies from a collection
+func (fs *FileStore) UpdateMetadata(address string, version *VersionReply, info *InfoReply, config *SensorConfigRecord, at time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, err := fs.sensor(address, true)
+	if err != nil {
+		return errors.Wrap(err, "[UpdateMetadata] error")
+	}
+
+	m := SensorMetadata{Address: address}
+	if s.metadata != nil {
+		m = *s.metadata
+	}
+	if version != nil {
+		m.Version = version
+	}
+	if info != nil {
+		m.Info = info
+	}
+	if config != nil {
+		n := len(m.ConfigHistory)
+		if n == 0 || m.ConfigHistory[n-1].Config != config.Config {
+			m.ConfigHistory = append(m.ConfigHistory, *config)
+		}
+	}
+	m.LastCollected = at
+
+	err = writeJSONFile(filepath.Join(s.dir, metadataFile), &m)
+	if err != nil {
+		return errors.Wrap(err, "[UpdateMetadata] error")
+	}
+	s.metadata = &m
+	return nil
+}
+
+// AddClockOffsets appends measurements to the sensor's clock history
+func (fs *FileStore) AddClockOffsets(address string, offsets []ClockOffset) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, err := fs.sensor(address, true)
+	if err != nil {
+		return errors.Wrap(err, "[AddClockOffsets] error")
+	}
+
+	m := SensorMetadata{Address: address}
+	if s.metadata != nil {
+		m = *s.metadata
+	}
+	m.Clock = append(ClockHistory{}, m.Clock...)
+	m.Clock = append(m.Clock, offsets...)
+
+	err = writeJSONFile(filepath.Join(s.dir, metadataFile), &m)
+	if err != nil {
+		return errors.Wrap(err, "[AddClockOffsets] error")
+	}
+	s.metadata = &m
+	return nil
+}
+
+// Metadata returns the sensor's metadata, nil if the sensor is unknown
+func (fs *FileStore) Metadata(address string) (*SensorMetadata, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, _ := fs.sensor(address, false)
+	if s == nil || s.metadata == nil {
+		return nil, nil
+	}
+	m := *s.metadata
+	return &m, nil
+}
+
+// Sensors returns the address of every sensor in the store, sorted
+func (fs *FileStore) Sensors() ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	addresses := []string{}
+	for key := range fs.sensors {
+		addresses = append(addresses, key)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// AddEvents stores the records whose sequence numbers are new
+func (fs *FileStore) AddEvents(address string, events []EventRecord) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, err := fs.sensor(address, true)
+	if err != nil {
+		return 0, errors.Wrap(err, "[AddEvents] error")
+	}
+
+	added := []EventRecord{}
+	lines := []interface{}{}
+	seen := map[int]bool{}
+	revision := s.revision
+	for _, e := range events {
+		if s.sequences[e.Sequence] || seen[e.Sequence] {
+			continue
+		}
+		seen[e.Sequence] = true
+		revision++
+		e.Revision = revision
+		added = append(added, e)
+		lines = append(lines, e)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	err = appendJSONLines(filepath.Join(s.dir, eventsFile), lines)
+	if err != nil {
+		return 0, errors.Wrap(err, "[AddEvents] error")
+	}
+	for _, e := range added {
+		s.addEvent(e)
+	}
+	return len(added), nil
+}
+
+// AddSlots stores the recordings whose time and slot number are new
+func (fs *FileStore) AddSlots(address string, slots []SlotRecording) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, err := fs.sensor(address, true)
+	if err != nil {
+		return 0, errors.Wrap(err, "[AddSlots] error")
+	}
+
+	added := []SlotRecording{}
+	lines := []interface{}{}
+	seen := map[slotKey]bool{}
+	revision := s.revision
+	for _, r := range slots {
+		key := slotKey{r.Time, r.Slot}
+		if s.slotKeys[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		revision++
+		r.Revision = revision
+		added = append(added, r)
+		lines = append(lines, r)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	err = appendJSONLines(filepath.Join(s.dir, slotsFile), lines)
+	if err != nil {
+		return 0, errors.Wrap(err, "[AddSlots] error")
+	}
+	for _, r := range added {
+		s.addSlot(r)
+	}
+	return len(added), nil
+}
+
+// LastEventSequence returns the highest event sequence number stored for the sensor
+func (fs *FileStore) LastEventSequence(address string) (int, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, _ := fs.sensor(address, false)
+	if s == nil || len(s.events) == 0 {
+		return 0, false, nil
+	}
+	return s.lastSequence, true, nil
+}
+
+// HasSlot returns true if the recording is already stored
+func (fs *FileStore) HasSlot(address string, slotTime int, slot int) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s, _ := fs.sensor(address, false)
+	return s != nil && s.slotKeys[slotKey{slotTime, slot}], nil
+}
+
+// Changes returns the records with a revision after `since`
+func (fs *FileStore) Changes(address string, since uint64) (*SensorChanges, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	c := &SensorChanges{Revision: since}
+	s, _ := fs.sensor(address, false)
+	if s == nil {
+		return c, nil
+	}
+
+	for _, e := range s.events {
+		if e.Revision > since {
+			c.Events = append(c.Events, e)
+		}
+	}
+	for _, r := range s.slots {
+		if r.Revision > since {
+			c.Slots = append(c.Slots, r)
+		}
+	}
+	if s.revision > since {
+		c.Revision = s.revision
+	}
+	return c, nil
+}
+
+// SyncCursor returns the revision that `consumer` has synced the sensor up to
+func (fs *FileStore) SyncCursor(consumer string, address string) (uint64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.cursors[consumer][addressKey(address)], nil
+}
+
+// SetSyncCursor records that `consumer` has synced the sensor up to `revision`
+func (fs *FileStore) SetSyncCursor(consumer string, address string, revision uint64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if consumer == "" {
+		return fmt.Errorf("[SetSyncCursor] consumer name is empty")
+	}
+	if fs.cursors[consumer] == nil {
+		fs.cursors[consumer] = map[string]uint64{}
+	}
+	previous, existed := fs.cursors[consumer][addressKey(address)]
+	fs.cursors[consumer][addressKey(address)] = revision
+
+	err := writeJSONFile(filepath.Join(fs.dir, cursorsFile), fs.cursors)
+	if err != nil {
+		if existed {
+			fs.cursors[consumer][addressKey(address)] = previous
+		} else {
+			delete(fs.cursors[consumer], addressKey(address))
+		}
+		return errors.Wrap(err, "[SetSyncCursor] error")
+	}
+	return nil
+}
+
+// Close releases the store, every write has already been flushed
+func (fs *FileStore) Close() error {
+	return nil
+}
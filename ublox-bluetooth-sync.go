@@ -0,0 +1,329 @@
+package ubloxbluetooth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SyncClient is the part of UbloxBluetooth that SyncSensor uses
+type SyncClient interface {
+	ConnectToDevice(address string, onConnect DeviceEvent, onDisconnect DeviceEvent) error
+	DisconnectFromDevice() error
+	EnableNotifications() error
+	EnableIndications() error
+	UnlockDevice(password []byte) (bool, error)
+	GetInfo() (*InfoReply, error)
+	DownloadEventLog(startingIndex int, fn DownloadNotificationHandler) error
+	ReadSlotCount() (*SlotCountReply, error)
+	ReadSlotInfo(slotNumber int) (*SlotInfoReply, error)
+	DownloadSlot(sd *SlotDownload) error
+	EraseSlotData() error
+	ClearEventLog() error
+}
+
+// SyncSink takes the data that SyncSensor downloads. The sensor's copy may be erased as soon as
+// a call returns nil, so the data must be durable by then.
+type SyncSink interface {
+	SyncEvents(address string, events []EventRecord) error
+	SyncSlot(address string, slot SlotRecording) error
+}
+
+// StoreSink passes synced data to a SensorStore, a FileStore syncs each addition to disk
+type StoreSink struct {
+	Store SensorStore
+}
+
+// SyncEvents adds the events to the store
+func (s StoreSink) SyncEvents(address string, events []EventRecord) error {
+	_, err := s.Store.AddEvents(address, events)
+	return err
+}
+
+// SyncSlot adds the slot to the store
+func (s StoreSink) SyncSlot(address string, slot SlotRecording) error {
+	_, err := s.Store.AddSlots(address, []SlotRecording{slot})
+	return err
+}
+
+// SyncOptions configure SyncSensor
+type SyncOptions struct {
+	Password []byte
+	// Credentials supplies the password when Password is nil
+	Credentials CredentialProvider
+	// Sink is required
+	Sink SyncSink
+	// EventIndex is the first event log record to download
+	EventIndex int
+	SkipEvents bool
+	SkipSlots  bool
+	// EraseSlots erases the slots once every one of them is in the sink. The sensor only erases
+	// all of its slots at once, so they are kept if any failed.
+	EraseSlots bool
+	// ClearEvents clears the event log once all of it is in the sink
+	ClearEvents bool
+}
+
+// SlotSyncResult is the outcome for one slot
+type SlotSyncResult struct {
+	Slot   int
+	Info   *SlotInfoReply
+	Synced bool
+	Erased bool
+	Err    error
+}
+
+// SyncResult describes what SyncSensor did, including when it failed part way
+type SyncResult struct {
+	Address string
+	Info    *InfoReply
+	// SlotCount is the number of slots the sensor held, Slots has a result for each one attempted
+	SlotCount int
+	Slots     []SlotSyncResult
+	// SlotsErased is true when the slots were erased
+	SlotsErased bool
+	// Events is the number of event records in the sink, from EventIndex up to NextEventIndex
+	Events         int
+	NextEventIndex int
+	EventsSynced   bool
+	EventsCleared  bool
+}
+
+// SyncSensor connects to the sensor at `address`, unlocks it, and downloads its slots and event
+// log into opts.Sink. Only what the sink has accepted is erased, and only after everything has
+// been downloaded. A slot that fails is reported in the result and the sync carries on; the
+// returned error is the first failure.
+func SyncSensor(ctx context.Context, client SyncClient, address string, opts SyncOptions) (*SyncResult, error) {
+	r := &SyncResult{Address: address, NextEventIndex: opts.EventIndex}
+	if opts.Sink == nil {
+		return r, fmt.Errorf("[SyncSensor] Sink is required")
+	}
+	password, err := passwordFor(address, opts.Password, opts.Credentials)
+	if err != nil {
+		return r, errors.Wrap(err, "[SyncSensor] error")
+	}
+	if err = ctx.Err(); err != nil {
+		return r, errors.Wrap(err, "[SyncSensor] error")
+	}
+
+	var syncErr error
+	err = client.ConnectToDevice(address, func() error {
+		syncErr = syncConnected(ctx, client, password, opts, r)
+		return client.DisconnectFromDevice()
+	}, func() error {
+		return nil
+	})
+	if syncErr != nil {
+		return r, errors.Wrap(syncErr, "[SyncSensor] error")
+	}
+	if err != nil {
+		return r, errors.Wrap(err, "[SyncSensor] ConnectToDevice error")
+	}
+	return r, nil
+}
+
+// SyncSensor runs SyncSensor on this module
+func (ub *UbloxBluetooth) SyncSensor(ctx context.Context, address string, opts SyncOptions) (*SyncResult, error) {
+	return SyncSensor(ctx, ub, address, opts)
+}
+
+func syncConnected(ctx context.Context, c SyncClient, password []byte, opts SyncOptions, r *SyncResult) error {
+	err := c.EnableNotifications()
+	if err != nil {
+		return errors.Wrap(err, "EnableNotifications error")
+	}
+	err = c.EnableIndications()
+	if err != nil {
+		return errors.Wrap(err, "EnableIndications error")
+	}
+	unlocked, err := c.UnlockDevice(password)
+	if err != nil {
+		return errors.Wrap(err, "UnlockDevice error")
+	}
+	if !unlocked {
+		return fmt.Errorf("%s did not unlock", r.Address)
+	}
+	r.Info, err = c.GetInfo()
+	if err != nil {
+		return errors.Wrap(err, "GetInfo error")
+	}
+
+	// the first failure is returned, but a failed slot or event log does not stop the other
+	var firstErr error
+	if !opts.SkipSlots {
+		firstErr = syncSlots(ctx, c, opts, r)
+	}
+	if !opts.SkipEvents && ctx.Err() == nil {
+		err = syncEvents(c, opts, r, r.Info.CurrentSequenceNumber)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// nothing is erased once the sync has been cancelled
+	if err = ctx.Err(); err != nil {
+		if firstErr == nil {
+			firstErr = err
+		}
+		return firstErr
+	}
+	if opts.EraseSlots {
+		err = eraseSyncedSlots(c, r)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if opts.ClearEvents && r.EventsSynced {
+		err = clearSyncedEvents(c, opts, r)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// syncSlots downloads each slot into the sink. A slot that fails verification or is refused by
+// the sink is skipped, any other error ends the slots.
+func syncSlots(ctx context.Context, c SyncClient, opts SyncOptions, r *SyncResult) error {
+	count, err := c.ReadSlotCount()
+	if err != nil {
+		return errors.Wrap(err, "ReadSlotCount error")
+	}
+	r.SlotCount = count.Count
+
+	var firstErr error
+	for slot := 0; slot < count.Count; slot++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		sr := SlotSyncResult{Slot: slot}
+		sr.Info, sr.Err = c.ReadSlotInfo(slot)
+		if sr.Err != nil {
+			sr.Err = errors.Wrapf(sr.Err, "ReadSlotInfo %d error", slot)
+			r.Slots = append(r.Slots, sr)
+			return sr.Err
+		}
+
+		download := NewSlotDownload(slot, sr.Info)
+		err = c.DownloadSlot(download)
+		for resumes := 0; IsIncompleteDownload(err) != nil && resumes < maxSlotResumes; resumes++ {
+			err = c.DownloadSlot(download)
+		}
+		if err != nil {
+			sr.Err = errors.Wrapf(err, "DownloadSlot %d error", slot)
+			r.Slots = append(r.Slots, sr)
+			if !isSlotDataError(err) {
+				return sr.Err
+			}
+			if firstErr == nil {
+				firstErr = sr.Err
+			}
+			continue
+		}
+
+		err = opts.Sink.SyncSlot(r.Address, SlotRecording{Time: sr.Info.Time, Slot: slot, Info: *sr.Info, Data: download.Payload(), Received: time.Now()})
+		if err != nil {
+			sr.Err = errors.Wrapf(err, "SyncSlot %d error", slot)
+			if firstErr == nil {
+				firstErr = sr.Err
+			}
+		} else {
+			sr.Synced = true
+		}
+		r.Slots = append(r.Slots, sr)
+	}
+	return firstErr
+}
+
+// isSlotDataError is true for an error in a slot's data rather than the connection
+func isSlotDataError(err error) bool {
+	if incomplete := IsIncompleteDownload(err); incomplete != nil {
+		return incomplete.Err == nil
+	}
+	cause := errors.Cause(err)
+	return cause == ErrSlotSize
+}
+
+// syncEvents downloads the event log from r.NextEventIndex up to `last` into the sink
+func syncEvents(c SyncClient, opts SyncOptions, r *SyncResult, last int) error {
+	start := r.NextEventIndex
+	events := []EventRecord{}
+	err := c.DownloadEventLog(start, func(b []byte) error {
+		events = append(events, EventRecord{Sequence: eventSequence(start, len(events)), Data: append([]byte{}, b...), Received: time.Now()})
+		return nil
+	})
+	// records that did arrive are kept, but the log is only complete without an error
+	complete := err == nil
+	if err != nil {
+		err = errors.Wrap(err, "DownloadEventLog error")
+	}
+	if len(events) > 0 {
+		sinkErr := opts.Sink.SyncEvents(r.Address, events)
+		if sinkErr != nil {
+			r.EventsSynced = false
+			return errors.Wrap(sinkErr, "SyncEvents error")
+		}
+		r.Events += len(events)
+		r.NextEventIndex = eventSequence(start, len(events))
+	}
+	if complete {
+		r.EventsSynced = true
+		if r.NextEventIndex < last {
+			r.NextEventIndex = last
+		}
+	}
+	return err
+}
+
+// eraseSyncedSlots erases the slots when every one is in the sink and no more have been recorded
+// during the sync, otherwise it erases nothing
+func eraseSyncedSlots(c SyncClient, r *SyncResult) error {
+	if r.SlotCount == 0 || len(r.Slots) != r.SlotCount {
+		return nil
+	}
+	for _, sr := range r.Slots {
+		if !sr.Synced {
+			return nil
+		}
+	}
+
+	count, err := c.ReadSlotCount()
+	if err != nil {
+		return errors.Wrap(err, "ReadSlotCount before erase error")
+	}
+	if count.Count != r.SlotCount {
+		return nil
+	}
+
+	err = c.EraseSlotData()
+	if err != nil {
+		return errors.Wrap(err, "EraseSlotData error")
+	}
+	r.SlotsErased = true
+	for i := range r.Slots {
+		r.Slots[i].Erased = true
+	}
+	return nil
+}
+
+// clearSyncedEvents downloads any events logged during the sync, then clears the log
+func clearSyncedEvents(c SyncClient, opts SyncOptions, r *SyncResult) error {
+	info, err := c.GetInfo()
+	if err != nil {
+		return errors.Wrap(err, "GetInfo before clear error")
+	}
+	if info.CurrentSequenceNumber > r.NextEventIndex {
+		err = syncEvents(c, opts, r, info.CurrentSequenceNumber)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.ClearEventLog()
+	if err != nil {
+		return errors.Wrap(err, "ClearEventLog error")
+	}
+	r.EventsCleared = true
+	return nil
+}
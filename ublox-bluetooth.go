@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/RobHumphris/ublox-bluetooth/serial"
+	"github.com/pkg/errors"
 )
 
 // DataResponse holds the Token at the start of the reply, and the subsequent data bytes
@@ -42,6 +45,7 @@ const extendedDataMode ubloxMode = 2
 // UbloxBluetooth holds the serial port, and the communication channels.
 type UbloxBluetooth struct {
 	timeout            time.Duration
+	devicePath         string
 	lastCommand        string
 	serialPort         *serial.SerialPort
 	currentMode        ubloxMode
@@ -51,15 +55,50 @@ type UbloxBluetooth struct {
 	EDMChannel         chan []byte
 	ErrorChannel       chan error
 	CompletedChannel   chan bool
-	stopScanning       chan bool
+	scanErrors         chan error
+	readerStop         chan struct{}
+	readerDone         chan struct{}
+	readerRunning      bool
 	connectedDevice    *ConnectionReply
 	disconnectHandler  DeviceEvent
 	disconnectExpected bool
+	radioLock          sync.Mutex
+	radioFree          chan struct{}
+	radioHeld          bool
+	radioConnection    bool
+	radioWaiters       int
+	releaseConnection  func()
+	moduleInfoLock     sync.Mutex
+	moduleInfo         *ModuleInfo
+	moduleGeneration   int
+	expectingText      int32
+	gone               int32
+	detached           chan struct{}
+	detachLock         sync.Mutex
+	closed             bool
+	attachment         sync.RWMutex
+	hotPlugLock        sync.Mutex
+	hotPlug            *hotPlug
+	securityLock       sync.Mutex
+	pairingHandler     *PairingHandler
+	replyLock          sync.Mutex
+	pendingReplies     []pendingReply
+	replySequence      uint64
+	linkLock           sync.Mutex
+	phyUpdates         chan *PHYUpdate
+	flowControl        FlowControlOptions
+	opcodes            ExtendedOpcodes
 }
 
-// NewUbloxBluetooth creates a new UbloxBluetooth instance
+// NewUbloxBluetooth creates a new UbloxBluetooth instance on the first u-blox device found
 func NewUbloxBluetooth(timeout time.Duration) (*UbloxBluetooth, error) {
-	sp, err := serial.OpenSerialPort(timeout)
+	return NewUbloxBluetoothOnDevice("", timeout)
+}
+
+// NewUbloxBluetoothOnDevice creates a new UbloxBluetooth instance on the serial device at
+// `devicePath`, see serial.FindDevice for choosing between several dongles.
+func NewUbloxBluetoothOnDevice(devicePath string, timeout time.Duration) (*UbloxBluetooth, error) {
+	sp, err := serial.OpenSerialPortPath(devicePath, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -72,6 +111,7 @@ func NewUbloxBluetooth(timeout time.Duration) (*UbloxBluetooth, error) {
 
 	ub := &UbloxBluetooth{
 		timeout:            timeout,
+		devicePath:         devicePath,
 		lastCommand:        "",
 		serialPort:         sp,
 		currentMode:        extendedDataMode,
@@ -81,19 +121,41 @@ func NewUbloxBluetooth(timeout time.Duration) (*UbloxBluetooth, error) {
 		EDMChannel:         make(chan []byte),
 		ErrorChannel:       make(chan error),
 		CompletedChannel:   make(chan bool),
-		stopScanning:       make(chan bool),
+		scanErrors:         make(chan error),
+		phyUpdates:         make(chan *PHYUpdate, 1),
+		detached:           make(chan struct{}),
 		connectedDevice:    nil,
 	}
 
 	sp.SetEDMFlag(true)
 
-	go ub.serialportReader()
+	ub.startReader()
 
 	return ub, err
 }
 
+func (ub *UbloxBluetooth) startReader() {
+	ub.resetReplies()
+	ub.readerStop = make(chan struct{})
+	ub.readerDone = make(chan struct{})
+	ub.readerRunning = true
+	go ub.serialportReader()
+}
+
+// stopReader stops the serial port scanner and the reader, waiting for the reader to finish.
+func (ub *UbloxBluetooth) stopReader() {
+	if !ub.readerRunning {
+		return
+	}
+	ub.readerRunning = false
+	close(ub.readerStop)
+	ub.serialPort.StopScanning()
+	<-ub.readerDone
+}
+
 func (ub *UbloxBluetooth) serialportReader() {
-	go ub.serialPort.ScanPort(ub.readChannel, ub.EDMChannel, ub.ErrorChannel)
+	defer close(ub.readerDone)
+	go ub.serialPort.ScanPort(ub.readChannel, ub.EDMChannel, ub.scanErrors)
 
 	for {
 		select {
@@ -104,7 +166,7 @@ func (ub *UbloxBluetooth) serialportReader() {
 				case 'A':
 					ub.processATResponse(b)
 				case '+':
-					ub.DataChannel <- b
+					ub.sendData(b)
 				default:
 					ub.handleGeneralMessage(b)
 				}
@@ -113,23 +175,52 @@ func (ub *UbloxBluetooth) serialportReader() {
 			if len(edmData) > 0 {
 				err := ub.ParseEDMMessage(edmData)
 				if err != nil {
-					ub.ErrorChannel <- err
+					ub.sendError(err)
 				}
 			}
-		case _ = <-ub.stopScanning:
-			fmt.Println("StopScanning received")
-			ub.serialPort.StopScanning()
+		case err := <-ub.scanErrors:
+			if serial.IsDeviceGone(err) {
+				ub.deviceGone(err)
+				return
+			}
+			ub.sendError(err)
+		case <-ub.readerStop:
 			return
 		}
 	}
 }
 
+// sendData, sendError and sendCompleted pass messages from the reader to the waiting command,
+// giving up if the reader is stopped so that it is never left blocked.
+func (ub *UbloxBluetooth) sendData(b []byte) {
+	select {
+	case ub.DataChannel <- b:
+	case <-ub.readerStop:
+	}
+}
+
+func (ub *UbloxBluetooth) sendError(err error) {
+	select {
+	case ub.ErrorChannel <- err:
+	case <-ub.readerStop:
+	}
+}
+
+func (ub *UbloxBluetooth) sendCompleted() {
+	select {
+	case ub.CompletedChannel <- true:
+	case <-ub.readerStop:
+	}
+}
+
 // ResetSerial stops reading threads and
 func (ub *UbloxBluetooth) ResetSerial() error {
-	ub.stopScanning <- true
+	ub.attachment.Lock()
+	defer ub.attachment.Unlock()
+	ub.stopReader()
 	ub.serialPort.Close()
 
-	sp, err := serial.OpenSerialPort(ub.timeout)
+	sp, err := serial.OpenSerialPortPath(ub.devicePath, ub.timeout)
 	if err != nil {
 		return err
 	}
@@ -147,7 +238,7 @@ func (ub *UbloxBluetooth) ResetSerial() error {
 	}
 
 	ub.serialPort = sp
-	go ub.serialportReader()
+	ub.startReader()
 
 	return nil
 }
@@ -155,6 +246,13 @@ func (ub *UbloxBluetooth) ResetSerial() error {
 // Close shuts down the serial port, can closes communication channels.
 func (ub *UbloxBluetooth) Close() {
 	fmt.Println("### Closing Serial Port")
+	ub.attachment.Lock()
+	ub.closed = true
+	hp := ub.getHotPlug()
+	if hp != nil {
+		close(hp.stop)
+	}
+	ub.stopReader()
 	err := ub.serialPort.Close()
 	if err != nil {
 		fmt.Printf("[Close] error %v\n", err)
@@ -165,11 +263,121 @@ func (ub *UbloxBluetooth) Close() {
 	close(ub.EDMChannel)
 	close(ub.CompletedChannel)
 	close(ub.ErrorChannel)
+	close(ub.scanErrors)
+	ub.attachment.Unlock()
+
+	if hp != nil {
+		hp.wg.Wait()
+	}
+}
+
+// ErrRadioBusy is returned by a discovery started while a connection holds the radio, which it
+// would otherwise wait for until the device disconnects, and when the wait for the radio is too long
+var ErrRadioBusy = fmt.Errorf("radio is held by a connection")
+
+// acquireRadio waits for exclusive use of the radio, discovery and connections cannot
+// run at the same time. The returned function releases the radio and may be called more than once.
+func (ub *UbloxBluetooth) acquireRadio() (func(), error) {
+	return ub.takeRadio(false, true)
+}
+
+// acquireConnectionRadio waits for the radio for a connection, which holds it until the device
+// disconnects
+func (ub *UbloxBluetooth) acquireConnectionRadio() (func(), error) {
+	return ub.takeRadio(true, true)
+}
+
+// acquireDiscoveryRadio waits for the radio, unless a connection holds it
+func (ub *UbloxBluetooth) acquireDiscoveryRadio() (func(), error) {
+	return ub.takeRadio(false, false)
+}
+
+// radioWait is the longest wait for the radio from `start`: the timeout while a connection holds
+// it, so that a missed disconnect cannot block everything for good, otherwise long enough for the
+// longest scan to complete. Callers hold radioLock.
+func (ub *UbloxBluetooth) radioWait(start time.Time) time.Duration {
+	wait := maxScanDuration + ub.timeout
+	if ub.radioConnection {
+		wait = ub.timeout
+	}
+	return time.Until(start.Add(wait))
+}
+
+func (ub *UbloxBluetooth) takeRadio(connection bool, waitForConnection bool) (func(), error) {
+	start := time.Now()
+	ub.radioLock.Lock()
+	ub.radioWaiters++
+	for ub.radioHeld {
+		if ub.radioConnection && !waitForConnection {
+			ub.radioWaiters--
+			ub.radioLock.Unlock()
+			return nil, ErrRadioBusy
+		}
+		if ub.radioFree == nil {
+			ub.radioFree = make(chan struct{})
+		}
+		free := ub.radioFree
+		wait := ub.radioWait(start)
+		ub.radioLock.Unlock()
+
+		var err error
+		select {
+		case <-free:
+		case <-ub.detachment():
+			err = errors.Wrap(ErrDeviceGone, "[takeRadio] error")
+		case <-time.After(wait):
+			err = errors.Wrapf(ErrRadioBusy, "[takeRadio] waited %v", time.Since(start))
+		}
+
+		ub.radioLock.Lock()
+		if err != nil {
+			ub.radioWaiters--
+			ub.radioLock.Unlock()
+			return nil, err
+		}
+	}
+	ub.radioWaiters--
+	ub.radioHeld = true
+	ub.radioConnection = connection
+	ub.radioLock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(ub.releaseRadio)
+	}, nil
+}
+
+func (ub *UbloxBluetooth) releaseRadio() {
+	ub.radioLock.Lock()
+	defer ub.radioLock.Unlock()
+	ub.radioHeld = false
+	ub.radioConnection = false
+	if ub.radioFree != nil {
+		close(ub.radioFree)
+		ub.radioFree = nil
+	}
+}
+
+// IsIdle returns true when no device is connected and nothing is waiting to use the radio.
+func (ub *UbloxBluetooth) IsIdle() bool {
+	ub.radioLock.Lock()
+	defer ub.radioLock.Unlock()
+	return !ub.radioConnection && ub.radioWaiters == 0
 }
 
 // SetCommsRate sets the rate to either: Default BaudRate, or HighSpeed
 func (ub *UbloxBluetooth) SetCommsRate(rate serial.BaudRate) error {
-	return ub.serialPort.SetBaudRate(rate, ub.timeout)
+	return ub.port().SetBaudRate(rate, ub.timeout)
+}
+
+// DevicePath returns the path of the serial device that the module is attached to
+func (ub *UbloxBluetooth) DevicePath() string {
+	return ub.port().Path()
+}
+
+// EDMStats returns the counts of EDM frames decoded and dropped since the serial port was opened
+func (ub *UbloxBluetooth) EDMStats() serial.EDMStats {
+	return ub.port().EDMStats()
 }
 
 // SetSerialVerbose sets the debug flag
@@ -179,24 +387,113 @@ func (ub *UbloxBluetooth) SetSerialVerbose(f bool) {
 
 // Write writes the data string to Ublox via the SerialPort
 func (ub *UbloxBluetooth) Write(data string) error {
+	_, err := ub.write(data)
+	return err
+}
+
+// write is Write returning the command's place in the reply queue, for dropReply
+func (ub *UbloxBluetooth) write(data string) (uint64, error) {
 	var b []byte
 	ub.lastCommand = data
 
-	if ub.currentMode == extendedDataMode {
+	if ub.mode() == extendedDataMode {
 		b = NewEDMATCommand(data)
 	} else {
 		b = []byte(append([]byte(data), tail...))
 	}
-	return ub.WriteBytes(b)
+	return ub.writeCommand(b, false)
+}
+
+// pendingReply is a command waiting for its OK or ERROR
+type pendingReply struct {
+	id     uint64
+	answer bool
+}
+
+// writeCommand writes a command and queues its OK or ERROR, `answer` marks the answers to
+// security requests whose results are not passed on. The module replies in order so the
+// queue is held through the write. The returned id identifies the command to dropReply.
+func (ub *UbloxBluetooth) writeCommand(b []byte, answer bool) (uint64, error) {
+	ub.replyLock.Lock()
+	defer ub.replyLock.Unlock()
+	err := ub.WriteBytes(b)
+	if err != nil {
+		return 0, err
+	}
+	ub.replySequence++
+	ub.pendingReplies = append(ub.pendingReplies, pendingReply{id: ub.replySequence, answer: answer})
+	return ub.replySequence, nil
+}
+
+// takeReply removes the oldest command from the queue, returning true if it was an answer
+// to a security request
+func (ub *UbloxBluetooth) takeReply() bool {
+	ub.replyLock.Lock()
+	defer ub.replyLock.Unlock()
+	if len(ub.pendingReplies) == 0 {
+		return false
+	}
+	answer := ub.pendingReplies[0].answer
+	ub.pendingReplies = ub.pendingReplies[1:]
+	return answer
+}
+
+// dropReply removes a command that failed before its OK or ERROR arrived, most likely by timing
+// out, so that the replies to later commands are not matched to it. Its reply, should it come
+// after all, is taken as that of the next command, as it would be without the queue.
+func (ub *UbloxBluetooth) dropReply(id uint64) {
+	ub.replyLock.Lock()
+	defer ub.replyLock.Unlock()
+	for i, r := range ub.pendingReplies {
+		if r.id == id {
+			ub.pendingReplies = append(ub.pendingReplies[:i], ub.pendingReplies[i+1:]...)
+			return
+		}
+	}
+}
+
+func (ub *UbloxBluetooth) resetReplies() {
+	ub.replyLock.Lock()
+	defer ub.replyLock.Unlock()
+	ub.pendingReplies = nil
 }
 
 // WriteBytes writes the passed bytes
 func (ub *UbloxBluetooth) WriteBytes(b []byte) error {
+	ub.attachment.RLock()
+	defer ub.attachment.RUnlock()
+	if !ub.IsAttached() {
+		return errors.Wrap(ErrDeviceGone, "[WriteBytes] error")
+	}
 	return ub.serialPort.Write(b)
 }
 
+// port returns the serial port, which is replaced when the dongle is reattached
+func (ub *UbloxBluetooth) port() *serial.SerialPort {
+	ub.attachment.RLock()
+	defer ub.attachment.RUnlock()
+	return ub.serialPort
+}
+
+func (ub *UbloxBluetooth) mode() ubloxMode {
+	ub.attachment.RLock()
+	defer ub.attachment.RUnlock()
+	return ub.currentMode
+}
+
+func (ub *UbloxBluetooth) setMode(m ubloxMode, edm bool) {
+	ub.attachment.Lock()
+	defer ub.attachment.Unlock()
+	ub.currentMode = m
+	ub.serialPort.SetEDMFlag(edm)
+}
+
 // WaitForResponse waits until timeout for a response from the Ublox device
 func (ub *UbloxBluetooth) WaitForResponse(expectedResponse string, waitForData bool) ([]byte, error) {
+	return ub.waitForResponse(expectedResponse, waitForData, ub.timeout)
+}
+
+func (ub *UbloxBluetooth) waitForResponse(expectedResponse string, waitForData bool, timeout time.Duration) ([]byte, error) {
 	expected := []byte(expectedResponse)
 	d := []byte{}
 	complete := false
@@ -227,7 +524,9 @@ func (ub *UbloxBluetooth) WaitForResponse(expectedResponse string, waitForData b
 			}
 		case e := <-ub.ErrorChannel:
 			return nil, e
-		case <-time.After(ub.timeout):
+		case <-ub.detachment():
+			return nil, errors.Wrap(ErrDeviceGone, "[WaitForResponse] error")
+		case <-time.After(timeout):
 			return nil, fmt.Errorf("Timeout")
 		}
 	}
@@ -235,7 +534,7 @@ func (ub *UbloxBluetooth) WaitForResponse(expectedResponse string, waitForData b
 
 func handleUnsolicitedMessage(data []byte) error {
 	if bytes.HasPrefix(data, ubloxBTReponseHeader) {
-		// Todo - handle the likes of +UUBTLEPHYU:0,0,2,2
+		// other +UUBT events, e.g. a bond completing, are only of interest to the command waiting for them
 	} else {
 		if bytes.HasPrefix(data, rebootResponse) {
 			return fmt.Errorf("Error device has rebooted")
@@ -254,8 +553,9 @@ var notificationSeperator = []byte("16,")
 var indicationSeperator = []byte("13,")
 
 // HandleDataDownload enables data download (Events and Slots). Passed variables are:
-// `expected` number of notifications. This handles the credit based flow mechanism and does
-// not return until the expected number of notifications and terminating indication are received.
+// `expected` number of notifications. This handles the credit based flow mechanism, see
+// FlowController, and does not return until the expected number of notifications and
+// terminating indication are received.
 //
 // `commandReply` the Veh command (0x07 or 0x10)
 //
@@ -263,43 +563,133 @@ var indicationSeperator = []byte("13,")
 //
 // `dih` Indication handler function, which is invoked each time an indication is received.
 func (ub *UbloxBluetooth) HandleDataDownload(expected int, commandReply string, dnh DownloadNotificationHandler, dih func([]byte) error) error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+
+	dl := &creditedDownload{ub: ub, flow: NewFlowController(ub.flowControl, expected, time.Now())}
+	if ub.flowControl.Report != nil {
+		defer func() {
+			ub.flowControl.Report(dl.flow.Stats(time.Now()))
+		}()
+	}
+
+	err := dl.receive(expected, dnh, dih)
+	if err != nil {
+		ub.drainCredits(len(dl.pending))
+	}
+	return err
+}
+
+// creditedDownload tracks the credit writes of a download, which are not waited for so that no
+// notification is missed, their OKs are picked up by receive
+type creditedDownload struct {
+	ub      *UbloxBluetooth
+	handle  int
+	flow    *FlowController
+	pending []int
+}
+
+func (dl *creditedDownload) sendCredits(credit int) error {
+	if credit <= 0 {
+		return nil
+	}
+	cmd := WriteCharacteristicHexCommand(dl.handle, commandValueHandle, creditCommand, uint8ToString(uint8(credit)))
+	err := dl.ub.Write(cmd.Cmd)
+	if err != nil {
+		dl.flow.CreditFailed(credit)
+		return err
+	}
+	dl.pending = append(dl.pending, credit)
+	return nil
+}
+
+// receive handles the notifications and indication of the download
+func (dl *creditedDownload) receive(expected int, dnh DownloadNotificationHandler, dih func([]byte) error) error {
+	ub := dl.ub
 	var err error
-	received := 0
-	dataComplete := false
+	dataComplete := expected == 0
 	indicationRecieved := false
+	lastActivity := time.Now()
 	for {
+		if dataComplete && indicationRecieved && len(dl.pending) == 0 {
+			return nil
+		}
+		wait := dl.flow.StallTimeout()
+		if dataComplete || wait > ub.timeout {
+			wait = ub.timeout
+		}
+
 		select {
 		case data := <-ub.DataChannel:
+			lastActivity = time.Now()
 			if bytes.HasPrefix(data, gattNotificationResponse) {
-				err = dnh(getPayload(data, notificationSeperator))
+				payload := getPayload(data, notificationSeperator)
+				err = dnh(payload)
 				if err != nil {
 					return err
 				}
-				received++
-				if received%halfwayPoint == 0 {
-					err = ub.SendCredits(halfwayPoint)
-					if err != nil {
-						return err
-					}
-				}
-				dataComplete = (received == expected)
-				if dataComplete && indicationRecieved {
-					return nil
+				err = dl.sendCredits(dl.flow.Received(len(payload), lastActivity))
+				if err != nil {
+					return err
 				}
+				dataComplete = dl.flow.Stats(lastActivity).Complete
 			} else if bytes.HasPrefix(data, gattIndicationResponse) {
 				err = dih(getPayload(data, indicationSeperator))
 				if err != nil {
 					return err
 				}
 				indicationRecieved = true
-				if dataComplete && indicationRecieved {
-					return nil
-				}
 			} else {
 				return fmt.Errorf("unexpected: %s", data)
 			}
+		case <-ub.CompletedChannel:
+			if len(dl.pending) > 0 {
+				dl.pending = dl.pending[1:]
+			}
+		case e := <-ub.ErrorChannel:
+			if len(dl.pending) == 0 {
+				return e
+			}
+			dl.flow.CreditFailed(dl.pending[0])
+			dl.pending = dl.pending[1:]
+		case <-ub.detachment():
+			return errors.Wrap(ErrDeviceGone, "[receive] error")
+		case now := <-time.After(wait):
+			if dataComplete || now.Sub(lastActivity) >= ub.timeout {
+				return fmt.Errorf("Timeout")
+			}
+			if indicationRecieved {
+				s := dl.flow.Stats(now)
+				return fmt.Errorf("download ended with %d of %d notifications", s.Notifications, s.Expected)
+			}
+			credit, err := dl.flow.Stalled(now)
+			if err != nil {
+				return err
+			}
+			err = dl.sendCredits(credit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// drainCredits waits for the replies to `n` credit writes, so they are not taken as the reply to
+// the next command, dropping any notifications still arriving
+func (ub *UbloxBluetooth) drainCredits(n int) {
+	for n > 0 {
+		select {
+		case <-ub.DataChannel:
+		case <-ub.CompletedChannel:
+			n--
+		case <-ub.ErrorChannel:
+			n--
+		case <-ub.detachment():
+			return
 		case <-time.After(ub.timeout):
-			return fmt.Errorf("Timeout")
+			return
 		}
 	}
 }
@@ -316,6 +706,8 @@ func (ub *UbloxBluetooth) WaitOnDataChannel(fn DataMessageHandler) error {
 			}
 		case e := <-ub.ErrorChannel:
 			return e
+		case <-ub.detachment():
+			return errors.Wrap(ErrDeviceGone, "[WaitOnDataChannel] error")
 		case <-time.After(ub.timeout):
 			return fmt.Errorf("Timeout")
 		}
@@ -340,6 +732,8 @@ func (ub *UbloxBluetooth) HandleDiscovery(expectedResponse string, fn Discoveryh
 			return err
 		case e := <-ub.ErrorChannel:
 			return e
+		case <-ub.detachment():
+			return errors.Wrap(ErrDeviceGone, "[HandleDiscovery] error")
 		case <-time.After(ub.timeout):
 			return fmt.Errorf("Timeout")
 		}
@@ -362,15 +756,25 @@ func (ub *UbloxBluetooth) processATResponse(b []byte) {
 func (ub *UbloxBluetooth) handleGeneralMessage(b []byte) {
 	str := string(b[:])
 	switch str {
-	case okMessage:
-		ub.CompletedChannel <- true
-	case errorMessage:
-		ub.ErrorChannel <- fmt.Errorf(str)
+	case okMessage, errorMessage:
+		if ub.takeReply() {
+			return
+		}
+		if str == okMessage {
+			ub.sendCompleted()
+			return
+		}
+		ub.sendError(fmt.Errorf(str))
 	default:
-		ub.ErrorChannel <- fmt.Errorf("Cannot handle message %q", str)
+		// information text responses, e.g. to +CGMI, have no prefix
+		if atomic.LoadInt32(&ub.expectingText) != 0 {
+			ub.sendData(b)
+			return
+		}
+		ub.sendError(fmt.Errorf("Cannot handle message %q", str))
 	}
 }
 
 func (ub *UbloxBluetooth) handleUnknownPayload(t string, p string) {
-	ub.ErrorChannel <- fmt.Errorf("Unknown token %s payload %s", t, p)
+	ub.sendError(fmt.Errorf("Unknown token %s payload %s", t, p))
 }
@@ -13,6 +13,7 @@ const rs232SettingsResponseString = "+UMRS:"
 var rs232SettingsResponse = []byte(rs232SettingsResponseString)
 
 const echoOff = "ATE0"
+const echoOn = "ATE1"
 const storeConfig = "AT&W"
 
 const powerOff = "+CPWROFF"
@@ -20,10 +21,20 @@ const rebootResponseString = "+STARTUP"
 
 var rebootResponse = []byte(rebootResponseString)
 
+const manufacturerIdentification = "+CGMI"
+const modelIdentification = "+CGMM"
+const firmwareVersion = "+CGMR"
+const firmwareVersionAlternative = "+GMR"
+const serialNumber = "+CGSN"
+const localAddress = "+UMLA"
+const localAddressResponseString = "+UMLA:"
+const bluetoothInterface = 1
+
 const factoryReset = "+UFACTORY"
 const moduleStartMode = "+UMSM"
 const moduleStartModeResponseString = "+UMSM:"
 const watchdogSettings = "+UDWS"
+const watchdogSettingsResponseString = "+UDWS:"
 const getRSSI = "+UBTRSS"
 const getRSSIResponseString = "+UBTRSS:"
 
@@ -38,14 +49,68 @@ const discoveryResponseString = "+UBTD:"
 var discoveryResponse = []byte(discoveryResponseString)
 
 const bleRole = "+UBTLE"
+const bleRoleResponseString = "+UBTLE:"
 const bleDisabled = 0
 const bleCentral = 1
 const blePeripheral = 2
 const bleSimultaneous = 3
 
 const bleConfiguration = "+UBTLECFG"
+const bleConfigurationResponseString = "+UBTLECFG:"
+const minAdvertisingInterval = 1
+const maxAdvertisingInterval = 2
+const advertisingChannelMap = 3
 const minConnectionInterval = 4
 const maxConnectionInterval = 5
+const connectionLatency = 6
+const linkLossTimeout = 7
+
+const bondedDevices = "+UBTBD"
+const bondedDevicesResponseString = "+UBTBD:"
+const bond = "+UBTB"
+const bondResponseString = "+UUBTB:"
+const unbond = "+UBTUB"
+const unbondAllAddress = "FFFFFFFFFFFF"
+
+const securityMode = "+UBTSM"
+const securityModeResponseString = "+UBTSM:"
+const pairingMode = "+UBTPM"
+const pairingModeResponseString = "+UBTPM:"
+const userPasskeyEntry = "+UBTUPE"
+const userPasskeyEntryResponseString = "+UUBTUPE:"
+const userConfirmation = "+UBTUC"
+const userConfirmationResponseString = "+UUBTUC:"
+const userPasskeyDisplayResponseString = "+UUBTUPD:"
+
+const blePHYRequest = "+UBTLEPHYR"
+const blePHYRequestResponseString = "+UBTLEPHYR:"
+const blePHYUpdateResponseString = "+UUBTLEPHYU:"
+const connectionParametersUpdate = "+UBTCPU"
+const connectionStatus = "+UBTCST"
+const connectionStatusResponseString = "+UBTCST:"
+
+// +UBTCST properties
+const (
+	statusConnectionInterval = 0
+	statusPeripheralLatency  = 1
+	statusSupervisionTimeout = 2
+	statusMTU                = 3
+	statusTxPHY              = 9
+	statusRxPHY              = 10
+)
+
+var bondResponse = []byte(bondResponseString)
+var userPasskeyEntryResponse = []byte(userPasskeyEntryResponseString)
+var userConfirmationResponse = []byte(userConfirmationResponseString)
+var userPasskeyDisplayResponse = []byte(userPasskeyDisplayResponseString)
+
+const localName = "+UBTLN"
+const localNameResponseString = "+UBTLN:"
+
+const advertisingData = "+UBTAD"
+const scanResponseData = "+UBTSD"
+const discoverabilityMode = "+UBTDM"
+const connectabilityMode = "+UBTCM"
 
 const connect = "+UBTACLC"
 const connectResponse = "+UUBTACLC:"
@@ -91,7 +156,7 @@ const gattIndicationResponseString = "+UUBTGI:"
 var ubloxBTReponseHeader = []byte("+UUBT")
 var gattIndicationResponse = []byte(gattIndicationResponseString)
 var gattNotificationResponse = []byte("+UUBTGN:")
-var blePHYUpdateResponse = []byte("+UUBTLEPHYU:")
+var blePHYUpdateResponse = []byte(blePHYUpdateResponseString)
 var peerConnectedResponse = []byte(peerConnectedResponseString)
 var aclConnectionRemoteDeviceResponse = []byte(aclConnectionRemoteDeviceResponseString)
 
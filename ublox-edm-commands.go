@@ -66,18 +66,28 @@ func (ub *UbloxBluetooth) ParseEDMMessage(msg []byte) error {
 	case StartEvent:
 		ub.StartEventReceived = true
 	case ATConfirmation:
+		if len(data) == 0 {
+			// blank lines between replies are ignored
+			return nil
+		}
 		switch data[0] {
 		case '+':
-			ub.DataChannel <- data
+			ub.sendData(data)
 		default:
 			ub.handleGeneralMessage(data)
 		}
 	case ATEvent:
 		// we check for disconnect events disconnectResponse
-		if bytes.HasPrefix(data, disconnectResponse) && !ub.disconnectExpected {
+		if bytes.HasPrefix(data, disconnectResponse) && !ub.isDisconnectExpected() {
 			ub.handleUnexpectedDisconnection()
 		}
-		ub.DataChannel <- data
+		if bytes.HasPrefix(data, blePHYUpdateResponse) {
+			return ub.handlePHYUpdate(data)
+		}
+		if ub.handleSecurityEvent(data) {
+			return nil
+		}
+		ub.sendData(data)
 	}
 	return nil
 }
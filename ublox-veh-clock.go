@@ -0,0 +1,116 @@
+package ubloxbluetooth
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ClockOffset is a measurement of a sensor's clock against the host's
+type ClockOffset struct {
+	// MeasuredAt is the host time of the measurement
+	MeasuredAt time.Time
+	// SensorTime is the sensor's clock, in seconds since the Unix epoch, at MeasuredAt
+	SensorTime int
+	// Offset is the sensor's clock minus the host's clock
+	Offset time.Duration
+	// RoundTrip is the time taken by the command that read the sensor's clock
+	RoundTrip time.Duration
+	// Set is true when the sensor's clock was set to the host's at MeasuredAt
+	Set bool
+}
+
+// NewClockOffset returns the offset of `sensorTime`, read by a command sent at `sent` and answered
+// at `received`. The sensor is taken to have read its clock half way through the round trip.
+func NewClockOffset(sensorTime int, sent time.Time, received time.Time) ClockOffset {
+	roundTrip := received.Sub(sent)
+	measuredAt := sent.Add(roundTrip / 2)
+	return ClockOffset{
+		MeasuredAt: measuredAt,
+		SensorTime: sensorTime,
+		Offset:     time.Unix(int64(sensorTime), 0).Sub(measuredAt),
+		RoundTrip:  roundTrip,
+	}
+}
+
+// Correct converts a sensor time to host time using this offset alone
+func (c ClockOffset) Correct(sensorTime int) time.Time {
+	return time.Unix(int64(sensorTime), 0).Add(-c.Offset)
+}
+
+// ClockHistory is a sensor's clock measurements in the order they were made
+type ClockHistory []ClockOffset
+
+// Offset returns the sensor clock's offset at `sensorTime`, interpolated between the measurements
+// either side of it so that drift is spread over the time between them. Measurements are not
+// interpolated across a Set. When a Set moved the clock back, sensor times it repeats are taken
+// to be from after the Set.
+func (h ClockHistory) Offset(sensorTime int) time.Duration {
+	start := 0
+	for i, c := range h {
+		if c.Set && c.SensorTime <= sensorTime {
+			start = i
+		}
+	}
+	end := len(h)
+	for i := start + 1; i < len(h); i++ {
+		if h[i].Set {
+			end = i
+			break
+		}
+	}
+	span := h[start:end]
+	if len(span) == 0 {
+		return 0
+	}
+
+	if sensorTime <= span[0].SensorTime {
+		return span[0].Offset
+	}
+	for i := 1; i < len(span); i++ {
+		a, b := span[i-1], span[i]
+		if sensorTime > b.SensorTime {
+			continue
+		}
+		if b.SensorTime == a.SensorTime {
+			return b.Offset
+		}
+		fraction := float64(sensorTime-a.SensorTime) / float64(b.SensorTime-a.SensorTime)
+		return a.Offset + time.Duration(fraction*float64(b.Offset-a.Offset))
+	}
+	return span[len(span)-1].Offset
+}
+
+// Correct converts a sensor time, such as an event or SlotInfoReply time, to host time
+func (h ClockHistory) Correct(sensorTime int) time.Time {
+	return time.Unix(int64(sensorTime), 0).Add(-h.Offset(sensorTime))
+}
+
+// MeasureClock reads the connected device's info and measures its clock offset from the host's
+func (ub *UbloxBluetooth) MeasureClock() (*InfoReply, *ClockOffset, error) {
+	sent := time.Now()
+	info, err := ub.GetInfo()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "[MeasureClock] GetInfo error")
+	}
+	c := NewClockOffset(info.CurrentTime, sent, time.Now())
+	return info, &c, nil
+}
+
+// SyncClock sets the connected device's clock to the host's, returning the ClockOffset to record
+func (ub *UbloxBluetooth) SyncClock() (*ClockOffset, error) {
+	now := time.Now()
+	err := ub.SetTime(now)
+	if err != nil {
+		return nil, errors.Wrap(err, "[SyncClock] SetTime error")
+	}
+	c := clockSetAt(now)
+	return &c, nil
+}
+
+// clockSetAt is the ClockOffset recorded for setting a sensor's clock to `now`, the sensor
+// keeps whole seconds so is left behind by the fraction
+func clockSetAt(now time.Time) ClockOffset {
+	seconds := now.Unix()
+	return ClockOffset{MeasuredAt: now, SensorTime: int(seconds), Offset: time.Unix(seconds, 0).Sub(now), Set: true}
+}
@@ -3,6 +3,7 @@ package ubloxbluetooth
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -26,13 +27,40 @@ var (
 	rebootCommand        = []byte{0x13}
 )
 
+// ErrUnknownOpcode is returned by a sensor command whose opcode has not been given in ExtendedOpcodes
+var ErrUnknownOpcode = fmt.Errorf("sensor command opcode is not known")
+
+// ExtendedOpcodes are the opcodes of the sensor commands missing from the table above. They are
+// assigned by the sensor's firmware specification, each reply carries its opcode and a status.
+// The DFU replies are read with the image offset as a little-endian uint32 after the status, the
+// specification must agree before the DFU opcodes are set.
+type ExtendedOpcodes struct {
+	SetTime   []byte
+	DFUStart  []byte
+	DFUData   []byte
+	DFUVerify []byte
+	// ChangePassword is sent with the new password, as unlock is with the current one
+	ChangePassword []byte
+}
+
+// SetExtendedOpcodes gives the opcodes of the commands that are not in the baseline command table
+func (ub *UbloxBluetooth) SetExtendedOpcodes(o ExtendedOpcodes) {
+	ub.opcodes = o
+}
+
+// opcodeReply is the start of the reply to `opcode`
+func opcodeReply(opcode []byte) string {
+	return fmt.Sprintf("%02X", opcode[0])
+}
+
 // UnlockDevice attempts to unlock the device with the password provided.
 func (ub *UbloxBluetooth) UnlockDevice(password []byte) (bool, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return false, fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, append(unlockCommand, password...)), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, append(unlockCommand, password...)), true)
 	if err != nil {
 		return false, errors.Wrapf(err, "UnlockDevice error")
 	}
@@ -40,13 +68,52 @@ func (ub *UbloxBluetooth) UnlockDevice(password []byte) (bool, error) {
 	return ProcessUnlockReply(d)
 }
 
+// Unlock unlocks the connected device with its password from `creds`
+func (ub *UbloxBluetooth) Unlock(creds CredentialProvider) (bool, error) {
+	cr := ub.connection()
+	if cr == nil {
+		return false, fmt.Errorf("ConnectionReply is nil")
+	}
+
+	password, err := creds.Password(cr.BluetoothAddress)
+	if err != nil {
+		return false, errors.Wrap(err, "Unlock error")
+	}
+	return ub.UnlockDevice(password)
+}
+
+// ChangePassword sets a new password on the connected device, which must already be unlocked.
+// The new password is used from the next connection.
+//
+// ChangePassword is experimental: the sensor's command table has no change password opcode, so
+// it returns ErrUnknownOpcode unless SetExtendedOpcodes has given one, and the payload is
+// untested against a sensor.
+func (ub *UbloxBluetooth) ChangePassword(password []byte) error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+	opcode := ub.opcodes.ChangePassword
+	if opcode == nil {
+		return errors.Wrap(ErrUnknownOpcode, "ChangePassword error")
+	}
+
+	command := append(append([]byte{}, opcode...), password...)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, command), true)
+	if err != nil {
+		return errors.Wrap(err, "ChangePassword error")
+	}
+	return ProcessChangePasswordReply(d, opcode)
+}
+
 // GetVersion request the connected device's version
 func (ub *UbloxBluetooth) GetVersion() (*VersionReply, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return nil, fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, versionCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, versionCommand), true)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GetVersion error")
 	}
@@ -55,11 +122,12 @@ func (ub *UbloxBluetooth) GetVersion() (*VersionReply, error) {
 
 // GetInfo requests the current device info.
 func (ub *UbloxBluetooth) GetInfo() (*InfoReply, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return nil, fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, infoCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, infoCommand), true)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GetInfo error")
 	}
@@ -67,13 +135,37 @@ func (ub *UbloxBluetooth) GetInfo() (*InfoReply, error) {
 	return NewInfoReply(d)
 }
 
+// SetTime sets the device's clock to `t`, which is sent as seconds since the Unix epoch.
+//
+// SetTime is experimental: the sensor's command table has no set time opcode, so it returns
+// ErrUnknownOpcode unless SetExtendedOpcodes has given one, and the payload is untested against
+// a sensor.
+func (ub *UbloxBluetooth) SetTime(t time.Time) error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+	opcode := ub.opcodes.SetTime
+	if opcode == nil {
+		return errors.Wrap(ErrUnknownOpcode, "SetTime error")
+	}
+
+	seconds := uint32ToString(uint32(t.Unix()))
+	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, opcode, seconds), true)
+	if err != nil {
+		return errors.Wrap(err, "SetTime error")
+	}
+	return ProcessSetTimeReply(d, opcode)
+}
+
 // ReadConfig requests the device's current config
 func (ub *UbloxBluetooth) ReadConfig() (*ConfigReply, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return nil, fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, readConfigCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, readConfigCommand), true)
 	if err != nil {
 		return nil, errors.Wrapf(err, "ReadConfig error")
 	}
@@ -82,23 +174,25 @@ func (ub *UbloxBluetooth) ReadConfig() (*ConfigReply, error) {
 
 // WriteConfig sends the passed config to the device
 func (ub *UbloxBluetooth) WriteConfig(cfg *ConfigReply) error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
 	configData := cfg.ByteArray()
-	_, err := ub.writeAndWait(WriteCharacteristicHexCommand(ub.connectedDevice.Handle, commandValueHandle, writeConfigCommand, configData), true)
+	_, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, writeConfigCommand, configData), true)
 	return err
 }
 
 // ReadName messages the remote device to get its set name
 func (ub *UbloxBluetooth) ReadName() (string, error) {
 	name := ""
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return name, fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, readNameCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, readNameCommand), true)
 	if err != nil {
 		return name, errors.Wrapf(err, "readNameCommand error")
 	}
@@ -110,10 +204,11 @@ func (ub *UbloxBluetooth) ReadName() (string, error) {
 func (ub *UbloxBluetooth) WriteName(name string) error {
 	stringBytes := fmt.Sprintf("%x", name)
 
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
-	_, err := ub.writeAndWait(WriteCharacteristicHexCommand(ub.connectedDevice.Handle, commandValueHandle, writeNameCommand, stringBytes), true)
+	_, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, writeNameCommand, stringBytes), true)
 	if err != nil {
 		return errors.Wrapf(err, "writeNameCommand error")
 	}
@@ -123,39 +218,35 @@ func (ub *UbloxBluetooth) WriteName(name string) error {
 // DefaultCredit says that we can handle 16 messages in our FIFO
 const DefaultCredit = 16
 
-var defaultCreditString = uint8ToString(uint8(DefaultCredit))
-var halfwayPoint = DefaultCredit
-
 // SendCredits messages the connected device to say that it can accept `credit` number of messages
 func (ub *UbloxBluetooth) SendCredits(credit int) error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
 	creditHex := uint8ToString(uint8(credit))
-	_, err := ub.writeAndWait(WriteCharacteristicHexCommand(ub.connectedDevice.Handle, commandValueHandle, creditCommand, creditHex), false)
+	_, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, creditCommand, creditHex), false)
 	return err
 }
 
-// DownloadSlotData downloads slot data from
-func (ub *UbloxBluetooth) DownloadSlotData(slot int, slotOffset int, dnh DownloadNotificationHandler, dih DownloadIndicationHandler) error {
-	commandParameters := fmt.Sprintf("%s%s%s", uint16ToString(uint16(slot)), uint16ToString(uint16(slotOffset)), defaultCreditString)
+// ErrAbortDownload is returned by a DownloadNotificationHandler to stop a slot download. The
+// device is sent an abort and DownloadSlotData returns ErrAbortDownload.
+var ErrAbortDownload = fmt.Errorf("download aborted")
 
+// DownloadSlotData downloads slot data from `slotOffset`. Notifications must arrive in order, see
+// DownloadSlot for a download that survives missing notifications and verifies the slot.
+func (ub *UbloxBluetooth) DownloadSlotData(slot int, slotOffset int, dnh DownloadNotificationHandler, dih DownloadIndicationHandler) error {
 	expectedSequence := 0
-	return ub.downloadData(readSlotDataCommand, commandParameters, readSlotDataReply, func(d []byte) error {
-		if d != nil {
-			l := len(d)
-			sequenceNumber := stringToInt(string(d[l-4 : l]))
-			if sequenceNumber != expectedSequence {
-				return fmt.Errorf("sequence number: %d expected %d", sequenceNumber, expectedSequence)
-			}
-
-			err := dnh(d[:l-4])
-			if err != nil {
-				return errors.Wrap(err, "download hander error")
-			}
-			expectedSequence++
+	err := ub.downloadSlotNotifications(slot, slotOffset, func(sequenceNumber int, d []byte) error {
+		if sequenceNumber != expectedSequence {
+			return fmt.Errorf("sequence number: %d expected %d", sequenceNumber, expectedSequence)
 		}
+		err := dnh(d)
+		if err != nil {
+			return errors.Wrap(err, "download hander error")
+		}
+		expectedSequence++
 		return nil
 	}, func(s []byte) error {
 		if bytes.HasPrefix(s, readSlotDataReplyBytes) {
@@ -163,11 +254,15 @@ func (ub *UbloxBluetooth) DownloadSlotData(slot int, slotOffset int, dnh Downloa
 		}
 		return fmt.Errorf("[DownloadSlotData] indication %s does not start with %s", s, readSlotDataReply)
 	})
+	if err != nil && err != ErrAbortDownload {
+		return errors.Wrap(err, "[DownloadSlotData] error")
+	}
+	return err
 }
 
 // DownloadEventLog requests a number of log records to be downloaded.
 func (ub *UbloxBluetooth) DownloadEventLog(startingIndex int, fn DownloadNotificationHandler) error {
-	commandParameters := fmt.Sprintf("%s%s", uint16ToString(uint16(startingIndex)), defaultCreditString)
+	commandParameters := fmt.Sprintf("%s%s", uint16ToString(uint16(startingIndex)), ub.initialCreditString())
 	return ub.downloadData(readEventLogCommand, commandParameters, readEventLogReply, fn, func(d []byte) error {
 		if bytes.HasPrefix(d, readEventLogReplyBytes) {
 			return nil
@@ -177,11 +272,12 @@ func (ub *UbloxBluetooth) DownloadEventLog(startingIndex int, fn DownloadNotific
 }
 
 func (ub *UbloxBluetooth) downloadData(command []byte, commandParameters string, reply string, dnh DownloadNotificationHandler, dih func([]byte) error) error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(ub.connectedDevice.Handle, commandValueHandle, command, commandParameters), true)
+	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, command, commandParameters), true)
 	if err != nil {
 		return errors.Wrap(err, "[downloadData] Command error")
 	}
@@ -195,11 +291,12 @@ func (ub *UbloxBluetooth) downloadData(command []byte, commandParameters string,
 
 // ClearEventLog requests that the event log of the connected device be cleared.
 func (ub *UbloxBluetooth) ClearEventLog() error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, clearEventLogCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, clearEventLogCommand), true)
 	if err != nil {
 		return errors.Wrap(err, "ClearEventLog error")
 	}
@@ -208,21 +305,37 @@ func (ub *UbloxBluetooth) ClearEventLog() error {
 
 // AbortEventLogRead aborts the read
 func (ub *UbloxBluetooth) AbortEventLogRead() error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	_, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, abortCommand), false)
+	_, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, abortCommand), false)
 	return err
 }
 
+// AbortSlotRead stops a slot download started by DownloadSlotData
+func (ub *UbloxBluetooth) AbortSlotRead() error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, abortCommand), true)
+	if err != nil {
+		return errors.Wrap(err, "AbortSlotRead error")
+	}
+	return ProcessAbortReply(d)
+}
+
 // ReadSlotCount get recorder slot count
 func (ub *UbloxBluetooth) ReadSlotCount() (*SlotCountReply, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return nil, fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, readSlotCountCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, readSlotCountCommand), true)
 	if err != nil {
 		return nil, errors.Wrap(err, "ReadSlotCount error")
 	}
@@ -231,12 +344,13 @@ func (ub *UbloxBluetooth) ReadSlotCount() (*SlotCountReply, error) {
 
 // ReadSlotInfo get recorder's slot info for the provided slotNumber, returns a SlotInfoReply structure or an error
 func (ub *UbloxBluetooth) ReadSlotInfo(slotNumber int) (*SlotInfoReply, error) {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return nil, fmt.Errorf("ConnectionReply is nil")
 	}
 
 	slot := uint16ToString(uint16(slotNumber))
-	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(ub.connectedDevice.Handle, commandValueHandle, readSlotInfoCommand, slot), true)
+	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, readSlotInfoCommand, slot), true)
 	if err != nil {
 		return nil, err
 	}
@@ -245,13 +359,66 @@ func (ub *UbloxBluetooth) ReadSlotInfo(slotNumber int) (*SlotInfoReply, error) {
 
 // EraseSlotData requests that the device erases its slots...
 func (ub *UbloxBluetooth) EraseSlotData() error {
-	if ub.connectedDevice == nil {
+	cr := ub.connection()
+	if cr == nil {
 		return fmt.Errorf("ConnectionReply is nil")
 	}
 
-	d, err := ub.writeAndWait(WriteCharacteristicCommand(ub.connectedDevice.Handle, commandValueHandle, eraseSlotCommand), true)
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, eraseSlotCommand), true)
 	if err != nil {
 		return errors.Wrap(err, "EraseSlotData error")
 	}
 	return ProcessEraseSlotDataReply(d)
 }
+
+// RebootSensor reboots the connected device. The device drops the connection as it restarts, so
+// this returns once the disconnect has been seen, leaving no device connected and the
+// disconnect handler passed to ConnectToDevice uncalled.
+func (ub *UbloxBluetooth) RebootSensor() error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+
+	ub.expectDisconnect(true)
+	err := ub.Write(WriteCharacteristicCommand(cr.Handle, commandValueHandle, rebootCommand).Cmd)
+	if err != nil {
+		ub.expectDisconnect(false)
+		return errors.Wrap(err, "RebootSensor error")
+	}
+
+	err = ub.waitForReboot()
+	if err != nil {
+		ub.expectDisconnect(false)
+		return errors.Wrap(err, "RebootSensor error")
+	}
+	ub.clearConnection()
+	return nil
+}
+
+// waitForReboot takes the OK to the reboot write and the reboot reply, which may be lost as the
+// device restarts, until the disconnect that matters
+func (ub *UbloxBluetooth) waitForReboot() error {
+	deadline := time.After(ub.timeout)
+	for {
+		select {
+		case d := <-ub.DataChannel:
+			if bytes.HasPrefix(d, disconnectResponse) {
+				return nil
+			}
+			if bytes.HasPrefix(d, gattIndicationResponse) {
+				err := ProcessRebootReply(d)
+				if err != nil {
+					return err
+				}
+			}
+		case <-ub.CompletedChannel:
+		case e := <-ub.ErrorChannel:
+			return e
+		case <-ub.detachment():
+			return ErrDeviceGone
+		case <-deadline:
+			return fmt.Errorf("Timeout")
+		}
+	}
+}
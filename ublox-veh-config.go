@@ -0,0 +1,220 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SensorMode is the sensor's operating state, held in ConfigReply.State. The values are assumed,
+// they have not been checked against the sensor firmware.
+type SensorMode int
+
+const (
+	// SensorModeStandby logs events but does not record slots
+	SensorModeStandby SensorMode = iota
+	// SensorModeActive samples and records slots
+	SensorModeActive
+	// SensorModeShipping is the low power state sensors are delivered in
+	SensorModeShipping
+)
+
+func (m SensorMode) String() string {
+	switch m {
+	case SensorModeStandby:
+		return "standby"
+	case SensorModeActive:
+		return "active"
+	case SensorModeShipping:
+		return "shipping"
+	}
+	return fmt.Sprintf("SensorMode(%d)", int(m))
+}
+
+// ParseSensorMode returns the mode with the name `s`, as given by SensorMode.String
+func ParseSensorMode(s string) (SensorMode, error) {
+	for m := SensorModeStandby; m <= SensorModeShipping; m++ {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("[ParseSensorMode] unknown mode %q", s)
+}
+
+// Accelerometer settings bitfield, held in ConfigReply.AccelSettings. The layout, bits 0-1 for
+// the range and bits 4-7 for the output data rate, is assumed and has not been checked against
+// the sensor firmware.
+const (
+	accelRangeMask  = 0x03
+	accelRateShift  = 4
+	accelRateMask   = 0x0F
+	accelKnownBits  = accelRangeMask | accelRateMask<<accelRateShift
+	tempOffsetScale = 10
+)
+
+// AccelRanges are the accelerometer full scale ranges in g, indexed by their bitfield code.
+// The table is assumed, it has not been checked against the sensor firmware.
+var AccelRanges = []int{2, 4, 8, 16}
+
+// AccelDataRates are the accelerometer output data rates in Hz, indexed by their bitfield code.
+// The table is assumed, it has not been checked against the sensor firmware.
+var AccelDataRates = []int{0, 1, 10, 25, 50, 100, 200, 400}
+
+// UnknownAccelDataRate is the AccelDataRate of a config read with a rate code that is not in
+// AccelDataRates. Left alone, the code is written back unchanged.
+const UnknownAccelDataRate = -1
+
+// Limits on the SensorConfig values, assumed rather than taken from the sensor firmware
+const (
+	MinAdvertisingInterval = 20 * time.Millisecond
+	MaxAdvertisingInterval = 10240 * time.Millisecond
+	MinSampleInterval      = time.Second
+	MaxSampleInterval      = math.MaxUint16 * time.Second
+	MaxTemperatureOffset   = 20.0
+)
+
+// ErrConfigNotApplied is returned when the config read back after a write differs from the one written
+var ErrConfigNotApplied = fmt.Errorf("sensor config was not applied")
+
+// SensorConfig is a ConfigReply in real units. The units, milliseconds for the advertising
+// interval and tenths of a degree for the temperature offset, are assumed and have not been
+// checked against the sensor firmware. ConfigReply and WriteConfig carry the raw record
+// without these checks.
+type SensorConfig struct {
+	// AdvertisingInterval is in whole milliseconds
+	AdvertisingInterval time.Duration
+	// SampleInterval is in whole seconds
+	SampleInterval time.Duration
+	Mode           SensorMode
+	// AccelRange is the accelerometer's full scale in g, one of AccelRanges
+	AccelRange int
+	// AccelDataRate is in Hz, one of AccelDataRates, zero powers the accelerometer down
+	AccelDataRate int
+	// accelRateCode is the rate code read when AccelDataRate is UnknownAccelDataRate
+	accelRateCode    int
+	hasAccelRateCode bool
+	// AccelReserved holds the accelerometer bits that have no field, so that they survive a write
+	AccelReserved int
+	Spare         int
+	// TemperatureOffset is in °C, to a tenth of a degree
+	TemperatureOffset float64
+}
+
+// SensorConfig converts the raw reply to real units
+func (cr *ConfigReply) SensorConfig() SensorConfig {
+	c := SensorConfig{
+		AdvertisingInterval: time.Duration(cr.AdvertisingInterval) * time.Millisecond,
+		SampleInterval:      time.Duration(cr.SampleTime) * time.Second,
+		Mode:                SensorMode(cr.State),
+		AccelDataRate:       UnknownAccelDataRate,
+		AccelReserved:       cr.AccelSettings &^ accelKnownBits,
+		Spare:               cr.SpareOne,
+		TemperatureOffset:   float64(int16(cr.TemperatureOffset)) / tempOffsetScale,
+	}
+	c.AccelRange = AccelRanges[cr.AccelSettings&accelRangeMask]
+	rateCode := (cr.AccelSettings >> accelRateShift) & accelRateMask
+	if rateCode < len(AccelDataRates) {
+		c.AccelDataRate = AccelDataRates[rateCode]
+	} else {
+		c.accelRateCode = rateCode
+		c.hasAccelRateCode = true
+	}
+	return c
+}
+
+// Validate checks every value is one the sensor can hold
+func (c SensorConfig) Validate() error {
+	if c.AdvertisingInterval < MinAdvertisingInterval || c.AdvertisingInterval > MaxAdvertisingInterval || c.AdvertisingInterval%time.Millisecond != 0 {
+		return fmt.Errorf("[Validate] advertising interval %v must be whole milliseconds from %v to %v", c.AdvertisingInterval, MinAdvertisingInterval, MaxAdvertisingInterval)
+	}
+	if c.SampleInterval < MinSampleInterval || c.SampleInterval > MaxSampleInterval || c.SampleInterval%time.Second != 0 {
+		return fmt.Errorf("[Validate] sample interval %v must be whole seconds from %v to %v", c.SampleInterval, MinSampleInterval, MaxSampleInterval)
+	}
+	if c.Mode < SensorModeStandby || c.Mode > SensorModeShipping {
+		return fmt.Errorf("[Validate] unknown mode %d", int(c.Mode))
+	}
+	if indexOf(AccelRanges, c.AccelRange) < 0 {
+		return fmt.Errorf("[Validate] accelerometer range %dg is not one of %v", c.AccelRange, AccelRanges)
+	}
+	if c.accelRate() < 0 {
+		return fmt.Errorf("[Validate] accelerometer data rate %dHz is not one of %v", c.AccelDataRate, AccelDataRates)
+	}
+	if c.AccelReserved&accelKnownBits != 0 || c.AccelReserved < 0 || c.AccelReserved > math.MaxUint16 {
+		return fmt.Errorf("[Validate] reserved accelerometer bits %#x overlap the range or data rate", c.AccelReserved)
+	}
+	if c.Spare < 0 || c.Spare > math.MaxUint16 {
+		return fmt.Errorf("[Validate] spare %d is out of range", c.Spare)
+	}
+	if math.IsNaN(c.TemperatureOffset) || math.Abs(c.TemperatureOffset) > MaxTemperatureOffset {
+		return fmt.Errorf("[Validate] temperature offset %.1f°C is beyond ±%.0f°C", c.TemperatureOffset, MaxTemperatureOffset)
+	}
+	return nil
+}
+
+// ConfigReply validates the config and converts it to the raw values sent to the sensor
+func (c SensorConfig) ConfigReply() (*ConfigReply, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	accel := c.AccelReserved | indexOf(AccelRanges, c.AccelRange) | c.accelRate()<<accelRateShift
+	return &ConfigReply{
+		AdvertisingInterval: int(c.AdvertisingInterval / time.Millisecond),
+		SampleTime:          int(c.SampleInterval / time.Second),
+		State:               int(c.Mode),
+		AccelSettings:       accel,
+		SpareOne:            c.Spare,
+		TemperatureOffset:   int(uint16(int16(math.Round(c.TemperatureOffset * tempOffsetScale)))),
+	}, nil
+}
+
+// accelRate returns the bitfield code of AccelDataRate, or of the unknown rate that was read,
+// -1 if there is none
+func (c SensorConfig) accelRate() int {
+	if c.AccelDataRate == UnknownAccelDataRate && c.hasAccelRateCode {
+		return c.accelRateCode
+	}
+	return indexOf(AccelDataRates, c.AccelDataRate)
+}
+
+func indexOf(values []int, v int) int {
+	for i, value := range values {
+		if value == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReadSensorConfig reads the connected device's config in real units
+func (ub *UbloxBluetooth) ReadSensorConfig() (*SensorConfig, error) {
+	cr, err := ub.ReadConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "[ReadSensorConfig] error")
+	}
+	c := cr.SensorConfig()
+	return &c, nil
+}
+
+// WriteSensorConfig validates and writes the config, then reads it back to check that the
+// device has applied it
+func (ub *UbloxBluetooth) WriteSensorConfig(c SensorConfig) error {
+	cr, err := c.ConfigReply()
+	if err != nil {
+		return errors.Wrap(err, "[WriteSensorConfig] error")
+	}
+	err = ub.WriteConfig(cr)
+	if err != nil {
+		return errors.Wrap(err, "[WriteSensorConfig] WriteConfig error")
+	}
+	readBack, err := ub.ReadConfig()
+	if err != nil {
+		return errors.Wrap(err, "[WriteSensorConfig] ReadConfig error")
+	}
+	if *readBack != *cr {
+		return errors.Wrapf(ErrConfigNotApplied, "[WriteSensorConfig] wrote %+v read %+v", *cr, *readBack)
+	}
+	return nil
+}
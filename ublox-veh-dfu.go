@@ -0,0 +1,279 @@
+package ubloxbluetooth
+
+import (
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dfuWriteOverhead is the ATT write header, the opcode and the offset sent with each chunk
+const dfuWriteOverhead = 3 + 1 + 4
+
+// DefaultRebootDelay is the wait after a firmware update reboots the sensor before reconnecting
+const DefaultRebootDelay = 5 * time.Second
+
+// maxDFUStalls is the number of acknowledgements in a row that may fail to move the offset on
+const maxDFUStalls = 3
+
+// ErrDFUVerify is returned when the sensor rejects the CRC of the image it received
+var ErrDFUVerify = fmt.Errorf("firmware image failed verification")
+
+// ErrDFUVersion is returned when the sensor does not report the expected version after the update
+var ErrDFUVersion = fmt.Errorf("unexpected firmware version after update")
+
+// DFUTarget is the part of UbloxBluetooth that UpdateFirmware uses, on a connected and unlocked sensor
+type DFUTarget interface {
+	// DFUStart announces an image, returning the offset the sensor already holds from an earlier attempt
+	DFUStart(size int, crc uint32) (int, error)
+	// DFUWrite sends a chunk of the image, returning the offset the sensor expects next
+	DFUWrite(offset int, chunk []byte) (int, error)
+	// DFUVerify asks the sensor to check the image against the CRC from DFUStart
+	DFUVerify() error
+	RebootSensor() error
+	GetVersion() (*VersionReply, error)
+	// ReadMTU sizes the chunks, each DFUWrite must fit in one write
+	ReadMTU() (int, error)
+	Connected() bool
+	DisconnectFromDevice() error
+}
+
+// DFUStage is the step a firmware update has reached
+type DFUStage int
+
+const (
+	// DFUSending is streaming the image
+	DFUSending DFUStage = iota
+	// DFUVerifying is waiting for the sensor to check the image's CRC
+	DFUVerifying
+	// DFURebooting is restarting the sensor onto the new image
+	DFURebooting
+	// DFUComplete is when the sensor reports its new version
+	DFUComplete
+)
+
+// DFUProgress is reported as a firmware update proceeds
+type DFUProgress struct {
+	Stage   DFUStage
+	Sent    int
+	Total   int
+	Resumes int
+}
+
+// DFUProgressHandler is called with the progress of a firmware update
+type DFUProgressHandler func(p DFUProgress)
+
+// DFUOptions configure UpdateFirmware
+type DFUOptions struct {
+	// ChunkSize limits the image bytes in each DFU write, which are otherwise as many as the
+	// connection's MTU allows
+	ChunkSize int
+	// Reconnect connects to and unlocks the sensor again after a disconnect or the reboot
+	Reconnect func() error
+	// MaxResumes is the number of reconnects allowed while sending, and the number of retries
+	// of the reconnect after the reboot
+	MaxResumes int
+	// RebootDelay defaults to DefaultRebootDelay
+	RebootDelay time.Duration
+	// Version, when set, must match the SoftwareVersion the sensor reports after the update
+	Version  string
+	Progress DFUProgressHandler
+}
+
+// UpdateFirmware sends `image` to the sensor, has the sensor verify its CRC, reboots it and reads
+// back its version. A failure while sending is resumed from the offset the sensor holds, after
+// calling opts.Reconnect, unless it is ErrUnknownOpcode or ErrDFUVerify.
+//
+// UpdateFirmware is experimental, as are the DFU commands it uses.
+func UpdateFirmware(target DFUTarget, image []byte, opts DFUOptions) (*VersionReply, error) {
+	if len(image) == 0 {
+		return nil, fmt.Errorf("[UpdateFirmware] image is empty")
+	}
+	if opts.Reconnect == nil {
+		return nil, fmt.Errorf("[UpdateFirmware] Reconnect is required to reach the sensor after its reboot")
+	}
+	if opts.RebootDelay <= 0 {
+		opts.RebootDelay = DefaultRebootDelay
+	}
+
+	p := &DFUProgress{Total: len(image)}
+	report := func(stage DFUStage) {
+		p.Stage = stage
+		if opts.Progress != nil {
+			opts.Progress(*p)
+		}
+	}
+
+	crc := crc32.ChecksumIEEE(image)
+	for {
+		err := sendFirmware(target, image, crc, opts.ChunkSize, p, report)
+		if err == nil {
+			break
+		}
+		cause := errors.Cause(err)
+		if cause == ErrDFUVerify || cause == ErrUnknownOpcode || p.Resumes >= opts.MaxResumes {
+			return nil, errors.Wrap(err, "[UpdateFirmware] error")
+		}
+		p.Resumes++
+		if target.Connected() {
+			// a stall leaves the link up, and the radio would not connect again while it is
+			err = target.DisconnectFromDevice()
+			if err != nil {
+				return nil, errors.Wrap(err, "[UpdateFirmware] DisconnectFromDevice error")
+			}
+		}
+		err = opts.Reconnect()
+		if err != nil {
+			return nil, errors.Wrap(err, "[UpdateFirmware] Reconnect error")
+		}
+	}
+
+	report(DFURebooting)
+	err := target.RebootSensor()
+	if err != nil {
+		return nil, errors.Wrap(err, "[UpdateFirmware] RebootSensor error")
+	}
+	for attempt := 0; ; attempt++ {
+		time.Sleep(opts.RebootDelay)
+		err = opts.Reconnect()
+		if err == nil {
+			break
+		}
+		if attempt >= opts.MaxResumes {
+			return nil, errors.Wrap(err, "[UpdateFirmware] Reconnect after reboot error")
+		}
+	}
+
+	version, err := target.GetVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "[UpdateFirmware] GetVersion error")
+	}
+	if opts.Version != "" && version.SoftwareVersion != opts.Version {
+		return version, errors.Wrapf(ErrDFUVersion, "[UpdateFirmware] expected %s got %s", opts.Version, version.SoftwareVersion)
+	}
+	report(DFUComplete)
+	return version, nil
+}
+
+// sendFirmware streams the image from the offset the sensor asks for, then has it verified
+func sendFirmware(target DFUTarget, image []byte, crc uint32, chunkSize int, p *DFUProgress, report func(DFUStage)) error {
+	mtu, err := target.ReadMTU()
+	if err != nil {
+		return errors.Wrap(err, "ReadMTU error")
+	}
+	if mtu <= dfuWriteOverhead {
+		return fmt.Errorf("MTU %d leaves no room for image bytes", mtu)
+	}
+	if chunkSize <= 0 || chunkSize > mtu-dfuWriteOverhead {
+		chunkSize = mtu - dfuWriteOverhead
+	}
+
+	offset, err := target.DFUStart(len(image), crc)
+	if err != nil {
+		return errors.Wrap(err, "DFUStart error")
+	}
+
+	stalls := 0
+	for offset < len(image) {
+		if offset < 0 {
+			return fmt.Errorf("sensor asked for offset %d of %d", offset, len(image))
+		}
+		p.Sent = offset
+		report(DFUSending)
+
+		end := offset + chunkSize
+		if end > len(image) {
+			end = len(image)
+		}
+		next, err := target.DFUWrite(offset, image[offset:end])
+		if err != nil {
+			return errors.Wrapf(err, "DFUWrite at %d error", offset)
+		}
+		if next > len(image) {
+			return fmt.Errorf("sensor acknowledged offset %d of %d", next, len(image))
+		}
+		if next <= offset {
+			stalls++
+			if stalls > maxDFUStalls {
+				return fmt.Errorf("sensor stalled at offset %d", offset)
+			}
+		} else {
+			stalls = 0
+		}
+		offset = next
+	}
+	p.Sent = len(image)
+	report(DFUVerifying)
+
+	err = target.DFUVerify()
+	if err != nil {
+		return errors.Wrap(err, "DFUVerify error")
+	}
+	return nil
+}
+
+// DFUStart announces a firmware image of `size` bytes with the CRC-32 (IEEE) `crc`. The sensor
+// replies with the offset it already holds when the image matches an interrupted update.
+//
+// The DFU commands are experimental: the sensor's command table has no DFU opcodes, so they
+// return ErrUnknownOpcode unless SetExtendedOpcodes has given them, and their payloads are
+// untested against a sensor.
+func (ub *UbloxBluetooth) DFUStart(size int, crc uint32) (int, error) {
+	cr := ub.connection()
+	if cr == nil {
+		return 0, fmt.Errorf("ConnectionReply is nil")
+	}
+	opcode := ub.opcodes.DFUStart
+	if opcode == nil {
+		return 0, errors.Wrap(ErrUnknownOpcode, "DFUStart error")
+	}
+
+	params := uint32ToString(uint32(size)) + uint32ToString(crc)
+	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, opcode, params), true)
+	if err != nil {
+		return 0, errors.Wrap(err, "DFUStart error")
+	}
+	return ProcessDFUStartReply(d, opcode)
+}
+
+// DFUWrite sends the image bytes at `offset`, returning the offset the sensor expects next.
+// It is experimental, as DFUStart.
+func (ub *UbloxBluetooth) DFUWrite(offset int, chunk []byte) (int, error) {
+	cr := ub.connection()
+	if cr == nil {
+		return 0, fmt.Errorf("ConnectionReply is nil")
+	}
+
+	opcode := ub.opcodes.DFUData
+	if opcode == nil {
+		return 0, errors.Wrap(ErrUnknownOpcode, "DFUWrite error")
+	}
+
+	params := fmt.Sprintf("%s%x", uint32ToString(uint32(offset)), chunk)
+	d, err := ub.writeAndWait(WriteCharacteristicHexCommand(cr.Handle, commandValueHandle, opcode, params), true)
+	if err != nil {
+		return 0, errors.Wrap(err, "DFUWrite error")
+	}
+	return ProcessDFUDataReply(d, opcode)
+}
+
+// DFUVerify asks the sensor to check the image it holds against the CRC sent with DFUStart.
+// It is experimental, as DFUStart.
+func (ub *UbloxBluetooth) DFUVerify() error {
+	cr := ub.connection()
+	if cr == nil {
+		return fmt.Errorf("ConnectionReply is nil")
+	}
+
+	opcode := ub.opcodes.DFUVerify
+	if opcode == nil {
+		return errors.Wrap(ErrUnknownOpcode, "DFUVerify error")
+	}
+
+	d, err := ub.writeAndWait(WriteCharacteristicCommand(cr.Handle, commandValueHandle, opcode), true)
+	if err != nil {
+		return errors.Wrap(err, "DFUVerify error")
+	}
+	return ProcessDFUVerifyReply(d, opcode)
+}
@@ -0,0 +1,237 @@
+package ubloxbluetooth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSlotSize is returned when a complete slot download holds a different number of bytes or
+// dwords to the slot's info
+var ErrSlotSize = fmt.Errorf("slot size mismatch")
+
+// SlotSummary is the terminating indication of a slot download
+type SlotSummary struct {
+	Status string
+	// Notifications is the number of notifications in the whole slot
+	Notifications int
+}
+
+// SequenceRange is a range of notification sequence numbers from Start up to, but not including,
+// End. An End of -1 runs to the end of the slot.
+type SequenceRange struct {
+	Start int
+	End   int
+}
+
+func (r SequenceRange) String() string {
+	if r.End < 0 {
+		return fmt.Sprintf("%d-end", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End-1)
+}
+
+// IncompleteDownloadError is returned when notifications of a slot are missing. Passing the same
+// SlotDownload to DownloadSlot again downloads the slot from its start until the Missing ranges
+// are covered, the notifications already received are kept rather than passed on again.
+type IncompleteDownloadError struct {
+	Slot     int
+	Missing  []SequenceRange
+	Received int
+	// Expected is -1 when the download ended before the sensor said how many to expect
+	Expected int
+	// Err is the error that ended the download early, if any
+	Err error
+}
+
+func (e *IncompleteDownloadError) Error() string {
+	ranges := make([]string, len(e.Missing))
+	for i, r := range e.Missing {
+		ranges[i] = r.String()
+	}
+	s := fmt.Sprintf("slot %d download incomplete, received %d of %d notifications, missing %s", e.Slot, e.Received, e.Expected, strings.Join(ranges, ","))
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// IsIncompleteDownload returns the IncompleteDownloadError in `err`'s cause, or nil
+func IsIncompleteDownload(err error) *IncompleteDownloadError {
+	incomplete, _ := errors.Cause(err).(*IncompleteDownloadError)
+	return incomplete
+}
+
+// SlotDownload collects a slot's notifications by sequence number, so that a download that
+// misses some can be completed by downloading the slot again
+type SlotDownload struct {
+	Slot int
+	// Info, when set, is checked against the bytes downloaded
+	Info    *SlotInfoReply
+	Summary *SlotSummary
+	chunks  map[int][]byte
+}
+
+// NewSlotDownload starts the download of `slot`, described by `info`
+func NewSlotDownload(slot int, info *SlotInfoReply) *SlotDownload {
+	return &SlotDownload{Slot: slot, Info: info, chunks: map[int][]byte{}}
+}
+
+// Add records the payload of notification `sequence`, a repeat replaces the earlier one
+func (sd *SlotDownload) Add(sequence int, payload []byte) {
+	sd.chunks[sequence] = append([]byte{}, payload...)
+}
+
+// Received is the number of distinct notifications received
+func (sd *SlotDownload) Received() int {
+	return len(sd.chunks)
+}
+
+// Missing returns the ranges of notifications not yet received. Until the summary has been
+// received the slot's length is unknown, so the last range runs to the end.
+func (sd *SlotDownload) Missing() []SequenceRange {
+	sequences := make([]int, 0, len(sd.chunks))
+	for seq := range sd.chunks {
+		sequences = append(sequences, seq)
+	}
+	sort.Ints(sequences)
+
+	missing := []SequenceRange{}
+	next := 0
+	for _, seq := range sequences {
+		if sd.Summary != nil && seq >= sd.Summary.Notifications {
+			break
+		}
+		if seq > next {
+			missing = append(missing, SequenceRange{Start: next, End: seq})
+		}
+		next = seq + 1
+	}
+	if sd.Summary == nil {
+		missing = append(missing, SequenceRange{Start: next, End: -1})
+	} else if next < sd.Summary.Notifications {
+		missing = append(missing, SequenceRange{Start: next, End: sd.Summary.Notifications})
+	}
+	return missing
+}
+
+// Payload returns the notification payloads in sequence order, as DownloadSlotData passes them
+func (sd *SlotDownload) Payload() []byte {
+	sequences := make([]int, 0, len(sd.chunks))
+	for seq := range sd.chunks {
+		sequences = append(sequences, seq)
+	}
+	sort.Ints(sequences)
+
+	p := []byte{}
+	for _, seq := range sequences {
+		p = append(p, sd.chunks[seq]...)
+	}
+	return p
+}
+
+// Verify checks that every notification has been received, and that the slot's byte and dword
+// totals match its info
+func (sd *SlotDownload) Verify() error {
+	return sd.verify(nil)
+}
+
+func (sd *SlotDownload) verify(cause error) error {
+	missing := sd.Missing()
+	if len(missing) > 0 {
+		expected := -1
+		if sd.Summary != nil {
+			expected = sd.Summary.Notifications
+		}
+		return &IncompleteDownloadError{Slot: sd.Slot, Missing: missing, Received: sd.Received(), Expected: expected, Err: cause}
+	}
+	if cause != nil {
+		return cause
+	}
+
+	data, err := hex.DecodeString(string(sd.Payload()))
+	if err != nil {
+		return errors.Wrapf(err, "[Verify] slot %d payload is not hex", sd.Slot)
+	}
+	if sd.Info == nil {
+		return nil
+	}
+	if len(data) != sd.Info.Bytes {
+		return errors.Wrapf(ErrSlotSize, "[Verify] slot %d has %d bytes, its info gives %d", sd.Slot, len(data), sd.Info.Bytes)
+	}
+	if sd.Info.Dwords > 0 && (len(data)%4 != 0 || len(data)/4 != sd.Info.Dwords) {
+		return errors.Wrapf(ErrSlotSize, "[Verify] slot %d has %d bytes, its info gives %d dwords", sd.Slot, len(data), sd.Info.Dwords)
+	}
+	return nil
+}
+
+// DownloadSlot downloads the slot until `sd` has every notification, then verifies the slot.
+// A download that ends early returns an *IncompleteDownloadError. Calling DownloadSlot again
+// with the same `sd` resumes it, downloading from the start of the slot, as the sensor cannot
+// be asked for a range of notifications, and stopping once the missing ones have arrived.
+func (ub *UbloxBluetooth) DownloadSlot(sd *SlotDownload) error {
+	missing := sd.Missing()
+	if len(missing) > 0 {
+		err := ub.downloadSlotMissing(sd, missing[len(missing)-1])
+		if err != nil {
+			return errors.Wrap(sd.verify(err), "[DownloadSlot] error")
+		}
+	}
+	return sd.Verify()
+}
+
+// downloadSlotMissing downloads the slot into `sd`, aborting once `last`, the last missing range,
+// is covered. Notifications are numbered from the start of each download and the units of the
+// slot offset are not specified, so a resume downloads from the start of the slot too.
+func (ub *UbloxBluetooth) downloadSlotMissing(sd *SlotDownload, last SequenceRange) error {
+	err := ub.downloadSlotNotifications(sd.Slot, 0, func(sequence int, payload []byte) error {
+		sd.Add(sequence, payload)
+		if last.End >= 0 && sequence+1 >= last.End && sd.Summary != nil {
+			return ErrAbortDownload
+		}
+		return nil
+	}, func(d []byte) error {
+		summary, err := ProcessSlotDataIndication(d)
+		if err != nil {
+			return err
+		}
+		sd.Summary = summary
+		return nil
+	})
+	if err == ErrAbortDownload {
+		return nil
+	}
+	return err
+}
+
+// downloadSlotNotifications downloads a slot from `slotOffset`, passing each notification to
+// `fn` with its sequence number, counted from 0 in each download, and the terminating indication
+// to `dih`. ErrAbortDownload from either handler sends the sensor an abort and is returned.
+// The sequence number has four hex digits, so it would wrap after 65536 notifications. It is not
+// unwrapped, as the counts of notifications in the download reply and the terminating
+// indication have four hex digits too, so a download that long cannot be followed anyway.
+func (ub *UbloxBluetooth) downloadSlotNotifications(slot int, slotOffset int, fn func(sequence int, payload []byte) error, dih func([]byte) error) error {
+	commandParameters := fmt.Sprintf("%s%s%s", uint16ToString(uint16(slot)), uint16ToString(uint16(slotOffset)), ub.initialCreditString())
+
+	err := ub.downloadData(readSlotDataCommand, commandParameters, readSlotDataReply, func(d []byte) error {
+		if d == nil {
+			return nil
+		}
+		l := len(d)
+		if l < 4 {
+			return fmt.Errorf("notification %q is too short", d)
+		}
+		return fn(stringToInt(string(d[l-4:l])), d[:l-4])
+	}, dih)
+	if errors.Cause(err) == ErrAbortDownload {
+		abortErr := ub.AbortSlotRead()
+		if abortErr != nil {
+			return errors.Wrap(abortErr, "AbortSlotRead error")
+		}
+		return ErrAbortDownload
+	}
+	return err
+}